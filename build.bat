@@ -1,5 +1,5 @@
 
 goversioninfo -icon=assets/rof2plus.ico -manifest=rof2plus.exe.manifest -o=rsrc.syso versioninfo.json
-go build -ldflags "-s -w -X main.Version=0.1.1.2" || exit /b
+go build -ldflags "-s -w -X main.Version=0.1.1.2 -X github.com/xackery/rof2plus/selfupdate.ReleaseKey=%ROF2PLUS_RELEASE_KEY%" || exit /b
 move rof2plus.exe bin/rof2plus.exe || exit /b
 cd bin || exit /b
\ No newline at end of file
@@ -5,10 +5,13 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"path/filepath"
 	"sort"
+	"strings"
 	"sync"
 
 	"github.com/xackery/rof2plus/checksum"
+	"github.com/xackery/rof2plus/pfs"
 )
 
 var (
@@ -50,85 +53,119 @@ type Summary struct {
 	Error      SummaryError
 	Directions string
 	Client     checksum.ChecksumClient
+	// Entries are the problems found inside a failed pfs archive
+	Entries []*pfs.EntryError
 }
 
 func (e *Summary) String() string {
 	return fmt.Sprintf("%s: %s", e.Path, e.Directions)
 }
 
-// Check checks the path.
+// Options describes what to check
+type Options struct {
+	// Client the files belong to, reported in each summary
+	Client checksum.ChecksumClient
+	// Path is the client directory to check
+	Path string
+	// Manifest is checked when set, otherwise the checksum package's list for Client is used
+	Manifest map[string]*checksum.ChecksumEntry
+	// ReferencePath is a vanilla client that failed archives are compared to, to tell which entries were replaced
+	ReferencePath string
+}
+
+// Check checks the path and keeps the result for Report.
 func Check(client checksum.ChecksumClient, rootPath string) error {
+	mux.Lock()
+	ctx, cancel = context.WithCancel(context.Background())
+	runCtx := ctx
+	mux.Unlock()
+
+	defer Close()
+
+	result, err := Run(runCtx, Options{Client: client, Path: rootPath})
+	if err != nil {
+		return err
+	}
+
+	mux.Lock()
+	report = result
+	mux.Unlock()
+	return nil
+}
 
+// Run checks opts.Path and returns the report, independent of any other check
+func Run(ctx context.Context, opts Options) (*ReportDetail, error) {
+	rootPath := opts.Path
 	if rootPath == "" {
-		return fmt.Errorf("path is empty")
+		return nil, fmt.Errorf("path is empty")
 	}
 
 	fi, err := os.Stat(rootPath)
 	if err != nil {
 		if os.IsNotExist(err) {
-			return fmt.Errorf("path does not exist: %w", err)
+			return nil, fmt.Errorf("path does not exist: %w", err)
 		}
-		return fmt.Errorf("stat path: %w", err)
+		return nil, fmt.Errorf("stat path: %w", err)
 	}
 	if !fi.IsDir() {
-		return fmt.Errorf("path is not a directory")
+		return nil, fmt.Errorf("path is not a directory")
 	}
 
 	// start := time.Now()
 	// defer func() {
 	// 	fmt.Printf("Check took %0.2fs seconds\n", time.Since(start).Seconds())
 	// }()
-	mux.Lock()
-	ctx, cancel = context.WithCancel(context.Background())
-	mux.Unlock()
 
-	defer Close()
+	client := opts.Client
+	checksums := opts.Manifest
+	if checksums == nil {
+		chk, err := checksum.ByClient(client)
+		if err != nil {
+			return nil, fmt.Errorf("checksum byclient %s: %w", client.String(), err)
+		}
+		checksums = map[string]*checksum.ChecksumEntry{}
+		for k, v := range chk {
+			// sizes and hashes follow the checksum package's precedence
+			checksums[k] = &checksum.ChecksumEntry{
+				IsDeleted: v.IsDeleted,
+				Path:      v.Path,
+				MD5Hash:   checksum.MD5Hash(client, k),
+				FileSize:  checksum.FileSize(client, k),
+			}
+		}
+	}
 
 	wg := &sync.WaitGroup{}
-	summaryChan := make(chan *Summary, 9999999)
-
-	totalCount := 0
-
-	checksums := map[string]*checksum.ChecksumEntry{}
-
-	chk, err := checksum.ByClient(client)
-	if err != nil {
-		return fmt.Errorf("checksum byclient rof2: %w", err)
-	}
-	for k, v := range chk {
-		checksums[k] = v
-	}
+	summaryChan := make(chan *Summary, len(checksums))
 
 	for filePath, entry := range checksums {
 		wg.Add(1)
-		totalCount++
-
-		go checkPath(wg, summaryChan, client, rootPath, filePath, entry.IsDeleted)
+		go checkPath(ctx, wg, summaryChan, client, rootPath, filePath, entry, opts.ReferencePath)
 	}
 
 	wg.Wait()
-	mux.Lock()
-	defer mux.Unlock()
+	close(summaryChan)
 
-	report = &ReportDetail{
-		FileTotal: totalCount,
+	result := &ReportDetail{
+		FileTotal: len(checksums),
 	}
-
-	for {
-		if len(summaryChan) == 0 {
-			break
-		}
-		summary := <-summaryChan
+	for summary := range summaryChan {
 		if summary.Error != ErrorNone {
-			report.FailTotal++
-			report.Failures = append(report.Failures, summary)
+			result.FailTotal++
+			result.Failures = append(result.Failures, summary)
 			continue
 		}
-		report.OKTotal++
-		report.Successes = append(report.Successes, summary)
+		result.OKTotal++
+		result.Successes = append(result.Successes, summary)
 	}
 
-	return nil
+	sort.Slice(result.Failures, func(i, j int) bool {
+		return result.Failures[i].Path < result.Failures[j].Path
+	})
+	sort.Slice(result.Successes, func(i, j int) bool {
+		return result.Successes[i].Path < result.Successes[j].Path
+	})
+	return result, nil
 }
 
 // Close cancels the check.
@@ -144,25 +181,17 @@ func Close() {
 func Report() *ReportDetail {
 	mux.RLock()
 	defer mux.RUnlock()
-	if report != nil {
-		sort.Slice(report.Failures, func(i, j int) bool {
-			return report.Failures[i].Path < report.Failures[j].Path
-		})
-		sort.Slice(report.Successes, func(i, j int) bool {
-			return report.Successes[i].Path < report.Successes[j].Path
-		})
-	}
 	return report
 }
 
-func checkPath(wg *sync.WaitGroup, summaryChan chan *Summary, client checksum.ChecksumClient, rootPath string, relativePath string, isDeleted bool) {
+func checkPath(ctx context.Context, wg *sync.WaitGroup, summaryChan chan *Summary, client checksum.ChecksumClient, rootPath string, relativePath string, entry *checksum.ChecksumEntry, referencePath string) {
 	defer wg.Done()
 
 	fullPath := fmt.Sprintf("%s/%s", rootPath, relativePath)
 	fi, err := os.Stat(fullPath)
 	if err != nil {
 		if os.IsNotExist(err) {
-			if isDeleted {
+			if entry.IsDeleted {
 				summaryChan <- &Summary{
 					Path:       relativePath,
 					Error:      ErrorNone,
@@ -209,7 +238,7 @@ func checkPath(wg *sync.WaitGroup, summaryChan chan *Summary, client checksum.Ch
 	default:
 	}
 
-	size := checksum.FileSize(client, relativePath)
+	size := entry.FileSize
 	if size == -1 {
 		summaryChan <- &Summary{
 			Path:       relativePath,
@@ -233,15 +262,21 @@ func checkPath(wg *sync.WaitGroup, summaryChan chan *Summary, client checksum.Ch
 				Client:     client,
 				Directions: fmt.Sprintf("MD5 Failure: %v", err),
 			}
+			return
 		}
 
-		if md5 != checksum.MD5Hash(client, relativePath) {
-			summaryChan <- &Summary{
+		if md5 != entry.MD5Hash {
+			summary := &Summary{
 				Path:       relativePath,
 				Error:      ErrorSize,
 				Client:     client,
 				Directions: fmt.Sprintf("MD5 Failure: sizes %d vs %d", size, fi.Size()),
 			}
+			if pfs.IsArchive(relativePath) {
+				inspectArchive(summary, fullPath, referencePath)
+			}
+			summaryChan <- summary
+			return
 		}
 	}
 
@@ -254,3 +289,56 @@ func checkPath(wg *sync.WaitGroup, summaryChan chan *Summary, client checksum.Ch
 		Directions: "OK",
 	}
 }
+
+// inspectArchive adds the entries of a failed archive that are corrupt, or differ from the
+// same archive in referencePath, to summary
+func inspectArchive(summary *Summary, fullPath string, referencePath string) {
+	archive, err := pfs.Open(fullPath)
+	if err != nil {
+		summary.Directions += fmt.Sprintf(", archive is unreadable: %v", err)
+		return
+	}
+	defer archive.Close()
+
+	summary.Entries = archive.Verify()
+	if referencePath != "" {
+		reference, err := pfs.Open(filepath.Join(referencePath, summary.Path))
+		if err == nil {
+			defer reference.Close()
+			diffs, err := pfs.Diff(archive, reference)
+			if err == nil {
+				summary.Entries = mergeEntries(summary.Entries, diffs)
+			}
+		}
+	}
+	if len(summary.Entries) == 0 {
+		return
+	}
+
+	names := []string{}
+	for i, entry := range summary.Entries {
+		if i == 3 {
+			names = append(names, fmt.Sprintf("and %d more", len(summary.Entries)-i))
+			break
+		}
+		names = append(names, entry.Error())
+	}
+	summary.Directions += fmt.Sprintf(", %d entries differ: %s", len(summary.Entries), strings.Join(names, ", "))
+}
+
+// mergeEntries appends problems of entries not already in entries
+func mergeEntries(entries []*pfs.EntryError, problems []*pfs.EntryError) []*pfs.EntryError {
+	for _, problem := range problems {
+		isFound := false
+		for _, entry := range entries {
+			if strings.EqualFold(entry.Name, problem.Name) {
+				isFound = true
+				break
+			}
+		}
+		if !isFound {
+			entries = append(entries, problem)
+		}
+	}
+	return entries
+}
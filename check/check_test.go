@@ -1,11 +1,17 @@
 package check
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
 
 	"github.com/xackery/rof2plus/checksum"
+	"github.com/xackery/rof2plus/pfs"
 	"gopkg.in/yaml.v3"
 )
 
@@ -84,3 +90,84 @@ func TestPatcherCheck(t *testing.T) {
 
 	t.Fatalf("report: %+v", report)
 }
+
+func TestRunIndependent(t *testing.T) {
+	type run struct {
+		dir      string
+		manifest map[string]*checksum.ChecksumEntry
+		report   *ReportDetail
+		err      error
+	}
+	runs := []*run{}
+	for i, content := range []string{"first", "second client"} {
+		dir := t.TempDir()
+		err := os.WriteFile(filepath.Join(dir, "eqgame.exe"), []byte(content), 0644)
+		if err != nil {
+			t.Fatalf("write: %v", err)
+		}
+		manifest := map[string]*checksum.ChecksumEntry{
+			"eqgame.exe":                    {FileSize: int64(len(content))},
+			fmt.Sprintf("missing%d.txt", i): {FileSize: 1},
+		}
+		runs = append(runs, &run{dir: dir, manifest: manifest})
+	}
+
+	wg := sync.WaitGroup{}
+	for _, r := range runs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r.report, r.err = Run(context.Background(), Options{Client: checksum.ClientPatcher, Path: r.dir, Manifest: r.manifest})
+		}()
+	}
+	wg.Wait()
+
+	for i, r := range runs {
+		if r.err != nil {
+			t.Fatalf("run %d: %v", i, r.err)
+		}
+		if r.report.FileTotal != 2 || r.report.OKTotal != 1 || r.report.FailTotal != 1 {
+			t.Fatalf("run %d: unexpected report %s", i, r.report)
+		}
+		if r.report.Failures[0].Path != fmt.Sprintf("missing%d.txt", i) || r.report.Failures[0].Error != ErrorNotFound {
+			t.Fatalf("run %d: unexpected failure %s", i, r.report.Failures[0])
+		}
+	}
+}
+
+func TestRunArchiveEntries(t *testing.T) {
+	writeArchive := func(dir string, files []pfs.File) {
+		f, err := os.Create(filepath.Join(dir, "gfaydark.s3d"))
+		if err != nil {
+			t.Fatalf("create: %v", err)
+		}
+		defer f.Close()
+		err = pfs.Write(f, files)
+		if err != nil {
+			t.Fatalf("write archive: %v", err)
+		}
+	}
+
+	reference := t.TempDir()
+	writeArchive(reference, []pfs.File{{Name: "tree.dds", Data: []byte("vanilla tree")}, {Name: "zone.wld", Data: []byte("world")}})
+	dir := t.TempDir()
+	writeArchive(dir, []pfs.File{{Name: "tree.dds", Data: []byte("custom tree texture")}, {Name: "zone.wld", Data: []byte("world")}})
+
+	manifest := map[string]*checksum.ChecksumEntry{
+		"gfaydark.s3d": {MD5Hash: "00000000000000000000000000000000", FileSize: 1},
+	}
+	report, err := Run(context.Background(), Options{Client: checksum.ClientRoF2, Path: dir, Manifest: manifest, ReferencePath: reference})
+	if err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	if report.FailTotal != 1 {
+		t.Fatalf("expected archive failure, got %s", report)
+	}
+	failure := report.Failures[0]
+	if len(failure.Entries) != 1 || failure.Entries[0].Name != "tree.dds" || !errors.Is(failure.Entries[0], pfs.ErrReplaced) {
+		t.Fatalf("expected tree.dds replaced, got %v", failure.Entries)
+	}
+	if !strings.Contains(failure.Directions, "tree.dds: replaced") {
+		t.Fatalf("directions should name the entry: %s", failure.Directions)
+	}
+}
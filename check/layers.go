@@ -0,0 +1,271 @@
+package check
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/xackery/rof2plus/checksum"
+)
+
+// Layer is a source of files in a server directory, later layers replace earlier ones
+type Layer int
+
+const (
+	LayerUntracked Layer = iota
+	LayerRoF2
+	LayerLSOptional
+	LayerPatch
+)
+
+func (e Layer) String() string {
+	switch e {
+	case LayerRoF2:
+		return "rof2"
+	case LayerLSOptional:
+		return "ls optional"
+	case LayerPatch:
+		return "server patch"
+	}
+	return "untracked"
+}
+
+// LayerStatus is how a file on disk compares to its layers
+type LayerStatus int
+
+const (
+	// LayerStatusOK matches the owning layer
+	LayerStatusOK LayerStatus = iota
+	// LayerStatusMissing is listed but not on disk
+	LayerStatusMissing
+	// LayerStatusStale matches a lower layer, so the owning layer was never applied
+	LayerStatusStale
+	// LayerStatusModified matches no layer, it is corrupt or was changed by hand
+	LayerStatusModified
+	// LayerStatusUntracked is on disk but in no layer
+	LayerStatusUntracked
+)
+
+func (e LayerStatus) String() string {
+	switch e {
+	case LayerStatusOK:
+		return "ok"
+	case LayerStatusMissing:
+		return "missing"
+	case LayerStatusStale:
+		return "stale"
+	case LayerStatusModified:
+		return "modified"
+	}
+	return "untracked"
+}
+
+// LayerFile is the resolution of one file
+type LayerFile struct {
+	Path string
+	// Owner is the highest layer listing the file
+	Owner Layer
+	// Layers lists every layer with the file, lowest first
+	Layers []Layer
+	// IsOverride is true when the server patch replaces vanilla content with a different file
+	IsOverride bool
+	Status     LayerStatus
+	// Match is the layer the file on disk matches, when stale
+	Match Layer
+}
+
+func (e *LayerFile) String() string {
+	text := fmt.Sprintf("%s: %s", e.Path, e.Owner)
+	if e.IsOverride {
+		text += " overrides " + e.Layers[len(e.Layers)-2].String()
+	}
+	if e.Status == LayerStatusStale {
+		return fmt.Sprintf("%s, %s (matches %s)", text, e.Status, e.Match)
+	}
+	return fmt.Sprintf("%s, %s", text, e.Status)
+}
+
+// LayerReport resolves every file of a server directory to its layers
+type LayerReport struct {
+	Files     []*LayerFile
+	Overrides int
+	// Problems are files that are missing, stale or modified
+	Problems  int
+	Untracked int
+}
+
+func (e *LayerReport) String() string {
+	return fmt.Sprintf("Total: %d Overrides: %d Problems: %d Untracked: %d", len(e.Files), e.Overrides, e.Problems, e.Untracked)
+}
+
+// LayerOptions describes a server directory to resolve
+type LayerOptions struct {
+	Path string
+	// FileList is the server patch layer, optional
+	FileList *checksum.FileList
+	// IsLSOptional adds the LS optional overlay between rof2 and the server patch
+	IsLSOptional bool
+}
+
+type layerManifest struct {
+	layer    Layer
+	manifest map[string]*checksum.ChecksumEntry
+}
+
+// Layers reports which layer owns each file of opts.Path, flagging server overrides of vanilla
+// files and files that match no layer, so intended customizations can be told apart from corruption
+func Layers(ctx context.Context, opts LayerOptions) (*LayerReport, error) {
+	rof2, err := checksum.Manifest(checksum.ClientRoF2)
+	if err != nil {
+		return nil, fmt.Errorf("manifest: %w", err)
+	}
+	stack := []layerManifest{{layer: LayerRoF2, manifest: rof2}}
+	if opts.IsLSOptional {
+		lsOptional, err := checksum.Manifest(checksum.ClientLSOptional)
+		if err != nil {
+			return nil, fmt.Errorf("manifest: %w", err)
+		}
+		stack = append(stack, layerManifest{layer: LayerLSOptional, manifest: lsOptional})
+	}
+	if opts.FileList != nil {
+		stack = append(stack, layerManifest{layer: LayerPatch, manifest: checksum.FileListManifest(opts.FileList)})
+	}
+
+	return resolveStack(ctx, opts.Path, stack)
+}
+
+// resolveStack reports every file of rootPath against stack, ordered lowest layer first
+func resolveStack(ctx context.Context, rootPath string, stack []layerManifest) (*LayerReport, error) {
+	onDisk, err := listFiles(ctx, rootPath)
+	if err != nil {
+		return nil, err
+	}
+
+	// rof2 lists thousands of files a server directory may never hold, only listed files
+	// above the vanilla layer or present on disk are reported
+	names := map[string]bool{}
+	for name := range onDisk {
+		names[name] = true
+	}
+	for _, layer := range stack[1:] {
+		for name, entry := range layer.manifest {
+			if entry.IsDeleted {
+				continue
+			}
+			names[name] = true
+		}
+	}
+
+	report := &LayerReport{}
+	for name := range names {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		file, err := resolveLayers(rootPath, name, onDisk[name], stack)
+		if err != nil {
+			return nil, err
+		}
+		if file.IsOverride {
+			report.Overrides++
+		}
+		switch file.Status {
+		case LayerStatusOK:
+		case LayerStatusUntracked:
+			report.Untracked++
+		default:
+			report.Problems++
+		}
+		report.Files = append(report.Files, file)
+	}
+
+	sort.Slice(report.Files, func(i, j int) bool {
+		return report.Files[i].Path < report.Files[j].Path
+	})
+	return report, nil
+}
+
+func resolveLayers(rootPath string, name string, fi fs.FileInfo, stack []layerManifest) (*LayerFile, error) {
+	file := &LayerFile{Path: name}
+	entries := []*checksum.ChecksumEntry{}
+	for _, layer := range stack {
+		entry, ok := layer.manifest[name]
+		if !ok || entry.IsDeleted {
+			continue
+		}
+		file.Layers = append(file.Layers, layer.layer)
+		entries = append(entries, entry)
+	}
+	if len(entries) == 0 {
+		file.Status = LayerStatusUntracked
+		return file, nil
+	}
+
+	owner := entries[len(entries)-1]
+	file.Owner = file.Layers[len(file.Layers)-1]
+	if file.Owner == LayerPatch && len(entries) > 1 && !strings.EqualFold(entries[len(entries)-2].MD5Hash, owner.MD5Hash) {
+		file.IsOverride = true
+	}
+
+	if fi == nil {
+		file.Status = LayerStatusMissing
+		return file, nil
+	}
+	if fi.Size() == owner.FileSize && len(entries) == 1 {
+		// nothing to tell apart, the size check matches how check treats files
+		return file, nil
+	}
+
+	hash, err := checksum.MD5Generate(filepath.Join(rootPath, name))
+	if err != nil {
+		return nil, fmt.Errorf("md5 %s: %w", name, err)
+	}
+	if strings.EqualFold(hash, owner.MD5Hash) {
+		return file, nil
+	}
+	for i := len(entries) - 2; i >= 0; i-- {
+		if strings.EqualFold(hash, entries[i].MD5Hash) {
+			file.Status = LayerStatusStale
+			file.Match = file.Layers[i]
+			return file, nil
+		}
+	}
+	file.Status = LayerStatusModified
+	return file, nil
+}
+
+// listFiles returns every file under rootPath by its manifest name
+func listFiles(ctx context.Context, rootPath string) (map[string]fs.FileInfo, error) {
+	files := map[string]fs.FileInfo{}
+	err := filepath.WalkDir(rootPath, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if d.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(rootPath, path)
+		if err != nil {
+			return err
+		}
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+		files[filepath.ToSlash(rel)] = fi
+		return nil
+	})
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("path does not exist: %w", err)
+		}
+		return nil, fmt.Errorf("list %s: %w", rootPath, err)
+	}
+	return files, nil
+}
@@ -0,0 +1,72 @@
+package check
+
+import (
+	"context"
+	"testing"
+
+	"github.com/xackery/rof2plus/checksum"
+	"github.com/xackery/rof2plus/internal/testfiles"
+)
+
+func layerEntry(content string) *checksum.ChecksumEntry {
+	return &checksum.ChecksumEntry{MD5Hash: testfiles.MD5(content), FileSize: int64(len(content))}
+}
+
+func TestResolveStack(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"spells_us.txt":   "custom spells",
+		"eqgame.exe":      "vanilla game",
+		"dbstr_us.txt":    "vanilla strings",
+		"Resources/a.txt": "garbage",
+		"notes.txt":       "mine",
+	}
+	testfiles.Write(t, dir, files)
+
+	stack := []layerManifest{
+		{layer: LayerRoF2, manifest: map[string]*checksum.ChecksumEntry{
+			"spells_us.txt":   layerEntry("vanilla spells"),
+			"eqgame.exe":      layerEntry("vanilla game"),
+			"dbstr_us.txt":    layerEntry("vanilla strings"),
+			"Resources/a.txt": layerEntry("resource a"),
+		}},
+		{layer: LayerPatch, manifest: map[string]*checksum.ChecksumEntry{
+			"spells_us.txt": layerEntry("custom spells"),
+			"dbstr_us.txt":  layerEntry("custom strings"),
+			"custom.eqg":    layerEntry("new zone"),
+		}},
+	}
+
+	report, err := resolveStack(context.Background(), dir, stack)
+	if err != nil {
+		t.Fatalf("resolve: %v", err)
+	}
+
+	want := map[string]struct {
+		owner      Layer
+		status     LayerStatus
+		isOverride bool
+	}{
+		"spells_us.txt":   {owner: LayerPatch, status: LayerStatusOK, isOverride: true},
+		"eqgame.exe":      {owner: LayerRoF2, status: LayerStatusOK},
+		"dbstr_us.txt":    {owner: LayerPatch, status: LayerStatusStale, isOverride: true},
+		"Resources/a.txt": {owner: LayerRoF2, status: LayerStatusModified},
+		"notes.txt":       {owner: LayerUntracked, status: LayerStatusUntracked},
+		"custom.eqg":      {owner: LayerPatch, status: LayerStatusMissing},
+	}
+	if len(report.Files) != len(want) {
+		t.Fatalf("expected %d files, got %v", len(want), report.Files)
+	}
+	for _, file := range report.Files {
+		w, ok := want[file.Path]
+		if !ok {
+			t.Fatalf("unexpected file %s", file)
+		}
+		if file.Owner != w.owner || file.Status != w.status || file.IsOverride != w.isOverride {
+			t.Errorf("%s: got owner %s status %s override %t", file.Path, file.Owner, file.Status, file.IsOverride)
+		}
+	}
+	if report.Overrides != 2 || report.Problems != 3 || report.Untracked != 1 {
+		t.Fatalf("unexpected totals: %s", report)
+	}
+}
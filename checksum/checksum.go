@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"strings"
 	"sync"
 
 	"github.com/cespare/xxhash"
@@ -18,6 +19,10 @@ const (
 	ClientRoF2Core
 	ClientLS
 	ClientPatcher
+	// ClientLSOptional is the overlay of files where the Live-Steam build differs from RoF2
+	ClientLSOptional
+	// ClientRoF2LS is RoF2 with the LS optional overlay applied
+	ClientRoF2LS
 )
 
 func (e *ChecksumClient) String() string {
@@ -30,15 +35,41 @@ func (e *ChecksumClient) String() string {
 		return "ls"
 	case ClientPatcher:
 		return "patcher"
+	case ClientLSOptional:
+		return "lsopt"
+	case ClientRoF2LS:
+		return "rof2ls"
 	}
 	return "unknown"
 }
 
+// ClientByName returns the client matching name, e.g. rof2, rof2core, ls or rof2ls
+func ClientByName(name string) (ChecksumClient, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "rof2":
+		return ClientRoF2, nil
+	case "rof2core":
+		return ClientRoF2Core, nil
+	case "ls":
+		return ClientLS, nil
+	case "patcher":
+		return ClientPatcher, nil
+	case "lsopt":
+		return ClientLSOptional, nil
+	case "rof2ls":
+		return ClientRoF2LS, nil
+	}
+	return ClientRoF2, fmt.Errorf("unknown client: %s", name)
+}
+
 var (
 	mux              sync.RWMutex
 	isClientLimited  bool
 	excludedClients  []ChecksumClient
 	patcherChecksums = make(map[string]*ChecksumEntry)
+	// rof2LSChecksums is built from the rof2 and ls optional manifests on first use
+	rof2LSChecksums map[string]*ChecksumEntry
+	rof2LSOnce      sync.Once
 )
 
 type ChecksumEntry struct {
@@ -82,6 +113,11 @@ func FileSize(client ChecksumClient, filename string) int64 {
 			if ok {
 				return entry.FileSize
 			}
+		case ClientLSOptional, ClientRoF2LS:
+			entry, ok := manifestLocked(client)[filename]
+			if ok {
+				return entry.FileSize
+			}
 		default:
 			return -1
 		}
@@ -137,11 +173,21 @@ func MD5Hash(client ChecksumClient, filename string) string {
 		if ok {
 			return entry.MD5Hash
 		}
+	case ClientRoF2Core:
+		entry, ok := rofCoreChecksums[filename]
+		if ok {
+			return entry.MD5Hash
+		}
 	case ClientPatcher:
 		entry, ok := patcherChecksums[filename]
 		if ok {
 			return entry.MD5Hash
 		}
+	case ClientLSOptional, ClientRoF2LS:
+		entry, ok := manifestLocked(client)[filename]
+		if ok {
+			return entry.MD5Hash
+		}
 	}
 	return ""
 }
@@ -229,6 +275,8 @@ func ByClient(client ChecksumClient) (map[string]*ChecksumEntry, error) {
 			return lsChecksums, nil
 		case ClientPatcher:
 			return patcherChecksums, nil
+		case ClientLSOptional, ClientRoF2LS:
+			return manifestLocked(client), nil
 		default:
 			return nil, fmt.Errorf("unknown client: %d", client)
 		}
@@ -262,6 +310,46 @@ func ByClient(client ChecksumClient) (map[string]*ChecksumEntry, error) {
 	return checksums, nil
 }
 
+// Manifest returns a copy of the full checksum list shipped for client, ignoring client limits and
+// exclusions. Callers may change it without affecting the package or racing SetPatcherFilelist
+func Manifest(client ChecksumClient) (map[string]*ChecksumEntry, error) {
+	mux.RLock()
+	defer mux.RUnlock()
+
+	manifest := manifestLocked(client)
+	if manifest == nil {
+		return nil, fmt.Errorf("unknown client: %d", client)
+	}
+	copied := make(map[string]*ChecksumEntry, len(manifest))
+	for name, entry := range manifest {
+		copyEntry := *entry
+		copied[name] = &copyEntry
+	}
+	return copied, nil
+}
+
+// manifestLocked returns the manifest of client, the caller holds mux
+func manifestLocked(client ChecksumClient) map[string]*ChecksumEntry {
+	switch client {
+	case ClientRoF2:
+		return rofChecksums
+	case ClientRoF2Core:
+		return rofCoreChecksums
+	case ClientLS:
+		return lsChecksums
+	case ClientPatcher:
+		return patcherChecksums
+	case ClientLSOptional:
+		return lsOptChecksums
+	case ClientRoF2LS:
+		rof2LSOnce.Do(func() {
+			rof2LSChecksums = overlay(lsOptChecksums, rofChecksums)
+		})
+		return rof2LSChecksums
+	}
+	return nil
+}
+
 func SetExcludedClients(clients ...ChecksumClient) {
 	mux.Lock()
 	defer mux.Unlock()
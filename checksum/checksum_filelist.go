@@ -1,13 +1,7 @@
 package checksum
 
 import (
-	"fmt"
-	"io"
-	"net/http"
-	"os"
-	"time"
-
-	"gopkg.in/yaml.v3"
+	"context"
 )
 
 // FileList represents a file_list.yml file downloaded from server
@@ -16,7 +10,8 @@ type FileList struct {
 	DownloadPrefix string      `yaml:"downloadprefix"`
 	Deletes        []FileEntry `yaml:"deletes"`
 	Downloads      []FileEntry `yaml:"downloads"`
-	Unpacks        []FileEntry `yaml:"unpacks"`
+	// Unpacks are zips extracted into the directory named by their Zip
+	Unpacks []FileEntry `yaml:"unpacks"`
 }
 
 // FileEntry is an entry inside FileList
@@ -28,72 +23,31 @@ type FileEntry struct {
 	Size int    `yaml:"size"`
 }
 
-// FetchPatcherFilelist fetches the filelist from the patcher server
-func FetchPatcherFilelist(baseURL string) (*FileList, error) {
-	fileList := &FileList{}
-	data, err := os.ReadFile("rof2plus_filelist.yml")
-	if err == nil {
-		err = yaml.Unmarshal(data, fileList)
-		if err != nil {
-			return nil, fmt.Errorf("decode filelist: %w", err)
-		}
-
-		err = SetPatcherFilelist(fileList)
-		if err != nil {
-			return nil, fmt.Errorf("set patcher filelist: %w", err)
-		}
-		return fileList, nil
-	}
-
-	client := &http.Client{
-		Timeout: 10 * time.Second,
-	}
-	url := fmt.Sprintf("%s/rof2plus_filelist.yml", baseURL)
-	resp, err := client.Get(url)
-	if err != nil {
-		return nil, fmt.Errorf("download %s: %w", url, err)
-	}
-	if resp.StatusCode != 200 {
-		return nil, fmt.Errorf("download %s responded HTTP status code %d", url, resp.StatusCode)
-	}
-	defer resp.Body.Close()
-
-	data, err = io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, fmt.Errorf("read body: %w", err)
-	}
-
-	err = yaml.Unmarshal(data, fileList)
-	if err != nil {
-		return nil, fmt.Errorf("decode filelist: %w", err)
-	}
-
-	err = SetPatcherFilelist(fileList)
+// FetchPatcherFilelist fetches the filelist from the patcher server, caching it in dir
+func FetchPatcherFilelist(baseURL string, dir string) (*FileList, error) {
+	cached, err := FetchCachedFilelist(context.Background(), baseURL, dir, false)
 	if err != nil {
-		return nil, fmt.Errorf("set patcher filelist: %w", err)
+		return nil, err
 	}
-
-	//slog.Print("patch version is", fileList.Version, "and we are version", c.cfg.ClientVersion)
-
-	patcherChecksums = map[string]*ChecksumEntry{}
-
-	for _, entry := range fileList.Downloads {
-		patcherChecksums[entry.Name] = &ChecksumEntry{
-			Path:     entry.Name,
-			MD5Hash:  entry.Md5,
-			FileSize: int64(entry.Size),
-		}
-	}
-	return fileList, nil
+	return cached.FileList, nil
 }
 
 // SetPatcherFilelist sets the patcher filelist from the given data
 func SetPatcherFilelist(fileList *FileList) error {
+	checksums := FileListManifest(fileList)
 
-	patcherChecksums = map[string]*ChecksumEntry{}
+	mux.Lock()
+	defer mux.Unlock()
+	patcherChecksums = checksums
+	return nil
+}
+
+// FileListManifest returns the checksums described by fileList without touching the patcher manifest
+func FileListManifest(fileList *FileList) map[string]*ChecksumEntry {
+	checksums := map[string]*ChecksumEntry{}
 
 	for _, entry := range fileList.Downloads {
-		patcherChecksums[entry.Name] = &ChecksumEntry{
+		checksums[entry.Name] = &ChecksumEntry{
 			Path:     entry.Name,
 			MD5Hash:  entry.Md5,
 			FileSize: int64(entry.Size),
@@ -101,7 +55,7 @@ func SetPatcherFilelist(fileList *FileList) error {
 	}
 
 	for _, entry := range fileList.Deletes {
-		patcherChecksums[entry.Name] = &ChecksumEntry{
+		checksums[entry.Name] = &ChecksumEntry{
 			IsDeleted: true,
 			Path:      entry.Name,
 			MD5Hash:   "DELETE",
@@ -109,5 +63,5 @@ func SetPatcherFilelist(fileList *FileList) error {
 		}
 	}
 
-	return nil
+	return checksums
 }
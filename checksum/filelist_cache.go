@@ -0,0 +1,231 @@
+package checksum
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	fileListCacheName = "rof2plus_filelist.yml"
+	fileListMetaName  = "rof2plus_filelist_meta.yml"
+)
+
+// ErrNoCachedFilelist is returned in offline mode when no file list was ever fetched
+var ErrNoCachedFilelist = errors.New("no cached file list")
+
+// FileListMeta records how a cached file list was fetched and which version was last patched
+type FileListMeta struct {
+	URL          string    `yaml:"url"`
+	ETag         string    `yaml:"etag"`
+	LastModified string    `yaml:"lastmodified"`
+	Fetched      time.Time `yaml:"fetched"`
+	// PatchedVersion is the file list version last patched successfully
+	PatchedVersion string `yaml:"patchedversion"`
+	// RolledBack is a file list version the player rolled back, it is not patched again
+	RolledBack string `yaml:"rolledback"`
+	// Removed are files the rollback deleted because the rolled back version added them
+	Removed []string `yaml:"removed"`
+}
+
+// CachedFileList is a file list along with its cache state
+type CachedFileList struct {
+	*FileList
+	Meta *FileListMeta
+	// IsModified is false when the server reported no change or the cache was used offline
+	IsModified bool
+	dir        string
+}
+
+// IsCurrent returns true if this file list version was already patched
+func (e *CachedFileList) IsCurrent() bool {
+	return e.Version != "" && e.Version == e.Meta.PatchedVersion
+}
+
+// MarkPatched records the file list version as patched
+func (e *CachedFileList) MarkPatched() error {
+	e.Meta.PatchedVersion = e.Version
+	if e.Meta.RolledBack == e.Version {
+		e.Meta.RolledBack = ""
+		e.Meta.Removed = nil
+	}
+	return writeYAML(filepath.Join(e.dir, fileListMetaName), e.Meta)
+}
+
+// IsRolledBack returns true if the player rolled this file list version back
+func (e *CachedFileList) IsRolledBack() bool {
+	return e.Version != "" && e.Version == e.Meta.RolledBack
+}
+
+// MarkRolledBack records version as rolled back, so it is skipped until the server publishes a new one.
+// removed are the files the rollback deleted, they are not expected on disk
+func (e *CachedFileList) MarkRolledBack(version string, removed []string) error {
+	e.Meta.PatchedVersion = ""
+	e.Meta.RolledBack = version
+	e.Meta.Removed = removed
+	return writeYAML(filepath.Join(e.dir, fileListMetaName), e.Meta)
+}
+
+// Missing returns the downloads not in dir, other than files a rollback removed. A directory that
+// was deleted or wiped is missing files whatever version was last patched
+func (e *CachedFileList) Missing(dir string) []string {
+	removed := map[string]bool{}
+	for _, name := range e.Meta.Removed {
+		removed[name] = true
+	}
+	missing := []string{}
+	for _, file := range e.Downloads {
+		if removed[file.Name] {
+			continue
+		}
+		fi, err := os.Stat(filepath.Join(dir, filepath.FromSlash(file.Name)))
+		if err != nil || fi.IsDir() {
+			missing = append(missing, file.Name)
+		}
+	}
+	return missing
+}
+
+// FetchCachedFilelist fetches the filelist from the patcher server with a conditional request,
+// keeping the last copy in dir, which should be unique to the server.
+// When isOffline is set only the cached copy is used, so a hand edited rof2plus_filelist.yml in dir
+// can be tested before publishing it
+func FetchCachedFilelist(ctx context.Context, baseURL string, dir string, isOffline bool) (*CachedFileList, error) {
+	cached := &CachedFileList{
+		Meta: &FileListMeta{},
+		dir:  dir,
+	}
+
+	err := readYAML(filepath.Join(dir, fileListMetaName), cached.Meta)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("read filelist meta: %w", err)
+	}
+
+	cachePath := filepath.Join(dir, fileListCacheName)
+	fileList := &FileList{}
+	err = readYAML(cachePath, fileList)
+	isCached := err == nil
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("read cached filelist: %w", err)
+	}
+
+	if isOffline {
+		slog.Debug("using cached file list", "dir", dir, "cached", isCached)
+		if !isCached {
+			return nil, ErrNoCachedFilelist
+		}
+		return cached.use(fileList, false)
+	}
+
+	url := fmt.Sprintf("%s/rof2plus_filelist.yml", strings.TrimSuffix(baseURL, "/"))
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("create request: %w", err)
+	}
+	// a cache from another url can't be trusted to be the same list
+	if isCached && cached.Meta.URL == url {
+		if cached.Meta.ETag != "" {
+			req.Header.Set("If-None-Match", cached.Meta.ETag)
+		}
+		if cached.Meta.LastModified != "" {
+			req.Header.Set("If-Modified-Since", cached.Meta.LastModified)
+		}
+	}
+
+	client := &http.Client{
+		Timeout: 10 * time.Second,
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("download %s: %w", url, err)
+	}
+	defer resp.Body.Close()
+
+	slog.Debug("fetched file list", "url", url, "status", resp.StatusCode)
+	if resp.StatusCode == http.StatusNotModified && isCached {
+		return cached.use(fileList, false)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("download %s responded HTTP status code %d", url, resp.StatusCode)
+	}
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("read body: %w", err)
+	}
+
+	fileList = &FileList{}
+	err = yaml.Unmarshal(data, fileList)
+	if err != nil {
+		return nil, fmt.Errorf("decode filelist: %w", err)
+	}
+
+	err = os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, fmt.Errorf("mkdir: %w", err)
+	}
+	err = writeFile(cachePath, data)
+	if err != nil {
+		return nil, fmt.Errorf("cache filelist: %w", err)
+	}
+
+	cached.Meta.URL = url
+	cached.Meta.ETag = resp.Header.Get("ETag")
+	cached.Meta.LastModified = resp.Header.Get("Last-Modified")
+	cached.Meta.Fetched = time.Now()
+	err = writeYAML(filepath.Join(dir, fileListMetaName), cached.Meta)
+	if err != nil {
+		return nil, fmt.Errorf("write filelist meta: %w", err)
+	}
+
+	return cached.use(fileList, true)
+}
+
+func (e *CachedFileList) use(fileList *FileList, isModified bool) (*CachedFileList, error) {
+	err := SetPatcherFilelist(fileList)
+	if err != nil {
+		return nil, fmt.Errorf("set patcher filelist: %w", err)
+	}
+	e.FileList = fileList
+	e.IsModified = isModified
+	return e, nil
+}
+
+func readYAML(path string, v any) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	err = yaml.Unmarshal(data, v)
+	if err != nil {
+		return fmt.Errorf("decode %s: %w", filepath.Base(path), err)
+	}
+	return nil
+}
+
+func writeYAML(path string, v any) error {
+	data, err := yaml.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("encode %s: %w", filepath.Base(path), err)
+	}
+	return writeFile(path, data)
+}
+
+// writeFile replaces path through a temporary file so an interrupted write keeps the old copy
+func writeFile(path string, data []byte) error {
+	tmpPath := path + ".tmp"
+	err := os.WriteFile(tmpPath, data, 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
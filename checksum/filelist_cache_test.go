@@ -0,0 +1,104 @@
+package checksum
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFetchCachedFilelist(t *testing.T) {
+	requests := 0
+	conditional := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			conditional++
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		w.Write([]byte("version: \"1\"\ndownloads:\n  - name: spells_us.txt\n    md5: abc\n    size: 3\n"))
+	}))
+	defer srv.Close()
+
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	_, err := FetchCachedFilelist(ctx, srv.URL, dir, true)
+	if !errors.Is(err, ErrNoCachedFilelist) {
+		t.Fatalf("offline without cache: got %v", err)
+	}
+
+	fileList, err := FetchCachedFilelist(ctx, srv.URL, dir, false)
+	if err != nil {
+		t.Fatalf("fetch: %v", err)
+	}
+	if !fileList.IsModified || fileList.Version != "1" || len(fileList.Downloads) != 1 {
+		t.Fatalf("unexpected first fetch: %+v", fileList)
+	}
+	if fileList.IsCurrent() {
+		t.Fatalf("list was never patched")
+	}
+	err = fileList.MarkPatched()
+	if err != nil {
+		t.Fatalf("mark patched: %v", err)
+	}
+
+	fileList, err = FetchCachedFilelist(ctx, srv.URL+"/", dir, false)
+	if err != nil {
+		t.Fatalf("refetch: %v", err)
+	}
+	if conditional != 1 || fileList.IsModified {
+		t.Fatalf("expected a not modified response, conditional requests %d", conditional)
+	}
+	if !fileList.IsCurrent() || len(fileList.Downloads) != 1 {
+		t.Fatalf("expected cached list to be current: %+v", fileList)
+	}
+
+	fileList, err = FetchCachedFilelist(ctx, "http://127.0.0.1:0", dir, true)
+	if err != nil {
+		t.Fatalf("offline: %v", err)
+	}
+	if requests != 2 || !fileList.IsCurrent() {
+		t.Fatalf("offline should not request, got %d requests", requests)
+	}
+}
+
+func TestMissing(t *testing.T) {
+	dir := t.TempDir()
+	err := os.WriteFile(filepath.Join(dir, "spells_us.txt"), []byte("changed by hand"), 0644)
+	if err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	fileList := &CachedFileList{
+		FileList: &FileList{Version: "2", Downloads: []FileEntry{{Name: "spells_us.txt"}, {Name: "maps/new.txt"}, {Name: "dbstr_us.txt"}}},
+		Meta:     &FileListMeta{},
+		dir:      t.TempDir(),
+	}
+
+	missing := fileList.Missing(dir)
+	if len(missing) != 2 || missing[0] != "maps/new.txt" || missing[1] != "dbstr_us.txt" {
+		t.Fatalf("unexpected missing: %v", missing)
+	}
+
+	err = fileList.MarkRolledBack("2", []string{"maps/new.txt"})
+	if err != nil {
+		t.Fatalf("mark rolled back: %v", err)
+	}
+	missing = fileList.Missing(dir)
+	if len(missing) != 1 || missing[0] != "dbstr_us.txt" {
+		t.Fatalf("files the rollback removed are not missing: %v", missing)
+	}
+
+	err = fileList.MarkPatched()
+	if err != nil {
+		t.Fatalf("mark patched: %v", err)
+	}
+	if fileList.IsRolledBack() || len(fileList.Meta.Removed) != 0 {
+		t.Fatalf("patching the version again should clear the rollback: %+v", fileList.Meta)
+	}
+}
@@ -0,0 +1,183 @@
+package checksum
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// IdentifyThreshold is the confidence a manifest must reach to name a directory after it, or to verify
+// a configured client path
+const IdentifyThreshold = 0.95
+
+const (
+	// identifyMixedRatio is the share of distinguishing files that must come from the other client to call a directory mixed
+	identifyMixedRatio = 0.1
+)
+
+// Identity is the result of fingerprinting a directory against every known client manifest
+type Identity struct {
+	// Build is rof2, rof2core, ls, mixed or unknown
+	Build string
+	// Client is the identified client, only meaningful when Build is a client name
+	Client ChecksumClient
+	// Confidence is the share of the best matching manifest found intact, 0 to 1
+	Confidence float64
+	Scores     []*IdentityScore
+}
+
+func (e *Identity) String() string {
+	return fmt.Sprintf("%s (%0.1f%% confidence)", e.Build, e.Confidence*100)
+}
+
+// IdentityScore is how well a directory matches a single client manifest
+type IdentityScore struct {
+	Client     ChecksumClient
+	Total      int
+	Matched    int
+	Mismatched int
+	Missing    int
+	// Distinct is the number of matched files whose content differs between rof2 and ls
+	Distinct   int
+	Confidence float64
+}
+
+func (e *IdentityScore) String() string {
+	return fmt.Sprintf("%s: %d/%d matched, %d mismatched, %d missing (%0.1f%%)", e.Client.String(), e.Matched, e.Total, e.Mismatched, e.Missing, e.Confidence*100)
+}
+
+// Identify fingerprints rootPath against the rof2, rof2core and ls manifests
+func Identify(rootPath string) (*Identity, error) {
+	mux.RLock()
+	manifests := map[ChecksumClient]map[string]*ChecksumEntry{
+		ClientRoF2:     rofChecksums,
+		ClientRoF2Core: rofCoreChecksums,
+		ClientLS:       lsChecksums,
+	}
+	mux.RUnlock()
+
+	return identify(rootPath, manifests)
+}
+
+func identify(rootPath string, manifests map[ChecksumClient]map[string]*ChecksumEntry) (*Identity, error) {
+	fi, err := os.Stat(rootPath)
+	if err != nil {
+		return nil, fmt.Errorf("stat: %w", err)
+	}
+	if !fi.IsDir() {
+		return nil, fmt.Errorf("path is not a directory")
+	}
+
+	// files both rof2 and ls ship with different content tell the two builds apart
+	distinct := map[string]bool{}
+	for name, rofEntry := range manifests[ClientRoF2] {
+		lsEntry, ok := manifests[ClientLS][name]
+		if !ok || lsEntry.MD5Hash == rofEntry.MD5Hash {
+			continue
+		}
+		distinct[name] = true
+	}
+
+	sizes := map[string]int64{}
+	hashes := map[string]string{}
+	sizeOf := func(name string) int64 {
+		size, ok := sizes[name]
+		if ok {
+			return size
+		}
+		size = -1
+		fi, err := os.Stat(filepath.Join(rootPath, filepath.FromSlash(name)))
+		if err == nil && !fi.IsDir() {
+			size = fi.Size()
+		}
+		sizes[name] = size
+		return size
+	}
+	hashOf := func(name string) string {
+		hash, ok := hashes[name]
+		if ok {
+			return hash
+		}
+		hash, _ = MD5Generate(filepath.Join(rootPath, filepath.FromSlash(name)))
+		hashes[name] = hash
+		return hash
+	}
+
+	identity := &Identity{Build: "unknown"}
+	scores := map[ChecksumClient]*IdentityScore{}
+	clients := []ChecksumClient{}
+	for client := range manifests {
+		clients = append(clients, client)
+	}
+	sort.Slice(clients, func(i, j int) bool { return clients[i] < clients[j] })
+
+	for _, client := range clients {
+		score := &IdentityScore{Client: client}
+		for name, entry := range manifests[client] {
+			score.Total++
+			size := sizeOf(name)
+			if size < 0 {
+				score.Missing++
+				continue
+			}
+			if size != entry.FileSize {
+				score.Mismatched++
+				continue
+			}
+			if distinct[name] {
+				if hashOf(name) != entry.MD5Hash {
+					score.Mismatched++
+					continue
+				}
+				score.Distinct++
+			}
+			score.Matched++
+		}
+		if score.Total > 0 {
+			score.Confidence = float64(score.Matched) / float64(score.Total)
+		}
+		scores[client] = score
+		identity.Scores = append(identity.Scores, score)
+	}
+
+	rof := scores[ClientRoF2]
+	ls := scores[ClientLS]
+	core := scores[ClientRoF2Core]
+
+	isMixed := false
+	if rof != nil && ls != nil && rof.Distinct+ls.Distinct > 0 {
+		minority := min(rof.Distinct, ls.Distinct)
+		isMixed = float64(minority)/float64(rof.Distinct+ls.Distinct) >= identifyMixedRatio
+	}
+
+	var best *IdentityScore
+	for _, score := range []*IdentityScore{rof, ls} {
+		if score == nil {
+			continue
+		}
+		if best == nil || score.Confidence > best.Confidence || (score.Confidence == best.Confidence && score.Matched > best.Matched) {
+			best = score
+		}
+	}
+
+	switch {
+	case isMixed && best != nil && best.Confidence >= 0.5:
+		identity.Build = "mixed"
+		identity.Confidence = best.Confidence
+	case best != nil && best.Confidence >= IdentifyThreshold:
+		identity.Build = best.Client.String()
+		identity.Client = best.Client
+		identity.Confidence = best.Confidence
+	case core != nil && core.Confidence >= IdentifyThreshold:
+		identity.Build = core.Client.String()
+		identity.Client = core.Client
+		identity.Confidence = core.Confidence
+	default:
+		for _, score := range identity.Scores {
+			identity.Confidence = max(identity.Confidence, score.Confidence)
+		}
+	}
+
+	return identity, nil
+}
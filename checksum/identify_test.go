@@ -0,0 +1,88 @@
+package checksum
+
+import (
+	"testing"
+
+	"github.com/xackery/rof2plus/internal/testfiles"
+)
+
+func identifyEntry(content string) *ChecksumEntry {
+	return &ChecksumEntry{MD5Hash: testfiles.MD5(content), FileSize: int64(len(content))}
+}
+
+func TestIdentify(t *testing.T) {
+	// shared.txt differs between rof2 and ls in content only, same size
+	rof := map[string]*ChecksumEntry{
+		"eqgame.exe":      identifyEntry("rof2 eqgame"),
+		"shared.txt":      identifyEntry("aaaa"),
+		"shared2.txt":     identifyEntry("cccc"),
+		"uifiles/ui.xml":  identifyEntry("<ui/>"),
+		"zones/zone1.s3d": identifyEntry("zone1"),
+	}
+	core := map[string]*ChecksumEntry{
+		"eqgame.exe":     rof["eqgame.exe"],
+		"uifiles/ui.xml": rof["uifiles/ui.xml"],
+	}
+	ls := map[string]*ChecksumEntry{
+		"eqgame.exe":      identifyEntry("ls eqgame!!"),
+		"shared.txt":      identifyEntry("bbbb"),
+		"shared2.txt":     identifyEntry("dddd"),
+		"uifiles/ui.xml":  rof["uifiles/ui.xml"],
+		"zones/zone1.s3d": rof["zones/zone1.s3d"],
+	}
+	manifests := map[ChecksumClient]map[string]*ChecksumEntry{
+		ClientRoF2:     rof,
+		ClientRoF2Core: core,
+		ClientLS:       ls,
+	}
+
+	tests := []struct {
+		name  string
+		files map[string]string
+		build string
+	}{
+		{
+			name:  "rof2",
+			files: map[string]string{"eqgame.exe": "rof2 eqgame", "shared.txt": "aaaa", "shared2.txt": "cccc", "uifiles/ui.xml": "<ui/>", "zones/zone1.s3d": "zone1"},
+			build: "rof2",
+		},
+		{
+			name:  "ls",
+			files: map[string]string{"eqgame.exe": "ls eqgame!!", "shared.txt": "bbbb", "shared2.txt": "dddd", "uifiles/ui.xml": "<ui/>", "zones/zone1.s3d": "zone1"},
+			build: "ls",
+		},
+		{
+			name:  "rof2core",
+			files: map[string]string{"eqgame.exe": "rof2 eqgame", "uifiles/ui.xml": "<ui/>"},
+			build: "rof2core",
+		},
+		{
+			name:  "mixed",
+			files: map[string]string{"eqgame.exe": "rof2 eqgame", "shared.txt": "aaaa", "shared2.txt": "dddd", "uifiles/ui.xml": "<ui/>", "zones/zone1.s3d": "zone1"},
+			build: "mixed",
+		},
+		{
+			name:  "unknown",
+			files: map[string]string{"readme.txt": "hello"},
+			build: "unknown",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			testfiles.Write(t, dir, tt.files)
+
+			identity, err := identify(dir, manifests)
+			if err != nil {
+				t.Fatalf("identify: %v", err)
+			}
+			if identity.Build != tt.build {
+				t.Fatalf("build: got %s, want %s (%v)", identity.Build, tt.build, identity.Scores)
+			}
+			if tt.build != "unknown" && tt.build != "mixed" && identity.Confidence != 1 {
+				t.Fatalf("confidence: got %f", identity.Confidence)
+			}
+		})
+	}
+}
@@ -0,0 +1,50 @@
+package checksum
+
+import "fmt"
+
+// Resolve looks up path in a stack of client manifests, highest priority first, and returns the
+// entry of the first layer that lists it along with that layer. For example
+// Resolve("eqclient.ini", ClientLSOptional, ClientRoF2) prefers the LS optional file over RoF2
+func Resolve(path string, layers ...ChecksumClient) (*ChecksumEntry, ChecksumClient, error) {
+	mux.RLock()
+	defer mux.RUnlock()
+
+	for _, layer := range layers {
+		manifest := manifestLocked(layer)
+		if manifest == nil {
+			return nil, layer, fmt.Errorf("unknown client: %d", layer)
+		}
+		entry, ok := manifest[path]
+		if ok {
+			return entry, layer, nil
+		}
+	}
+	return nil, ClientRoF2, fmt.Errorf("%s is not in any layer", path)
+}
+
+// Overlay returns a manifest of every file in layers, highest priority first, each resolved like Resolve
+func Overlay(layers ...ChecksumClient) (map[string]*ChecksumEntry, error) {
+	mux.RLock()
+	defer mux.RUnlock()
+
+	manifests := []map[string]*ChecksumEntry{}
+	for _, layer := range layers {
+		manifest := manifestLocked(layer)
+		if manifest == nil {
+			return nil, fmt.Errorf("unknown client: %d", layer)
+		}
+		manifests = append(manifests, manifest)
+	}
+	return overlay(manifests...), nil
+}
+
+// overlay merges manifests, highest priority first
+func overlay(manifests ...map[string]*ChecksumEntry) map[string]*ChecksumEntry {
+	merged := map[string]*ChecksumEntry{}
+	for i := len(manifests) - 1; i >= 0; i-- {
+		for name, entry := range manifests[i] {
+			merged[name] = entry
+		}
+	}
+	return merged
+}
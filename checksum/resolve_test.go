@@ -0,0 +1,127 @@
+package checksum
+
+import (
+	"sort"
+	"testing"
+)
+
+func TestResolveMatchesFileSize(t *testing.T) {
+	// FileSize without a client limit walks patcher, ls, rof2 core then rof2
+	rofNames := []string{}
+	for name := range rofChecksums {
+		rofNames = append(rofNames, name)
+	}
+	sort.Strings(rofNames)
+	override := rofNames[0]
+
+	mux.Lock()
+	previous := patcherChecksums
+	patcherChecksums = map[string]*ChecksumEntry{
+		override:           {Path: override, MD5Hash: "00000000000000000000000000000000", FileSize: rofChecksums[override].FileSize + 1},
+		"rof2plus_new.txt": {Path: "rof2plus_new.txt", MD5Hash: "11111111111111111111111111111111", FileSize: 3},
+	}
+	mux.Unlock()
+	defer func() {
+		mux.Lock()
+		patcherChecksums = previous
+		mux.Unlock()
+	}()
+
+	stack := []ChecksumClient{ClientPatcher, ClientLS, ClientRoF2Core, ClientRoF2}
+	merged, err := Overlay(stack...)
+	if err != nil {
+		t.Fatalf("overlay: %v", err)
+	}
+	for name := range merged {
+		entry, _, err := Resolve(name, stack...)
+		if err != nil {
+			t.Fatalf("resolve %s: %v", name, err)
+		}
+		if entry != merged[name] {
+			t.Fatalf("%s: overlay and resolve disagree", name)
+		}
+		size := FileSize(ClientRoF2, name)
+		if size != entry.FileSize {
+			t.Fatalf("%s: file size %d, resolve %d", name, size, entry.FileSize)
+		}
+	}
+
+	_, layer, err := Resolve(override, stack...)
+	if err != nil {
+		t.Fatalf("resolve %s: %v", override, err)
+	}
+	if layer != ClientPatcher {
+		t.Fatalf("%s: resolved to %s, want patcher", override, layer.String())
+	}
+
+	_, _, err = Resolve("does/not/exist.txt", stack...)
+	if err == nil {
+		t.Fatalf("expected error for unknown file")
+	}
+	if FileSize(ClientRoF2, "does/not/exist.txt") != -1 {
+		t.Fatalf("expected file size -1 for unknown file")
+	}
+
+	_, _, err = Resolve(override, ChecksumClient(99))
+	if err == nil {
+		t.Fatalf("expected error for unknown client")
+	}
+}
+
+func TestRoF2LSManifest(t *testing.T) {
+	if len(lsOptChecksums) == 0 {
+		t.Skip("no ls optional files")
+	}
+
+	manifest, err := Manifest(ClientRoF2LS)
+	if err != nil {
+		t.Fatalf("manifest: %v", err)
+	}
+	for name, entry := range rofChecksums {
+		want := entry
+		lsOpt, ok := lsOptChecksums[name]
+		if ok {
+			want = lsOpt
+		}
+		if *manifest[name] != *want {
+			t.Fatalf("%s: got %+v want %+v", name, manifest[name], want)
+		}
+	}
+	for name, entry := range lsOptChecksums {
+		if *manifest[name] != *entry {
+			t.Fatalf("%s: ls optional file not preferred", name)
+		}
+		resolved, layer, err := Resolve(name, ClientLSOptional, ClientRoF2)
+		if err != nil {
+			t.Fatalf("resolve %s: %v", name, err)
+		}
+		if resolved != entry || layer != ClientLSOptional {
+			t.Fatalf("%s: resolved to %s", name, layer.String())
+		}
+		if MD5Hash(ClientRoF2LS, name) != entry.MD5Hash {
+			t.Fatalf("%s: md5 hash does not prefer ls optional", name)
+		}
+	}
+	if len(manifest) != len(overlay(lsOptChecksums, rofChecksums)) {
+		t.Fatalf("unexpected manifest size %d", len(manifest))
+	}
+}
+
+func TestManifestCopy(t *testing.T) {
+	manifest, err := Manifest(ClientRoF2)
+	if err != nil {
+		t.Fatalf("manifest: %v", err)
+	}
+	if len(manifest) != len(rofChecksums) {
+		t.Fatalf("got %d entries, want %d", len(manifest), len(rofChecksums))
+	}
+	for name, entry := range manifest {
+		hash := rofChecksums[name].MD5Hash
+		entry.MD5Hash = "changed"
+		delete(manifest, name)
+		if rofChecksums[name] == nil || rofChecksums[name].MD5Hash != hash {
+			t.Fatalf("%s: changing the manifest changed the package's copy", name)
+		}
+		break
+	}
+}
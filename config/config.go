@@ -2,96 +2,275 @@ package config
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
 
+	"github.com/xackery/rof2plus/checksum"
 	"gopkg.in/yaml.v3"
 )
 
-var config *Config
+// SchemaVersion is the current config layout. When settings change, bump it and append a migration
+const SchemaVersion = 1
+
+// migrations upgrade a decoded config document one version at a time, migrations[n] moves version n to n+1
+var migrations = []func(doc map[string]any) error{
+	// 0 to 1: unversioned configs only gain a version field
+	func(doc map[string]any) error {
+		return nil
+	},
+}
 
 // Config represents a configuration parse
 type Config struct {
-	RoF2Path string `yaml:"rof2path"`
-	LSPath   string `yaml:"lspath"`
-	baseName string
+	Version      int    `yaml:"version"`
+	RoF2Path     string `yaml:"rof2path"`
+	RoF2CorePath string `yaml:"rof2corepath"`
+	LSPath       string `yaml:"lspath"`
+	// LANCache is a rof2plus serve-cache url to patch through, found by discovery when empty
+	LANCache string `yaml:"lancache"`
+	path     string
 }
 
-func Get() *Config {
-	if config == nil {
-		panic("config is nil")
+// Default returns a config with default settings
+func Default() *Config {
+	return &Config{
+		Version: SchemaVersion,
 	}
-	return config
 }
 
-// New creates a new configuration
-func New(ctx context.Context, baseName string) (*Config, error) {
-	var f *os.File
-	cfg := Config{
-		baseName: baseName,
-	}
-	path := baseName + ".yaml"
+// New loads the configuration at path, creating it with defaults if it does not exist
+func New(ctx context.Context, path string) (*Config, error) {
+	name := filepath.Base(path)
 
-	isNewConfig := false
 	fi, err := os.Stat(path)
 	if err != nil {
 		if !os.IsNotExist(err) {
 			return nil, fmt.Errorf("config info: %w", err)
 		}
-		f, err = os.Create(path)
+
+		cfg := Default()
+		cfg.path = path
+		err = cfg.Save()
+		if err != nil {
+			return nil, fmt.Errorf("save default: %w", err)
+		}
+
+		return cfg, nil
+	}
+	if fi.IsDir() {
+		return nil, fmt.Errorf("%s is a directory, should be a file", name)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("open config: %w", err)
+	}
+
+	doc := map[string]any{}
+	err = yaml.Unmarshal(data, &doc)
+	if err != nil {
+		return nil, fmt.Errorf("decode %s: %w", name, err)
+	}
+	if doc == nil {
+		doc = map[string]any{}
+	}
+
+	fromVersion, err := migrate(doc)
+	if err != nil {
+		return nil, fmt.Errorf("migrate %s: %w", name, err)
+	}
+
+	migrated, err := yaml.Marshal(doc)
+	if err != nil {
+		return nil, fmt.Errorf("encode migrated: %w", err)
+	}
+
+	cfg := Default()
+	err = yaml.Unmarshal(migrated, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("decode %s: %w", name, err)
+	}
+	cfg.path = path
+
+	if fromVersion != SchemaVersion {
+		backupPath := fmt.Sprintf("%s.v%d.bak", path, fromVersion)
+		err = os.WriteFile(backupPath, data, 0644)
 		if err != nil {
-			return nil, fmt.Errorf("create %s.yaml: %w", baseName, err)
+			return nil, fmt.Errorf("backup %s: %w", name, err)
 		}
-		fi, err = os.Stat(path)
+		err = cfg.Save()
 		if err != nil {
-			return nil, fmt.Errorf("new config info: %w", err)
+			return nil, fmt.Errorf("save migrated: %w", err)
 		}
-		isNewConfig = true
 	}
-	if !isNewConfig {
-		f, err = os.Open(path)
+
+	return cfg, nil
+}
+
+// migrate upgrades doc to SchemaVersion in place, returning the version it started at
+func migrate(doc map[string]any) (int, error) {
+	version := 0
+	value, ok := doc["version"]
+	if ok {
+		v, ok := value.(int)
+		if !ok {
+			return 0, fmt.Errorf("version %v is not a number", value)
+		}
+		version = v
+	}
+	if version > SchemaVersion {
+		return version, fmt.Errorf("version %d is newer than this rof2plus supports (%d), please update rof2plus", version, SchemaVersion)
+	}
+	if version < 0 {
+		return version, fmt.Errorf("invalid version %d", version)
+	}
+
+	for v := version; v < SchemaVersion; v++ {
+		err := migrations[v](doc)
 		if err != nil {
-			return nil, fmt.Errorf("open config: %w", err)
+			return version, fmt.Errorf("version %d to %d: %w", v, v+1, err)
 		}
+		doc["version"] = v + 1
 	}
+	return version, nil
+}
 
-	defer f.Close()
-	if fi.IsDir() {
-		return nil, fmt.Errorf("%s.yaml is a directory, should be a file", baseName)
+// Verify returns an error if configuration appears off, such as a client path that is
+// missing or does not match its manifest
+func (c *Config) Verify() error {
+	errs := []error{}
+	if c.Version != SchemaVersion {
+		errs = append(errs, fmt.Errorf("version is %d, expected %d", c.Version, SchemaVersion))
 	}
 
-	if isNewConfig {
-		enc := yaml.NewEncoder(f)
-		cfg = Config{}
-		err = enc.Encode(cfg)
+	paths := []struct {
+		key    string
+		path   string
+		client checksum.ChecksumClient
+	}{
+		{key: "rof2path", path: c.RoF2Path, client: checksum.ClientRoF2},
+		{key: "rof2corepath", path: c.RoF2CorePath, client: checksum.ClientRoF2Core},
+		{key: "lspath", path: c.LSPath, client: checksum.ClientLS},
+	}
+	for _, p := range paths {
+		if p.path == "" {
+			continue
+		}
+		err := verifyClientPath(p.path, p.client)
 		if err != nil {
-			return nil, fmt.Errorf("encode default: %w", err)
+			errs = append(errs, fmt.Errorf("%s: %w", p.key, err))
 		}
+	}
+	return errors.Join(errs...)
+}
 
-		config = &cfg
-		return &cfg, nil
+func verifyClientPath(path string, client checksum.ChecksumClient) error {
+	fi, err := os.Stat(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("%s does not exist", path)
+		}
+		return fmt.Errorf("stat: %w", err)
+	}
+	if !fi.IsDir() {
+		return fmt.Errorf("%s is not a directory", path)
 	}
 
-	err = yaml.NewDecoder(f).Decode(&cfg)
+	identity, err := checksum.Identify(path)
 	if err != nil {
-		return nil, fmt.Errorf("decode %s.yaml: %w", baseName, err)
+		return fmt.Errorf("identify: %w", err)
 	}
+	for _, score := range identity.Scores {
+		if score.Client != client {
+			continue
+		}
+		if score.Confidence < checksum.IdentifyThreshold {
+			return fmt.Errorf("%s does not match the %s manifest, looks like %s", path, client.String(), identity)
+		}
+		return nil
+	}
+	return fmt.Errorf("no manifest for %s", client.String())
+}
 
-	config = &cfg
-	return &cfg, nil
+// Keys returns every setting key
+func (c *Config) Keys() []string {
+	keys := []string{}
+	t := reflect.TypeOf(*c)
+	for i := 0; i < t.NumField(); i++ {
+		key := yamlKey(t.Field(i))
+		if key == "" {
+			continue
+		}
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
 }
 
-// Verify returns an error if configuration appears off
-func (c *Config) Verify() error {
+// Value returns a setting by its yaml key
+func (c *Config) Value(key string) (string, error) {
+	field, err := c.field(key)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprint(field.Interface()), nil
+}
 
+// SetValue changes a setting by its yaml key. Version is managed by migrations and can't be set
+func (c *Config) SetValue(key string, value string) error {
+	if key == "version" {
+		return fmt.Errorf("version is read only")
+	}
+	field, err := c.field(key)
+	if err != nil {
+		return err
+	}
+	if field.Kind() != reflect.String {
+		return fmt.Errorf("%s is not a text setting", key)
+	}
+	field.SetString(value)
 	return nil
 }
 
+func (c *Config) field(key string) (reflect.Value, error) {
+	v := reflect.ValueOf(c).Elem()
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		if yamlKey(t.Field(i)) == strings.ToLower(key) {
+			return v.Field(i), nil
+		}
+	}
+	return reflect.Value{}, fmt.Errorf("unknown setting %s", key)
+}
+
+func yamlKey(field reflect.StructField) string {
+	if !field.IsExported() {
+		return ""
+	}
+	key, _, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+	return key
+}
+
+// Path returns where the config is saved
+func (c *Config) Path() string {
+	return c.path
+}
+
 // Save writes the config to disk
 func (c *Config) Save() error {
-	w, err := os.Create(fmt.Sprintf("%s.yaml", c.baseName))
+	err := os.MkdirAll(filepath.Dir(c.path), 0755)
+	if err != nil {
+		return fmt.Errorf("mkdir: %w", err)
+	}
+
+	w, err := os.Create(c.path)
 	if err != nil {
-		return fmt.Errorf("create %s.yaml: %w", c.baseName, err)
+		return fmt.Errorf("create %s: %w", filepath.Base(c.path), err)
 	}
 	defer w.Close()
 
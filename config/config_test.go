@@ -0,0 +1,126 @@
+package config
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestNewDefault(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rof2plus.yaml")
+
+	cfg, err := New(context.Background(), path)
+	if err != nil {
+		t.Fatalf("new: %v", err)
+	}
+	if cfg.Version != SchemaVersion {
+		t.Fatalf("version: got %d", cfg.Version)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if !strings.Contains(string(data), "version: 1") {
+		t.Fatalf("default config should be versioned: %s", data)
+	}
+}
+
+func TestNewMigratesUnversioned(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rof2plus.yaml")
+	old := "rof2path: /games/rof2\nlspath: /games/ls\n"
+	err := os.WriteFile(path, []byte(old), 0644)
+	if err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	cfg, err := New(context.Background(), path)
+	if err != nil {
+		t.Fatalf("new: %v", err)
+	}
+	if cfg.Version != SchemaVersion || cfg.RoF2Path != "/games/rof2" || cfg.LSPath != "/games/ls" {
+		t.Fatalf("unexpected config: %+v", cfg)
+	}
+
+	backup, err := os.ReadFile(path + ".v0.bak")
+	if err != nil || string(backup) != old {
+		t.Fatalf("backup: got %q %v", backup, err)
+	}
+
+	// loading again should not migrate or back up again
+	err = os.Remove(path + ".v0.bak")
+	if err != nil {
+		t.Fatalf("remove backup: %v", err)
+	}
+	_, err = New(context.Background(), path)
+	if err != nil {
+		t.Fatalf("reload: %v", err)
+	}
+	_, err = os.Stat(path + ".v0.bak")
+	if !os.IsNotExist(err) {
+		t.Fatalf("expected no second backup, got %v", err)
+	}
+}
+
+func TestNewRejectsNewerVersion(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rof2plus.yaml")
+	err := os.WriteFile(path, []byte("version: 99\n"), 0644)
+	if err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	_, err = New(context.Background(), path)
+	if err == nil || !strings.Contains(err.Error(), "newer") {
+		t.Fatalf("expected newer version error, got %v", err)
+	}
+}
+
+func TestValues(t *testing.T) {
+	cfg := Default()
+
+	err := cfg.SetValue("rof2path", "/games/rof2")
+	if err != nil {
+		t.Fatalf("set: %v", err)
+	}
+	value, err := cfg.Value("rof2path")
+	if err != nil || value != "/games/rof2" {
+		t.Fatalf("get: got %q %v", value, err)
+	}
+
+	err = cfg.SetValue("version", "5")
+	if err == nil {
+		t.Fatalf("expected version to be read only")
+	}
+	err = cfg.SetValue("nope", "x")
+	if err == nil {
+		t.Fatalf("expected unknown setting error")
+	}
+
+	keys := strings.Join(cfg.Keys(), ",")
+	if keys != "lancache,lspath,rof2corepath,rof2path,version" {
+		t.Fatalf("keys: got %s", keys)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	cfg := Default()
+	err := cfg.Verify()
+	if err != nil {
+		t.Fatalf("empty config should verify: %v", err)
+	}
+
+	cfg.RoF2Path = filepath.Join(t.TempDir(), "missing")
+	cfg.LSPath = t.TempDir()
+	err = cfg.Verify()
+	if err == nil {
+		t.Fatalf("expected errors")
+	}
+	if !strings.Contains(err.Error(), "rof2path") || !strings.Contains(err.Error(), "does not exist") {
+		t.Fatalf("expected missing rof2path, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "lspath") || !strings.Contains(err.Error(), "does not match") {
+		t.Fatalf("expected lspath manifest mismatch, got %v", err)
+	}
+}
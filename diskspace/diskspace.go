@@ -0,0 +1,149 @@
+// diskspace checks the bytes an operation will write against the free space of the target volumes before it starts
+package diskspace
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ErrInsufficient is returned when a volume does not have room for an operation
+var ErrInsufficient = errors.New("not enough disk space")
+
+// lowMargin is how much free space should remain after an operation before a warning is printed
+const lowMargin = 512 * 1024 * 1024
+
+// Need is space an operation requires under Path, which does not need to exist yet
+type Need struct {
+	Path string
+	// Reason describes the bytes, e.g. downloads or backups
+	Reason string
+	Bytes  int64
+}
+
+// Volume sums the needs that land on one volume
+type Volume struct {
+	// Path is the first path seen on the volume
+	Path   string
+	Free   int64
+	Needed int64
+	Needs  []Need
+}
+
+// IsLow is true when the volume has room, but little remains afterwards
+func (e *Volume) IsLow() bool {
+	return e.Needed <= e.Free && e.Free-e.Needed < lowMargin
+}
+
+func (e *Volume) String() string {
+	reasons := []string{}
+	for _, need := range e.Needs {
+		reasons = append(reasons, fmt.Sprintf("%s %s", need.Reason, Format(need.Bytes)))
+	}
+	return fmt.Sprintf("%s needs %s, %s free (%s)", e.Path, Format(e.Needed), Format(e.Free), strings.Join(reasons, ", "))
+}
+
+// Report is the result of a preflight
+type Report struct {
+	Volumes []*Volume
+}
+
+// Warnings returns a line for each volume that will be nearly full afterwards
+func (e *Report) Warnings() []string {
+	warnings := []string{}
+	for _, volume := range e.Volumes {
+		if volume.IsLow() {
+			warnings = append(warnings, "Low disk space: "+volume.String())
+		}
+	}
+	return warnings
+}
+
+// Check groups needs by volume and returns ErrInsufficient, describing every short volume,
+// if any of them lacks the room. Needs of zero bytes are ignored
+func Check(needs ...Need) (*Report, error) {
+	report := &Report{}
+	volumes := map[string]*Volume{}
+	for _, need := range needs {
+		if need.Bytes <= 0 {
+			continue
+		}
+		path, err := existingParent(need.Path)
+		if err != nil {
+			return nil, err
+		}
+		id, err := volumeID(path)
+		if err != nil {
+			return nil, fmt.Errorf("volume of %s: %w", need.Path, err)
+		}
+		volume, ok := volumes[id]
+		if !ok {
+			free, err := freeBytes(path)
+			if err != nil {
+				return nil, fmt.Errorf("free space of %s: %w", need.Path, err)
+			}
+			volume = &Volume{Path: need.Path, Free: free}
+			volumes[id] = volume
+			report.Volumes = append(report.Volumes, volume)
+		}
+		volume.Needed += need.Bytes
+		volume.Needs = append(volume.Needs, need)
+	}
+
+	short := []string{}
+	for _, volume := range report.Volumes {
+		if volume.Needed > volume.Free {
+			short = append(short, volume.String())
+		}
+	}
+	if len(short) > 0 {
+		sort.Strings(short)
+		return report, fmt.Errorf("%w: %s", ErrInsufficient, strings.Join(short, "; "))
+	}
+	return report, nil
+}
+
+// Free returns the bytes available to this user on the volume holding path, which does not need to exist yet
+func Free(path string) (int64, error) {
+	path, err := existingParent(path)
+	if err != nil {
+		return 0, err
+	}
+	return freeBytes(path)
+}
+
+// existingParent returns path or its closest parent that exists
+func existingParent(path string) (string, error) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return "", fmt.Errorf("abs %s: %w", path, err)
+	}
+	for {
+		_, err := os.Stat(path)
+		if err == nil {
+			return path, nil
+		}
+		if !os.IsNotExist(err) {
+			return "", fmt.Errorf("stat %s: %w", path, err)
+		}
+		parent := filepath.Dir(path)
+		if parent == path {
+			return "", fmt.Errorf("no existing parent of %s", path)
+		}
+		path = parent
+	}
+}
+
+// Format returns bytes in a human readable unit
+func Format(bytes int64) string {
+	switch {
+	case bytes >= 1024*1024*1024:
+		return fmt.Sprintf("%0.2f GB", float64(bytes)/1024/1024/1024)
+	case bytes >= 1024*1024:
+		return fmt.Sprintf("%0.2f MB", float64(bytes)/1024/1024)
+	}
+	return fmt.Sprintf("%0.2f KB", float64(bytes)/1024)
+}
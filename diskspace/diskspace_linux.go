@@ -0,0 +1,26 @@
+package diskspace
+
+import (
+	"fmt"
+
+	"golang.org/x/sys/unix"
+)
+
+func freeBytes(path string) (int64, error) {
+	stat := unix.Statfs_t{}
+	err := unix.Statfs(path, &stat)
+	if err != nil {
+		return 0, err
+	}
+	return int64(stat.Bavail) * int64(stat.Bsize), nil
+}
+
+// volumeID identifies the filesystem holding path by its device
+func volumeID(path string) (string, error) {
+	stat := unix.Stat_t{}
+	err := unix.Stat(path, &stat)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%d", stat.Dev), nil
+}
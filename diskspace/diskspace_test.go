@@ -0,0 +1,36 @@
+package diskspace
+
+import (
+	"errors"
+	"math"
+	"path/filepath"
+	"testing"
+)
+
+func TestCheck(t *testing.T) {
+	dir := t.TempDir()
+	free, err := Free(filepath.Join(dir, "not", "created"))
+	if err != nil {
+		t.Fatalf("free: %v", err)
+	}
+	if free <= 0 {
+		t.Fatalf("expected free space, got %d", free)
+	}
+
+	report, err := Check(
+		Need{Path: filepath.Join(dir, "server"), Reason: "downloads", Bytes: 1024},
+		Need{Path: filepath.Join(dir, "backups"), Reason: "backups", Bytes: 2048},
+		Need{Path: dir, Reason: "nothing"},
+	)
+	if err != nil {
+		t.Fatalf("check: %v", err)
+	}
+	if len(report.Volumes) != 1 || report.Volumes[0].Needed != 3072 || len(report.Volumes[0].Needs) != 2 {
+		t.Fatalf("expected needs on one volume, got %+v", report.Volumes)
+	}
+
+	_, err = Check(Need{Path: dir, Reason: "downloads", Bytes: math.MaxInt64})
+	if !errors.Is(err, ErrInsufficient) {
+		t.Fatalf("expected insufficient space, got %v", err)
+	}
+}
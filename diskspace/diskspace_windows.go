@@ -0,0 +1,26 @@
+package diskspace
+
+import (
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/sys/windows"
+)
+
+func freeBytes(path string) (int64, error) {
+	pathPtr, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return 0, err
+	}
+	var freeToCaller, total, totalFree uint64
+	err = windows.GetDiskFreeSpaceEx(pathPtr, &freeToCaller, &total, &totalFree)
+	if err != nil {
+		return 0, err
+	}
+	return int64(freeToCaller), nil
+}
+
+// volumeID identifies the volume holding path by its drive letter or UNC share
+func volumeID(path string) (string, error) {
+	return strings.ToLower(filepath.VolumeName(path)), nil
+}
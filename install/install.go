@@ -0,0 +1,316 @@
+// install places rof2plus in a per-user location with shortcuts, and removes everything it created on uninstall
+package install
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Layout describes where an install puts its files
+type Layout struct {
+	// Dir is the install directory holding the binary
+	Dir string
+	// Binary is the installed executable
+	Binary string
+	// Shortcuts are desktop entries or shortcut files that launch rof2plus
+	Shortcuts []string
+	// Record remembers what was installed so it can be found and removed later
+	Record string
+	goos   string
+}
+
+// Record is written on install and lists everything the install created
+type Record struct {
+	Dir       string    `yaml:"dir"`
+	Binary    string    `yaml:"binary"`
+	Installed time.Time `yaml:"installed"`
+	// Files are removed on uninstall
+	Files []string `yaml:"files"`
+	// Dirs were created by the install and are removed on uninstall if left empty
+	Dirs []string `yaml:"dirs"`
+}
+
+// DefaultLayout returns the install layout for this OS, %LOCALAPPDATA%\rof2plus on windows and ~/.local/share/rof2plus elsewhere
+func DefaultLayout() (*Layout, error) {
+	return layoutFor(runtime.GOOS, os.Getenv, "")
+}
+
+// LayoutAt returns the install layout for this OS with the binary placed in dir
+func LayoutAt(dir string) (*Layout, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, fmt.Errorf("abs: %w", err)
+	}
+	return layoutFor(runtime.GOOS, os.Getenv, dir)
+}
+
+func layoutFor(goos string, getenv func(string) string, dir string) (*Layout, error) {
+	layout := &Layout{goos: goos}
+	switch goos {
+	case "windows":
+		localAppData := getenv("LOCALAPPDATA")
+		appData := getenv("APPDATA")
+		userProfile := getenv("USERPROFILE")
+		if localAppData == "" || appData == "" || userProfile == "" {
+			return nil, fmt.Errorf("LOCALAPPDATA, APPDATA and USERPROFILE must be set")
+		}
+		if dir == "" {
+			dir = filepath.Join(localAppData, "rof2plus")
+		}
+		layout.Dir = dir
+		layout.Binary = filepath.Join(dir, "rof2plus.exe")
+		layout.Shortcuts = []string{
+			filepath.Join(userProfile, "Desktop", "rof2plus.url"),
+			filepath.Join(appData, "Microsoft", "Windows", "Start Menu", "Programs", "rof2plus.url"),
+		}
+		layout.Record = filepath.Join(appData, "rof2plus", "install.yaml")
+	default:
+		home := getenv("HOME")
+		if home == "" {
+			return nil, fmt.Errorf("HOME must be set")
+		}
+		dataHome := getenv("XDG_DATA_HOME")
+		if dataHome == "" {
+			dataHome = filepath.Join(home, ".local", "share")
+		}
+		configHome := getenv("XDG_CONFIG_HOME")
+		if configHome == "" {
+			configHome = filepath.Join(home, ".config")
+		}
+		if dir == "" {
+			dir = filepath.Join(dataHome, "rof2plus")
+		}
+		layout.Dir = dir
+		layout.Binary = filepath.Join(dir, "rof2plus")
+		layout.Shortcuts = []string{
+			filepath.Join(dataHome, "applications", "rof2plus.desktop"),
+		}
+		layout.Record = filepath.Join(configHome, "rof2plus", "install.yaml")
+	}
+	return layout, nil
+}
+
+// Installed returns the install record, or nil if rof2plus is not installed
+func (e *Layout) Installed() (*Record, error) {
+	data, err := os.ReadFile(e.Record)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("read record: %w", err)
+	}
+
+	record := &Record{}
+	err = yaml.Unmarshal(data, record)
+	if err != nil {
+		return nil, fmt.Errorf("decode record: %w", err)
+	}
+	return record, nil
+}
+
+// Install copies exePath into the layout, creates shortcuts, and records what was created
+func (e *Layout) Install(exePath string) (*Record, error) {
+	record := &Record{
+		Dir:       e.Dir,
+		Binary:    e.Binary,
+		Installed: time.Now(),
+	}
+
+	previous, err := e.Installed()
+	if err != nil {
+		return nil, err
+	}
+	if previous != nil {
+		// keep track of what an earlier install created so uninstall still removes it
+		record.Files = append(record.Files, previous.Files...)
+		record.Dirs = append(record.Dirs, previous.Dirs...)
+	}
+
+	err = e.mkdirAll(record, e.Dir)
+	if err != nil {
+		return nil, err
+	}
+
+	err = copyExecutable(exePath, e.Binary)
+	if err != nil {
+		return nil, fmt.Errorf("copy binary: %w", err)
+	}
+	record.addFile(e.Binary)
+
+	for _, shortcut := range e.Shortcuts {
+		err = e.mkdirAll(record, filepath.Dir(shortcut))
+		if err != nil {
+			return nil, err
+		}
+		err = os.WriteFile(shortcut, []byte(e.shortcutContent()), 0644)
+		if err != nil {
+			return nil, fmt.Errorf("write shortcut: %w", err)
+		}
+		record.addFile(shortcut)
+	}
+
+	err = e.mkdirAll(record, filepath.Dir(e.Record))
+	if err != nil {
+		return nil, err
+	}
+	record.addFile(e.Record)
+
+	data, err := yaml.Marshal(record)
+	if err != nil {
+		return nil, fmt.Errorf("encode record: %w", err)
+	}
+	err = os.WriteFile(e.Record, data, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("write record: %w", err)
+	}
+	return record, nil
+}
+
+// Uninstall removes every file and directory the install recorded. Files created later, such as
+// downloaded clients, are left alone along with the directories holding them
+func (e *Layout) Uninstall() error {
+	record, err := e.Installed()
+	if err != nil {
+		return err
+	}
+	if record == nil {
+		return fmt.Errorf("rof2plus is not installed")
+	}
+
+	exePath, _ := os.Executable()
+	for _, path := range record.Files {
+		err = os.Remove(path)
+		if err == nil || os.IsNotExist(err) {
+			continue
+		}
+		if e.goos == "windows" && exePath != "" && strings.EqualFold(filepath.Clean(exePath), filepath.Clean(path)) {
+			// windows won't delete a running executable, move it out of the way instead
+			err = os.Rename(path, filepath.Join(os.TempDir(), fmt.Sprintf("rof2plus-uninstalled-%d.exe", time.Now().Unix())))
+			if err == nil {
+				continue
+			}
+		}
+		return fmt.Errorf("remove %s: %w", path, err)
+	}
+
+	// deepest directories first so parents empty out
+	dirs := append([]string{}, record.Dirs...)
+	sort.Slice(dirs, func(i, j int) bool { return len(dirs[i]) > len(dirs[j]) })
+	for _, dir := range dirs {
+		entries, err := os.ReadDir(dir)
+		if err != nil || len(entries) > 0 {
+			continue
+		}
+		err = os.Remove(dir)
+		if err != nil {
+			return fmt.Errorf("remove %s: %w", dir, err)
+		}
+	}
+	return nil
+}
+
+// mkdirAll creates dir and records each directory that did not exist yet
+func (e *Layout) mkdirAll(record *Record, dir string) error {
+	missing := []string{}
+	for path := dir; ; path = filepath.Dir(path) {
+		_, err := os.Stat(path)
+		if err == nil {
+			break
+		}
+		if !os.IsNotExist(err) {
+			return fmt.Errorf("stat %s: %w", path, err)
+		}
+		missing = append(missing, path)
+		if filepath.Dir(path) == path {
+			break
+		}
+	}
+
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return fmt.Errorf("mkdir %s: %w", dir, err)
+	}
+	for _, path := range missing {
+		record.addDir(path)
+	}
+	return nil
+}
+
+func (e *Layout) shortcutContent() string {
+	if e.goos == "windows" {
+		return fmt.Sprintf("[InternetShortcut]\r\nURL=file:///%s\r\nWorkingDirectory=%s\r\nIconFile=%s\r\nIconIndex=0\r\n", filepath.ToSlash(e.Binary), e.Dir, e.Binary)
+	}
+	return fmt.Sprintf(`[Desktop Entry]
+Type=Application
+Name=rof2plus
+Comment=Rain of Fear client validator and patcher
+Exec="%s" start
+Path=%s
+Terminal=true
+Categories=Game;
+`, e.Binary, e.Dir)
+}
+
+func (e *Record) addFile(path string) {
+	for _, file := range e.Files {
+		if file == path {
+			return
+		}
+	}
+	e.Files = append(e.Files, path)
+}
+
+func (e *Record) addDir(path string) {
+	for _, dir := range e.Dirs {
+		if dir == path {
+			return
+		}
+	}
+	e.Dirs = append(e.Dirs, path)
+}
+
+// copyExecutable streams src to dst through a temporary file so a failed copy never leaves a broken binary
+func copyExecutable(src string, dst string) error {
+	if filepath.Clean(src) == filepath.Clean(dst) {
+		return nil
+	}
+
+	r, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("open: %w", err)
+	}
+	defer r.Close()
+
+	tmpPath := dst + ".tmp"
+	w, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0755)
+	if err != nil {
+		return fmt.Errorf("create: %w", err)
+	}
+
+	_, err = io.Copy(w, r)
+	if err != nil {
+		w.Close()
+		os.Remove(tmpPath)
+		return fmt.Errorf("copy: %w", err)
+	}
+	err = w.Close()
+	if err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("close: %w", err)
+	}
+
+	err = os.Rename(tmpPath, dst)
+	if err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("rename: %w", err)
+	}
+	return nil
+}
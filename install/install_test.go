@@ -0,0 +1,136 @@
+package install
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func testEnv(env map[string]string) func(string) string {
+	return func(key string) string {
+		return env[key]
+	}
+}
+
+func TestLayoutLinux(t *testing.T) {
+	layout, err := layoutFor("linux", testEnv(map[string]string{"HOME": "/home/player"}), "")
+	if err != nil {
+		t.Fatalf("layout: %v", err)
+	}
+	if layout.Dir != "/home/player/.local/share/rof2plus" {
+		t.Fatalf("dir: got %s", layout.Dir)
+	}
+	if layout.Binary != "/home/player/.local/share/rof2plus/rof2plus" {
+		t.Fatalf("binary: got %s", layout.Binary)
+	}
+	if len(layout.Shortcuts) != 1 || layout.Shortcuts[0] != "/home/player/.local/share/applications/rof2plus.desktop" {
+		t.Fatalf("shortcuts: got %v", layout.Shortcuts)
+	}
+	if layout.Record != "/home/player/.config/rof2plus/install.yaml" {
+		t.Fatalf("record: got %s", layout.Record)
+	}
+
+	layout, err = layoutFor("linux", testEnv(map[string]string{"HOME": "/home/player", "XDG_DATA_HOME": "/data", "XDG_CONFIG_HOME": "/config"}), "")
+	if err != nil {
+		t.Fatalf("layout: %v", err)
+	}
+	if layout.Dir != "/data/rof2plus" || layout.Record != "/config/rof2plus/install.yaml" {
+		t.Fatalf("xdg layout: got %+v", layout)
+	}
+
+	_, err = layoutFor("linux", testEnv(nil), "")
+	if err == nil {
+		t.Fatalf("expected error without HOME")
+	}
+}
+
+func TestInstallUninstall(t *testing.T) {
+	home := t.TempDir()
+	// applications already exists on most desktops and must survive uninstall
+	err := os.MkdirAll(filepath.Join(home, ".local", "share", "applications"), 0755)
+	if err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+
+	exePath := filepath.Join(t.TempDir(), "rof2plus")
+	err = os.WriteFile(exePath, []byte("binary"), 0755)
+	if err != nil {
+		t.Fatalf("write exe: %v", err)
+	}
+
+	layout, err := layoutFor("linux", testEnv(map[string]string{"HOME": home}), "")
+	if err != nil {
+		t.Fatalf("layout: %v", err)
+	}
+
+	record, err := layout.Installed()
+	if err != nil || record != nil {
+		t.Fatalf("expected no install, got %v %v", record, err)
+	}
+
+	record, err = layout.Install(exePath)
+	if err != nil {
+		t.Fatalf("install: %v", err)
+	}
+
+	data, err := os.ReadFile(layout.Binary)
+	if err != nil || string(data) != "binary" {
+		t.Fatalf("binary: got %q %v", data, err)
+	}
+	fi, err := os.Stat(layout.Binary)
+	if err != nil || fi.Mode().Perm()&0100 == 0 {
+		t.Fatalf("binary should be executable: %v %v", fi.Mode(), err)
+	}
+	data, err = os.ReadFile(layout.Shortcuts[0])
+	if err != nil || !strings.Contains(string(data), "Exec=\""+layout.Binary+"\" start") {
+		t.Fatalf("desktop entry: got %q %v", data, err)
+	}
+
+	installed, err := layout.Installed()
+	if err != nil || installed == nil || installed.Dir != record.Dir {
+		t.Fatalf("installed: got %+v %v", installed, err)
+	}
+
+	// reinstalling keeps one entry per file
+	_, err = layout.Install(exePath)
+	if err != nil {
+		t.Fatalf("reinstall: %v", err)
+	}
+	installed, err = layout.Installed()
+	if err != nil || len(installed.Files) != len(record.Files) {
+		t.Fatalf("reinstall record: got %+v %v", installed, err)
+	}
+
+	// a file the user created in the install dir must not be removed
+	userFile := filepath.Join(layout.Dir, "rof2plus.yaml")
+	err = os.WriteFile(userFile, []byte("rof2path: x"), 0644)
+	if err != nil {
+		t.Fatalf("write user file: %v", err)
+	}
+
+	err = layout.Uninstall()
+	if err != nil {
+		t.Fatalf("uninstall: %v", err)
+	}
+
+	for _, path := range append([]string{layout.Binary, layout.Record, filepath.Dir(layout.Record)}, layout.Shortcuts...) {
+		_, err = os.Stat(path)
+		if !os.IsNotExist(err) {
+			t.Fatalf("%s should be removed, got %v", path, err)
+		}
+	}
+	_, err = os.Stat(userFile)
+	if err != nil {
+		t.Fatalf("user file should remain: %v", err)
+	}
+	_, err = os.Stat(filepath.Join(home, ".local", "share", "applications"))
+	if err != nil {
+		t.Fatalf("applications dir should remain: %v", err)
+	}
+
+	err = layout.Uninstall()
+	if err == nil {
+		t.Fatalf("expected error uninstalling twice")
+	}
+}
@@ -0,0 +1,78 @@
+// testfiles writes and compares the small client directories tests work on
+package testfiles
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Write creates files, keyed by slash separated names, under dir
+func Write(t testing.TB, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		WriteFile(t, filepath.Join(dir, filepath.FromSlash(name)), content)
+	}
+}
+
+// WriteFile creates the file at path and its parent directories
+func WriteFile(t testing.TB, path string, content string) {
+	t.Helper()
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	err = os.WriteFile(path, []byte(content), 0644)
+	if err != nil {
+		t.Fatalf("write: %v", err)
+	}
+}
+
+// Assert checks every one of files is under dir with its content
+func Assert(t testing.TB, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		data, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(name)))
+		if err != nil {
+			t.Fatalf("read %s: %v", name, err)
+		}
+		if string(data) != content {
+			t.Fatalf("%s: got %q want %q", name, data, content)
+		}
+	}
+}
+
+// AssertOnly checks dir holds exactly files
+func AssertOnly(t testing.TB, dir string, files map[string]string) {
+	t.Helper()
+	Assert(t, dir, files)
+	count := 0
+	err := filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		if _, ok := files[filepath.ToSlash(rel)]; !ok {
+			t.Fatalf("unexpected file %s", filepath.ToSlash(rel))
+		}
+		count++
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("walk: %v", err)
+	}
+	if count != len(files) {
+		t.Fatalf("got %d files, want %d", count, len(files))
+	}
+}
+
+// MD5 returns the hex md5 of content, as file lists and manifests record it
+func MD5(content string) string {
+	sum := md5.Sum([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
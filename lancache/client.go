@@ -0,0 +1,105 @@
+package lancache
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrNotFound is returned when no cache answers discovery
+var ErrNotFound = errors.New("no lan cache found")
+
+// FileURL returns the cache url of a file with hash, fetched from upstream on a miss
+func FileURL(cacheURL string, hash string, upstream string) string {
+	return fmt.Sprintf("%s/file/%s?url=%s", strings.TrimSuffix(cacheURL, "/"), strings.ToLower(hash), url.QueryEscape(upstream))
+}
+
+// FileListBase returns a base url that serves the file list of patchURL through the cache
+func FileListBase(cacheURL string, patchURL string) string {
+	return fmt.Sprintf("%s/filelist/%s", strings.TrimSuffix(cacheURL, "/"), base64.RawURLEncoding.EncodeToString([]byte(strings.TrimSuffix(patchURL, "/"))))
+}
+
+// Ping returns an error if cacheURL is not a rof2plus cache
+func Ping(ctx context.Context, cacheURL string) error {
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", strings.TrimSuffix(cacheURL, "/")+"/ping", nil)
+	if err != nil {
+		return fmt.Errorf("create request: %w", err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("ping %s: %w", cacheURL, err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 64))
+	if err != nil {
+		return fmt.Errorf("read ping: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK || string(data) != pingReply {
+		return fmt.Errorf("%s is not a rof2plus cache", cacheURL)
+	}
+	return nil
+}
+
+// Discover broadcasts on the LAN and returns the url of the first cache to answer within timeout
+func Discover(ctx context.Context, timeout time.Duration) (string, error) {
+	return discover(ctx, fmt.Sprintf("255.255.255.255:%d", DiscoveryPort), timeout)
+}
+
+func discover(ctx context.Context, addr string, timeout time.Duration) (string, error) {
+	target, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return "", fmt.Errorf("resolve: %w", err)
+	}
+	conn, err := net.ListenUDP("udp", nil)
+	if err != nil {
+		return "", fmt.Errorf("listen: %w", err)
+	}
+	defer conn.Close()
+
+	deadline := time.Now().Add(timeout)
+	ctxDeadline, ok := ctx.Deadline()
+	if ok && ctxDeadline.Before(deadline) {
+		deadline = ctxDeadline
+	}
+	err = conn.SetDeadline(deadline)
+	if err != nil {
+		return "", fmt.Errorf("deadline: %w", err)
+	}
+
+	_, err = conn.WriteTo([]byte(discoveryRequest), target)
+	if err != nil {
+		return "", fmt.Errorf("broadcast: %w", err)
+	}
+
+	buf := make([]byte, 64)
+	for {
+		n, from, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				return "", ErrNotFound
+			}
+			return "", fmt.Errorf("read: %w", err)
+		}
+		reply, ok := strings.CutPrefix(string(buf[:n]), discoveryReply)
+		if !ok {
+			continue
+		}
+		port, err := strconv.Atoi(reply)
+		if err != nil || port <= 0 || port > 65535 {
+			continue
+		}
+		return fmt.Sprintf("http://%s", net.JoinHostPort(from.IP.String(), strconv.Itoa(port))), nil
+	}
+}
@@ -0,0 +1,217 @@
+package lancache
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/xackery/rof2plus/internal/testfiles"
+)
+
+func get(t *testing.T, url string) (int, string) {
+	t.Helper()
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatalf("get %s: %v", url, err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	return resp.StatusCode, string(data)
+}
+
+func TestServeFile(t *testing.T) {
+	var hits atomic.Int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		switch r.URL.Path {
+		case "/spells_us.txt":
+			w.Write([]byte("spell data"))
+		case "/corrupt.txt":
+			w.Write([]byte("not what the list says"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer upstream.Close()
+
+	srv := New(t.TempDir())
+	srv.Mirrors = []string{upstream.URL + "/"}
+	cache := httptest.NewServer(srv)
+	defer cache.Close()
+
+	hash := testfiles.MD5("spell data")
+	fileURL := FileURL(cache.URL, hash, upstream.URL+"/spells_us.txt")
+
+	wg := sync.WaitGroup{}
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := http.Get(fileURL)
+			if err != nil {
+				t.Errorf("get: %v", err)
+				return
+			}
+			defer resp.Body.Close()
+			data, _ := io.ReadAll(resp.Body)
+			if resp.StatusCode != http.StatusOK || string(data) != "spell data" {
+				t.Errorf("got %d %q", resp.StatusCode, data)
+			}
+		}()
+	}
+	wg.Wait()
+	if hits.Load() != 1 {
+		t.Fatalf("expected one upstream download, got %d", hits.Load())
+	}
+
+	// cached by md5, so the upstream url no longer matters
+	status, body := get(t, FileURL(cache.URL, hash, upstream.URL+"/missing.txt"))
+	if status != http.StatusOK || body != "spell data" {
+		t.Fatalf("cached: got %d %q", status, body)
+	}
+
+	status, _ = get(t, FileURL(cache.URL, testfiles.MD5("expected"), upstream.URL+"/corrupt.txt"))
+	if status != http.StatusBadGateway {
+		t.Fatalf("corrupt upstream: got %d", status)
+	}
+	status, _ = get(t, cache.URL+"/file/nothex?url="+upstream.URL)
+	if status != http.StatusBadRequest {
+		t.Fatalf("invalid md5: got %d", status)
+	}
+	status, _ = get(t, FileURL(cache.URL, testfiles.MD5("x"), "file:///etc/passwd"))
+	if status != http.StatusBadRequest {
+		t.Fatalf("invalid upstream: got %d", status)
+	}
+	status, _ = get(t, FileURL(cache.URL, testfiles.MD5("x"), "http://169.254.169.254/latest/meta-data"))
+	if status != http.StatusForbidden {
+		t.Fatalf("unknown upstream: got %d", status)
+	}
+}
+
+func TestServeFileLearnsPrefix(t *testing.T) {
+	files := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("spell data"))
+	}))
+	defer files.Close()
+	patch := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/patch/rof2plus_filelist.yml" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte("downloadprefix: " + files.URL + "/rof/\n"))
+	}))
+	defer patch.Close()
+
+	srv := New(t.TempDir())
+	srv.PatchURLs = []string{patch.URL + "/patch/"}
+	cache := httptest.NewServer(srv)
+	defer cache.Close()
+
+	// the download prefix is learned from the server's file list on the first miss
+	status, body := get(t, FileURL(cache.URL, testfiles.MD5("spell data"), files.URL+"/rof/spells_us.txt"))
+	if status != http.StatusOK || body != "spell data" {
+		t.Fatalf("got %d %q", status, body)
+	}
+	for _, upstream := range []string{files.URL + "/other/spells_us.txt", files.URL + "/rof/../other/spells_us.txt"} {
+		status, _ = get(t, FileURL(cache.URL, testfiles.MD5("other"), upstream))
+		if status != http.StatusForbidden {
+			t.Fatalf("%s: got %d", upstream, status)
+		}
+	}
+}
+
+func TestServeFileList(t *testing.T) {
+	isDown := atomic.Bool{}
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isDown.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		if r.URL.Path != "/patch/rof2plus_filelist.yml" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte("version: \"7\"\n"))
+	}))
+	defer upstream.Close()
+
+	srv := New(t.TempDir())
+	srv.PatchURLs = []string{upstream.URL + "/patch", upstream.URL + "/other"}
+	cache := httptest.NewServer(srv)
+	defer cache.Close()
+
+	base := FileListBase(cache.URL, upstream.URL+"/patch/")
+	status, body := get(t, base+"/rof2plus_filelist.yml")
+	if status != http.StatusOK || body != "version: \"7\"\n" {
+		t.Fatalf("got %d %q", status, body)
+	}
+
+	isDown.Store(true)
+	status, body = get(t, base+"/rof2plus_filelist.yml")
+	if status != http.StatusOK || !strings.Contains(body, "7") {
+		t.Fatalf("expected last copy while upstream is down, got %d %q", status, body)
+	}
+
+	status, _ = get(t, FileListBase(cache.URL, upstream.URL+"/other")+"/rof2plus_filelist.yml")
+	if status != http.StatusBadGateway {
+		t.Fatalf("uncached list with upstream down: got %d", status)
+	}
+	status, _ = get(t, FileListBase(cache.URL, "http://127.0.0.1:1/unknown")+"/rof2plus_filelist.yml")
+	if status != http.StatusForbidden {
+		t.Fatalf("unknown patch url: got %d", status)
+	}
+}
+
+func TestPing(t *testing.T) {
+	cache := httptest.NewServer(New(t.TempDir()))
+	defer cache.Close()
+	other := httptest.NewServer(http.NotFoundHandler())
+	defer other.Close()
+
+	err := Ping(context.Background(), cache.URL)
+	if err != nil {
+		t.Fatalf("ping: %v", err)
+	}
+	err = Ping(context.Background(), other.URL)
+	if err == nil {
+		t.Fatalf("expected non cache to fail ping")
+	}
+}
+
+func TestDiscover(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	go answerDiscovery(conn, 8123)
+
+	cacheURL, err := discover(context.Background(), conn.LocalAddr().String(), time.Second)
+	conn.Close()
+	if err != nil {
+		t.Fatalf("discover: %v", err)
+	}
+	if cacheURL != "http://127.0.0.1:8123" {
+		t.Fatalf("got %s", cacheURL)
+	}
+
+	silent, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer silent.Close()
+	_, err = discover(context.Background(), silent.LocalAddr().String(), 100*time.Millisecond)
+	if !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected not found, got %v", err)
+	}
+}
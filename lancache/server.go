@@ -0,0 +1,430 @@
+// lancache serves patch files to a LAN from a single upstream download, content addressed by md5
+package lancache
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// DiscoveryPort is the UDP port a cache answers discovery broadcasts on
+const DiscoveryPort = 41980
+
+// DefaultAddr is the HTTP address serve-cache listens on by default
+const DefaultAddr = ":41981"
+
+const (
+	discoveryRequest = "rof2plus-cache?"
+	discoveryReply   = "rof2plus-cache "
+	pingReply        = "rof2plus-cache"
+	fileListName     = "rof2plus_filelist.yml"
+)
+
+var md5Pattern = regexp.MustCompile(`^[0-9a-f]{32}$`)
+
+// learnInterval limits how often file lists are fetched to learn their download prefixes
+const learnInterval = time.Minute
+
+// Server is a caching proxy for file lists and patch files. It only fetches from known servers,
+// so a player on the LAN can not use it to reach arbitrary hosts
+type Server struct {
+	// Dir stores cached files
+	Dir    string
+	Client *http.Client
+	// PatchURLs are the file list bases the cache proxies. Files are fetched from the download
+	// prefixes their file lists name
+	PatchURLs []string
+	// Mirrors are further download prefixes files may be fetched from
+	Mirrors []string
+
+	mux   sync.Mutex
+	locks map[string]*sync.Mutex
+	// prefixes are download prefixes learned from file lists
+	prefixes  map[string]bool
+	learnedAt time.Time
+}
+
+// New returns a server caching into dir
+func New(dir string) *Server {
+	return &Server{
+		Dir:    dir,
+		Client: &http.Client{Timeout: 30 * time.Minute},
+		locks:  map[string]*sync.Mutex{},
+	}
+}
+
+// ServeHTTP handles /ping, /file/<md5>?url=<upstream> and /filelist/<base>/rof2plus_filelist.yml
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	switch {
+	case r.URL.Path == "/ping":
+		fmt.Fprint(w, pingReply)
+	case strings.HasPrefix(r.URL.Path, "/file/"):
+		s.serveFile(w, r, strings.TrimPrefix(r.URL.Path, "/file/"))
+	case strings.HasPrefix(r.URL.Path, "/filelist/"):
+		encoded, name, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/filelist/"), "/")
+		if name != fileListName {
+			http.NotFound(w, r)
+			return
+		}
+		s.serveFileList(w, r, encoded)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (s *Server) serveFile(w http.ResponseWriter, r *http.Request, hash string) {
+	hash = strings.ToLower(hash)
+	if !md5Pattern.MatchString(hash) {
+		http.Error(w, "invalid md5", http.StatusBadRequest)
+		return
+	}
+
+	path := filepath.Join(s.Dir, "files", hash[:2], hash)
+	unlock := s.lock(hash)
+	_, err := os.Stat(path)
+	if err != nil {
+		upstream, uerr := upstreamURL(r.URL.Query().Get("url"))
+		if uerr != nil {
+			unlock()
+			http.Error(w, uerr.Error(), http.StatusBadRequest)
+			return
+		}
+		if !s.isFileAllowed(r.Context(), upstream) {
+			unlock()
+			slog.Warn("refused upstream", "url", upstream)
+			http.Error(w, fmt.Sprintf("%s is not a known download prefix", upstream), http.StatusForbidden)
+			return
+		}
+		slog.Debug("cache miss", "md5", hash, "url", upstream)
+		err = s.fetchFile(r.Context(), upstream.String(), hash, path)
+		if err != nil {
+			slog.Warn("upstream download failed", "url", upstream, "err", err)
+			unlock()
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+	}
+	unlock()
+
+	w.Header().Set("ETag", `"`+hash+`"`)
+	http.ServeFile(w, r, path)
+}
+
+// fetchFile downloads upstream to path, keeping it only if it matches hash
+func (s *Server) fetchFile(ctx context.Context, upstream string, hash string, path string) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", upstream, nil)
+	if err != nil {
+		return fmt.Errorf("create request: %w", err)
+	}
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("download %s: %w", upstream, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("download %s responded HTTP status code %d", upstream, resp.StatusCode)
+	}
+
+	err = os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return fmt.Errorf("mkdir: %w", err)
+	}
+	partPath := path + ".part"
+	w, err := os.Create(partPath)
+	if err != nil {
+		return fmt.Errorf("create: %w", err)
+	}
+	h := md5.New()
+	_, err = io.Copy(io.MultiWriter(w, h), resp.Body)
+	closeErr := w.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(partPath)
+		return fmt.Errorf("write: %w", err)
+	}
+
+	got := hex.EncodeToString(h.Sum(nil))
+	if got != hash {
+		os.Remove(partPath)
+		return fmt.Errorf("%s has md5 %s, expected %s", upstream, got, hash)
+	}
+	err = os.Rename(partPath, path)
+	if err != nil {
+		return fmt.Errorf("rename: %w", err)
+	}
+	return nil
+}
+
+// serveFileList proxies a file list, keeping the last copy to serve if the upstream is down
+func (s *Server) serveFileList(w http.ResponseWriter, r *http.Request, encoded string) {
+	data, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		http.Error(w, "invalid file list base", http.StatusBadRequest)
+		return
+	}
+	base := strings.TrimSuffix(string(data), "/")
+	_, err = upstreamURL(base + "/" + fileListName)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !s.isPatchURL(base) {
+		slog.Warn("refused file list", "url", base)
+		http.Error(w, fmt.Sprintf("%s is not a known patch url", base), http.StatusForbidden)
+		return
+	}
+
+	path, err := s.updateFileList(r.Context(), base)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	http.ServeFile(w, r, path)
+}
+
+// updateFileList refreshes the cached file list of base, returning its path. The last copy is
+// kept if the upstream is down
+func (s *Server) updateFileList(ctx context.Context, base string) (string, error) {
+	encoded := base64.RawURLEncoding.EncodeToString([]byte(base))
+	upstream := base + "/" + fileListName
+	path := filepath.Join(s.Dir, "filelists", encoded+".yml")
+	unlock := s.lock(encoded)
+	err := s.fetchFileList(ctx, upstream, path)
+	unlock()
+	if err != nil {
+		slog.Warn("upstream file list failed", "url", upstream, "err", err)
+		_, statErr := os.Stat(path)
+		if statErr != nil {
+			return "", err
+		}
+	}
+	s.learnPrefix(path)
+	return path, nil
+}
+
+// learnPrefix trusts the download prefix of the file list at path
+func (s *Server) learnPrefix(path string) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return
+	}
+	fileList := struct {
+		DownloadPrefix string `yaml:"downloadprefix"`
+	}{}
+	err = yaml.Unmarshal(data, &fileList)
+	if err != nil || fileList.DownloadPrefix == "" {
+		return
+	}
+	s.mux.Lock()
+	if s.prefixes == nil {
+		s.prefixes = map[string]bool{}
+	}
+	s.prefixes[fileList.DownloadPrefix] = true
+	s.mux.Unlock()
+}
+
+func (s *Server) isPatchURL(base string) bool {
+	for _, patchURL := range s.PatchURLs {
+		if strings.TrimSuffix(patchURL, "/") == base {
+			return true
+		}
+	}
+	return false
+}
+
+// isFileAllowed returns true if upstream is under a patch url, mirror or learned download prefix.
+// Unknown upstreams refresh the file lists first, a server may have moved its downloads
+func (s *Server) isFileAllowed(ctx context.Context, upstream *url.URL) bool {
+	if s.hasPrefix(upstream) {
+		return true
+	}
+
+	s.mux.Lock()
+	isStale := time.Since(s.learnedAt) > learnInterval
+	if isStale {
+		s.learnedAt = time.Now()
+	}
+	s.mux.Unlock()
+	if !isStale {
+		return false
+	}
+	for _, patchURL := range s.PatchURLs {
+		s.updateFileList(ctx, strings.TrimSuffix(patchURL, "/"))
+	}
+	return s.hasPrefix(upstream)
+}
+
+func (s *Server) hasPrefix(upstream *url.URL) bool {
+	prefixes := append([]string{}, s.PatchURLs...)
+	prefixes = append(prefixes, s.Mirrors...)
+	s.mux.Lock()
+	for prefix := range s.prefixes {
+		prefixes = append(prefixes, prefix)
+	}
+	s.mux.Unlock()
+
+	for _, prefix := range prefixes {
+		if isUnder(upstream, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// isUnder returns true if upstream is prefix or a path below it
+func isUnder(upstream *url.URL, prefix string) bool {
+	u, err := url.Parse(strings.TrimSpace(prefix))
+	if err != nil || u.Host == "" {
+		return false
+	}
+	if u.Scheme != upstream.Scheme || !strings.EqualFold(u.Host, upstream.Host) {
+		return false
+	}
+	dir := strings.TrimSuffix(githubPath(u), "/")
+	p := githubPath(upstream)
+	return p == dir || strings.HasPrefix(p, dir+"/")
+}
+
+// githubPath returns the path of u, dropping the refs/heads a patcher adds to GitHub raw branch urls
+func githubPath(u *url.URL) string {
+	if !strings.EqualFold(u.Host, "raw.githubusercontent.com") {
+		return u.Path
+	}
+	return strings.Replace(u.Path, "/refs/heads/", "/", 1)
+}
+
+func (s *Server) fetchFileList(ctx context.Context, upstream string, path string) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", upstream, nil)
+	if err != nil {
+		return fmt.Errorf("create request: %w", err)
+	}
+	fi, err := os.Stat(path)
+	if err == nil {
+		req.Header.Set("If-Modified-Since", fi.ModTime().UTC().Format(http.TimeFormat))
+	}
+
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("download %s: %w", upstream, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotModified {
+		return nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("download %s responded HTTP status code %d", upstream, resp.StatusCode)
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("read body: %w", err)
+	}
+
+	err = os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return fmt.Errorf("mkdir: %w", err)
+	}
+	err = os.WriteFile(path+".tmp", data, 0644)
+	if err != nil {
+		return fmt.Errorf("write: %w", err)
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// lock serializes work on key so concurrent players only trigger one upstream download
+func (s *Server) lock(key string) func() {
+	s.mux.Lock()
+	if s.locks == nil {
+		s.locks = map[string]*sync.Mutex{}
+	}
+	l, ok := s.locks[key]
+	if !ok {
+		l = &sync.Mutex{}
+		s.locks[key] = l
+	}
+	s.mux.Unlock()
+
+	l.Lock()
+	return l.Unlock
+}
+
+// upstreamURL parses value with its path cleaned, so .. can not climb out of a known prefix
+func upstreamURL(value string) (*url.URL, error) {
+	u, err := url.Parse(value)
+	if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") || u.User != nil {
+		return nil, fmt.Errorf("invalid upstream url %q", value)
+	}
+	u.Path = path.Clean("/" + u.Path)
+	u.RawPath = ""
+	return u, nil
+}
+
+// ListenAndServe serves HTTP on addr and answers discovery broadcasts until ctx is done
+func (s *Server) ListenAndServe(ctx context.Context, addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("listen: %w", err)
+	}
+	port := listener.Addr().(*net.TCPAddr).Port
+
+	conn, err := net.ListenPacket("udp", fmt.Sprintf(":%d", DiscoveryPort))
+	if err != nil {
+		listener.Close()
+		return fmt.Errorf("listen discovery: %w", err)
+	}
+	go answerDiscovery(conn, port)
+
+	srv := &http.Server{Handler: s}
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+		srv.Close()
+	}()
+
+	err = srv.Serve(listener)
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+// answerDiscovery replies to discovery requests on conn with the HTTP port until conn is closed
+func answerDiscovery(conn net.PacketConn, port int) {
+	buf := make([]byte, 64)
+	reply := []byte(discoveryReply + strconv.Itoa(port))
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		if string(buf[:n]) != discoveryRequest {
+			continue
+		}
+		conn.WriteTo(reply, addr)
+	}
+}
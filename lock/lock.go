@@ -0,0 +1,154 @@
+// lock keeps two rof2plus processes from changing the same directory at once with an OS lock on a lock file
+package lock
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// ErrLocked is returned when another running rof2plus holds the lock
+var ErrLocked = errors.New("another rof2plus is already running")
+
+// errHeld is returned by lockFile when another open lock file holds the OS lock
+var errHeld = errors.New("lock is held")
+
+// Lock is a held lock on a directory
+type Lock struct {
+	path string
+	f    *os.File
+}
+
+// Path returns the lock file of dir. It sits beside dir, not in it, so it is never
+// mistaken for a client file or moved along with dir
+func Path(dir string) (string, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", fmt.Errorf("abs %s: %w", dir, err)
+	}
+	return filepath.Join(filepath.Dir(dir), "."+filepath.Base(dir)+".rof2plus.lock"), nil
+}
+
+// Acquire locks dir for this process. The OS releases the lock when the process exits, so a lock
+// file left behind by a crash does not block the next run
+func Acquire(dir string) (*Lock, error) {
+	path, err := Path(dir)
+	if err != nil {
+		return nil, err
+	}
+	err = os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return nil, fmt.Errorf("mkdir: %w", err)
+	}
+
+	for range 3 {
+		f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+		if err != nil {
+			return nil, fmt.Errorf("open lock: %w", err)
+		}
+		err = lockFile(f)
+		if err != nil {
+			f.Close()
+			if !errors.Is(err, errHeld) {
+				return nil, fmt.Errorf("lock: %w", err)
+			}
+			pid, _ := readPID(path)
+			if pid > 0 {
+				return nil, fmt.Errorf("%s is in use by process %d: %w", dir, pid, ErrLocked)
+			}
+			return nil, fmt.Errorf("%s: %w", dir, ErrLocked)
+		}
+
+		// the previous holder removes the file on release, a lock taken on a removed file protects nothing
+		isSame, err := isLockedPath(f, path)
+		if err != nil || !isSame {
+			unlockFile(f)
+			f.Close()
+			continue
+		}
+
+		// the pid only tells a player which process holds the lock, the OS lock is what excludes
+		err = f.Truncate(0)
+		if err == nil {
+			_, err = f.WriteAt([]byte(strconv.Itoa(os.Getpid())), 0)
+		}
+		if err != nil {
+			unlockFile(f)
+			f.Close()
+			return nil, fmt.Errorf("write lock: %w", err)
+		}
+		return &Lock{path: path, f: f}, nil
+	}
+	return nil, fmt.Errorf("%s: %w", dir, ErrLocked)
+}
+
+func isLockedPath(f *os.File, path string) (bool, error) {
+	held, err := f.Stat()
+	if err != nil {
+		return false, err
+	}
+	current, err := os.Stat(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return os.SameFile(held, current), nil
+}
+
+// Owner returns the process id holding the lock on dir, or 0 if it is not locked
+func Owner(dir string) (int, error) {
+	path, err := Path(dir)
+	if err != nil {
+		return 0, err
+	}
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, fmt.Errorf("open lock: %w", err)
+	}
+	defer f.Close()
+
+	err = lockFile(f)
+	if err == nil {
+		// nobody holds it, the file was left behind
+		unlockFile(f)
+		return 0, nil
+	}
+	if !errors.Is(err, errHeld) {
+		return 0, fmt.Errorf("lock: %w", err)
+	}
+	return readPID(path)
+}
+
+// readPID returns the pid written in the lock file at path, or 0 if it is not written yet
+func readPID(path string) (int, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, fmt.Errorf("read lock: %w", err)
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return 0, nil
+	}
+	return pid, nil
+}
+
+// Release unlocks dir and removes the lock file
+func (e *Lock) Release() error {
+	if e == nil || e.f == nil {
+		return nil
+	}
+	err := release(e.f, e.path)
+	e.f = nil
+	if err != nil {
+		return fmt.Errorf("release lock: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,34 @@
+package lock
+
+import (
+	"errors"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// lockFile takes an exclusive flock on f without waiting
+func lockFile(f *os.File) error {
+	err := unix.Flock(int(f.Fd()), unix.LOCK_EX|unix.LOCK_NB)
+	if errors.Is(err, unix.EWOULDBLOCK) {
+		return errHeld
+	}
+	return err
+}
+
+func unlockFile(f *os.File) error {
+	return unix.Flock(int(f.Fd()), unix.LOCK_UN)
+}
+
+// release removes path while still holding the lock, so a process that opened the old file
+// notices it was removed instead of locking it
+func release(f *os.File, path string) error {
+	err := os.Remove(path)
+	if err != nil && !os.IsNotExist(err) {
+		unlockFile(f)
+		f.Close()
+		return err
+	}
+	unlockFile(f)
+	return f.Close()
+}
@@ -0,0 +1,149 @@
+package lock
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestHelperProcess is run as a second rof2plus by the tests below, it is not a real test
+func TestHelperProcess(t *testing.T) {
+	dir := os.Getenv("ROF2PLUS_LOCK_DIR")
+	if dir == "" {
+		return
+	}
+	l, err := Acquire(dir)
+	if err != nil {
+		if errors.Is(err, ErrLocked) {
+			fmt.Println("locked")
+			os.Exit(3)
+		}
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	fmt.Println("acquired")
+	if os.Getenv("ROF2PLUS_LOCK_HOLD") == "" {
+		l.Release()
+		os.Exit(0)
+	}
+	// hold the lock until the parent kills this process, leaving it behind as a crash would
+	for {
+		time.Sleep(time.Minute)
+	}
+}
+
+func helper(t *testing.T, dir string, isHold bool) *exec.Cmd {
+	t.Helper()
+	cmd := exec.Command(os.Args[0], "-test.run=TestHelperProcess")
+	cmd.Env = append(os.Environ(), "ROF2PLUS_LOCK_DIR="+dir)
+	if isHold {
+		cmd.Env = append(cmd.Env, "ROF2PLUS_LOCK_HOLD=1")
+	}
+	return cmd
+}
+
+func TestAcquireTwoProcesses(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "server")
+
+	holder := helper(t, dir, true)
+	stdout, err := holder.StdoutPipe()
+	if err != nil {
+		t.Fatalf("pipe: %v", err)
+	}
+	err = holder.Start()
+	if err != nil {
+		t.Fatalf("start holder: %v", err)
+	}
+	line, err := bufio.NewReader(stdout).ReadString('\n')
+	if err != nil || strings.TrimSpace(line) != "acquired" {
+		holder.Process.Kill()
+		t.Fatalf("holder: %q %v", line, err)
+	}
+
+	_, err = Acquire(dir)
+	if !errors.Is(err, ErrLocked) {
+		holder.Process.Kill()
+		t.Fatalf("expected locked, got %v", err)
+	}
+	pid, err := Owner(dir)
+	if err != nil || pid != holder.Process.Pid {
+		holder.Process.Kill()
+		t.Fatalf("owner: got %d %v want %d", pid, err, holder.Process.Pid)
+	}
+
+	err = helper(t, dir, false).Run()
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) || exitErr.ExitCode() != 3 {
+		holder.Process.Kill()
+		t.Fatalf("expected second process to see the lock, got %v", err)
+	}
+
+	// the holder dies without releasing, its lock is stale
+	holder.Process.Kill()
+	holder.Wait()
+
+	l, err := Acquire(dir)
+	if err != nil {
+		t.Fatalf("acquire stale lock: %v", err)
+	}
+	out, err := helper(t, dir, false).Output()
+	if err == nil || strings.TrimSpace(string(out)) != "locked" {
+		t.Fatalf("expected locked while held, got %q %v", out, err)
+	}
+	err = l.Release()
+	if err != nil {
+		t.Fatalf("release: %v", err)
+	}
+
+	out, err = helper(t, dir, false).Output()
+	if err != nil || strings.TrimSpace(string(out)) != "acquired" {
+		t.Fatalf("expected acquire after release, got %q %v", out, err)
+	}
+}
+
+func TestAcquireLeftover(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "rof2")
+	path, err := Path(dir)
+	if err != nil {
+		t.Fatalf("path: %v", err)
+	}
+	// a lock file nobody holds, as a crash leaves behind
+	err = os.WriteFile(path, []byte("garbage"), 0644)
+	if err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	l, err := Acquire(dir)
+	if err != nil {
+		t.Fatalf("acquire: %v", err)
+	}
+
+	// a holder that has not written its pid yet still holds the lock
+	err = os.WriteFile(path, nil, 0644)
+	if err != nil {
+		t.Fatalf("truncate: %v", err)
+	}
+	_, err = Acquire(dir)
+	if !errors.Is(err, ErrLocked) {
+		t.Fatalf("expected locked with an empty lock file, got %v", err)
+	}
+
+	err = l.Release()
+	if err != nil {
+		t.Fatalf("release: %v", err)
+	}
+	pid, err := Owner(dir)
+	if err != nil || pid != 0 {
+		t.Fatalf("owner after release: got %d %v", pid, err)
+	}
+	l, err = Acquire(dir)
+	if err != nil {
+		t.Fatalf("acquire after release: %v", err)
+	}
+	l.Release()
+}
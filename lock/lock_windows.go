@@ -0,0 +1,38 @@
+package lock
+
+import (
+	"errors"
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// lockOffset is where the locked byte sits, past the pid so other processes can still read it
+const lockOffset = 1 << 32
+
+// lockFile takes an exclusive LockFileEx lock on f without waiting
+func lockFile(f *os.File) error {
+	ol := &windows.Overlapped{Offset: lockOffset & 0xffffffff, OffsetHigh: lockOffset >> 32}
+	err := windows.LockFileEx(windows.Handle(f.Fd()), windows.LOCKFILE_EXCLUSIVE_LOCK|windows.LOCKFILE_FAIL_IMMEDIATELY, 0, 1, 0, ol)
+	if errors.Is(err, windows.ERROR_LOCK_VIOLATION) {
+		return errHeld
+	}
+	return err
+}
+
+func unlockFile(f *os.File) error {
+	ol := &windows.Overlapped{Offset: lockOffset & 0xffffffff, OffsetHigh: lockOffset >> 32}
+	return windows.UnlockFileEx(windows.Handle(f.Fd()), 0, 1, 0, ol)
+}
+
+// release unlocks and closes f before removing path, windows can not remove a file that is open.
+// The removal fails harmlessly if another process already opened the file to lock it
+func release(f *os.File, path string) error {
+	unlockFile(f)
+	err := f.Close()
+	if err != nil {
+		return err
+	}
+	os.Remove(path)
+	return nil
+}
@@ -0,0 +1,125 @@
+// logs sets up the default slog logger to write a rotating log file with user home paths redacted
+package logs
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+// FileName is the current log file, older logs are numbered rof2plus.1.log and up
+const FileName = "rof2plus.log"
+
+const (
+	// maxSize is the size a log file is rotated at
+	maxSize = 5 * 1024 * 1024
+	// keep is how many rotated log files are kept
+	keep = 3
+)
+
+// Options configures logging
+type Options struct {
+	// Dir receives the log files
+	Dir string
+	// IsVerbose logs debug messages and copies every log line to the console
+	IsVerbose bool
+	// IsJSON writes JSON lines instead of text
+	IsJSON bool
+	// Console is where verbose logs are copied, defaults to stderr
+	Console io.Writer
+}
+
+// Setup makes a logger for opts the slog default and returns a function closing its file
+func Setup(opts Options) (func() error, error) {
+	err := os.MkdirAll(opts.Dir, 0755)
+	if err != nil {
+		return nil, fmt.Errorf("mkdir: %w", err)
+	}
+	file, err := openRotating(filepath.Join(opts.Dir, FileName), maxSize, keep)
+	if err != nil {
+		return nil, err
+	}
+
+	level := slog.LevelInfo
+	var w io.Writer = file
+	if opts.IsVerbose {
+		level = slog.LevelDebug
+		console := opts.Console
+		if console == nil {
+			console = os.Stderr
+		}
+		w = io.MultiWriter(file, console)
+	}
+
+	slog.SetDefault(slog.New(NewHandler(w, level, opts.IsJSON)))
+	return file.Close, nil
+}
+
+// NewHandler returns a text or JSON handler writing to w that redacts home paths
+func NewHandler(w io.Writer, level slog.Leveler, isJSON bool) slog.Handler {
+	handlerOpts := &slog.HandlerOptions{Level: level, ReplaceAttr: redactAttr}
+	if isJSON {
+		return slog.NewJSONHandler(w, handlerOpts)
+	}
+	return slog.NewTextHandler(w, handlerOpts)
+}
+
+func redactAttr(groups []string, a slog.Attr) slog.Attr {
+	switch a.Value.Kind() {
+	case slog.KindString:
+		return slog.String(a.Key, Redact(a.Value.String()))
+	case slog.KindAny:
+		switch v := a.Value.Any().(type) {
+		case error:
+			return slog.String(a.Key, Redact(v.Error()))
+		case fmt.Stringer:
+			return slog.String(a.Key, Redact(v.String()))
+		case []string:
+			redacted := make([]string, len(v))
+			for i, s := range v {
+				redacted[i] = Redact(s)
+			}
+			return slog.Any(a.Key, redacted)
+		}
+	}
+	return a
+}
+
+var (
+	homeOnce    sync.Once
+	homePattern *regexp.Regexp
+)
+
+// Redact replaces the user's home directory in s with ~, so logs can be shared without
+// revealing account names
+func Redact(s string) string {
+	homeOnce.Do(func() {
+		home, err := os.UserHomeDir()
+		if err != nil || len(home) < 2 {
+			return
+		}
+		homePattern = homeRegexp(home, runtime.GOOS == "windows")
+	})
+	if homePattern == nil {
+		return s
+	}
+	return homePattern.ReplaceAllLiteralString(s, "~")
+}
+
+// homeRegexp matches home written with either slash, ignoring case on windows
+func homeRegexp(home string, isWindows bool) *regexp.Regexp {
+	if !isWindows {
+		return regexp.MustCompile(regexp.QuoteMeta(home))
+	}
+	parts := strings.FieldsFunc(home, func(r rune) bool { return r == '/' || r == '\\' })
+	for i, part := range parts {
+		parts[i] = regexp.QuoteMeta(part)
+	}
+	return regexp.MustCompile(`(?i)` + strings.Join(parts, `[/\\]`))
+}
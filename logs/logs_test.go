@@ -0,0 +1,137 @@
+package logs
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestHomeRegexp(t *testing.T) {
+	tests := []struct {
+		home      string
+		isWindows bool
+		in        string
+		want      string
+	}{
+		{`/home/bob`, false, "open /home/bob/.local/share/rof2plus/rof2: denied", "open ~/.local/share/rof2plus/rof2: denied"},
+		{`/home/bob`, false, "/home/BOB/x", "/home/BOB/x"},
+		{`C:\Users\Bob`, true, `C:\Users\Bob\AppData\Roaming\rof2plus`, `~\AppData\Roaming\rof2plus`},
+		{`C:\Users\Bob`, true, `path c:/users/bob/eq and C:\USERS\BOB`, `path ~/eq and ~`},
+	}
+	for _, tt := range tests {
+		got := homeRegexp(tt.home, tt.isWindows).ReplaceAllLiteralString(tt.in, "~")
+		if got != tt.want {
+			t.Fatalf("%s: got %q want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestSetup(t *testing.T) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		t.Skip("no home directory")
+	}
+	dir := t.TempDir()
+	console := &bytes.Buffer{}
+	closeLog, err := Setup(Options{Dir: dir, IsVerbose: true, IsJSON: true, Console: console})
+	if err != nil {
+		t.Fatalf("setup: %v", err)
+	}
+	defer slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, nil)))
+
+	path := filepath.Join(home, "rof2plus", "thj")
+	slog.Debug("patching", "path", path, "err", fmt.Errorf("open %s: %w", path, errors.New("denied")))
+	err = closeLog()
+	if err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, FileName))
+	if err != nil {
+		t.Fatalf("read log: %v", err)
+	}
+	if !bytes.Equal(data, console.Bytes()) {
+		t.Fatalf("verbose console output differs from the log file")
+	}
+	if strings.Contains(string(data), home) {
+		t.Fatalf("home directory not redacted: %s", data)
+	}
+	line := map[string]any{}
+	err = json.Unmarshal(data, &line)
+	if err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	want := filepath.Join("~", "rof2plus", "thj")
+	if line["level"] != "DEBUG" || line["path"] != want || line["err"] != "open "+want+": denied" {
+		t.Fatalf("unexpected log line: %v", line)
+	}
+}
+
+func TestRotate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), FileName)
+	r, err := openRotating(path, 10, 2)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	for _, line := range []string{"first\n", "second\n", "third\n", "fourth\n"} {
+		_, err = r.Write([]byte(line))
+		if err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+	err = r.Close()
+	if err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	want := map[string]string{
+		FileName:         "fourth\n",
+		"rof2plus.1.log": "third\n",
+		"rof2plus.2.log": "second\n",
+	}
+	for name, content := range want {
+		data, err := os.ReadFile(filepath.Join(filepath.Dir(path), name))
+		if err != nil || string(data) != content {
+			t.Fatalf("%s: got %q %v want %q", name, data, err, content)
+		}
+	}
+	_, err = os.Stat(filepath.Join(filepath.Dir(path), "rof2plus.3.log"))
+	if !os.IsNotExist(err) {
+		t.Fatalf("expected only %d rotated logs, got %v", 2, err)
+	}
+}
+
+func TestRotateRenameFails(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, FileName)
+	// a non-empty directory where the rotated log goes can't be replaced
+	err := os.MkdirAll(filepath.Join(dir, "rof2plus.1.log", "busy"), 0755)
+	if err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	r, err := openRotating(path, 10, 1)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	for _, line := range []string{"first\n", "second\n", "third\n"} {
+		_, err = r.Write([]byte(line))
+		if err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+	err = r.Close()
+	if err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil || string(data) != "first\nsecond\nthird\n" {
+		t.Fatalf("got %q %v", data, err)
+	}
+}
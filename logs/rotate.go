@@ -0,0 +1,103 @@
+package logs
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+// rotatingFile is a log file that is renamed aside once it reaches maxSize
+type rotatingFile struct {
+	mux     sync.Mutex
+	path    string
+	maxSize int64
+	keep    int
+	f       *os.File
+	size    int64
+}
+
+func openRotating(path string, maxSize int64, keep int) (*rotatingFile, error) {
+	r := &rotatingFile{path: path, maxSize: maxSize, keep: keep}
+	err := r.open()
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *rotatingFile) open() error {
+	f, err := os.OpenFile(r.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("open log: %w", err)
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("stat log: %w", err)
+	}
+	r.f = f
+	r.size = fi.Size()
+	return nil
+}
+
+func (r *rotatingFile) Write(p []byte) (int, error) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	if r.f == nil {
+		return 0, os.ErrClosed
+	}
+	if r.size > 0 && r.size+int64(len(p)) > r.maxSize {
+		err := r.rotate()
+		if err != nil {
+			return 0, err
+		}
+	}
+	n, err := r.f.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+// rotate shifts rof2plus.log to rof2plus.1.log, rof2plus.1.log to rof2plus.2.log and so on, dropping the oldest.
+// A log that can't be renamed, e.g. one another program has open on windows, is reopened and kept writing to
+func (r *rotatingFile) rotate() error {
+	r.f.Close()
+	r.f = nil
+
+	os.Remove(r.rotatedPath(r.keep))
+	for i := r.keep - 1; i >= 1; i-- {
+		os.Rename(r.rotatedPath(i), r.rotatedPath(i+1))
+	}
+	var rotateErr error
+	if r.keep > 0 {
+		rotateErr = os.Rename(r.path, r.rotatedPath(1))
+	} else {
+		rotateErr = os.Remove(r.path)
+	}
+	err := r.open()
+	if err != nil {
+		return err
+	}
+	if rotateErr != nil {
+		// try again once another maxSize is written rather than on every line
+		r.size = 0
+	}
+	return nil
+}
+
+func (r *rotatingFile) rotatedPath(i int) string {
+	base := strings.TrimSuffix(r.path, ".log")
+	return fmt.Sprintf("%s.%d.log", base, i)
+}
+
+func (r *rotatingFile) Close() error {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	if r.f == nil {
+		return nil
+	}
+	err := r.f.Close()
+	r.f = nil
+	return err
+}
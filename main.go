@@ -2,21 +2,63 @@ package main
 
 import (
 	"context"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
+	"log/slog"
 	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
 	"runtime"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/xackery/rof2plus/check"
 	"github.com/xackery/rof2plus/checksum"
 	"github.com/xackery/rof2plus/config"
+	"github.com/xackery/rof2plus/diskspace"
+	"github.com/xackery/rof2plus/install"
+	"github.com/xackery/rof2plus/lancache"
+	"github.com/xackery/rof2plus/lock"
+	"github.com/xackery/rof2plus/logs"
+	"github.com/xackery/rof2plus/patch"
+	"github.com/xackery/rof2plus/paths"
+	"github.com/xackery/rof2plus/pfs"
+	"github.com/xackery/rof2plus/prompt"
+	"github.com/xackery/rof2plus/publish"
+	"github.com/xackery/rof2plus/relocate"
+	"github.com/xackery/rof2plus/selfupdate"
+	"github.com/xackery/rof2plus/serverlist"
 	"github.com/xackery/rof2plus/start"
+	"github.com/xackery/rof2plus/support"
+	"gopkg.in/yaml.v3"
 )
 
+// Version is set at build time with -ldflags "-X main.Version=...", otherwise versioninfo.json is used
+var Version string
+
+//go:embed versioninfo.json
+var versionInfo []byte
+
+// logPath is the log file of this run, shown when it fails
+var logPath string
+
 func main() {
 	err := run()
+	if errors.Is(err, context.Canceled) {
+		fmt.Println("Stopped:", err)
+		os.Exit(130)
+	}
 	if err != nil {
 		fmt.Println("Failed to run the program:", err)
+		if logPath != "" {
+			fmt.Println("Details are logged in", logPath)
+		}
 		if runtime.GOOS == "windows" {
 			fmt.Println("Press any key to exit...")
 			fmt.Scanln()
@@ -25,45 +67,623 @@ func main() {
 	}
 }
 
-func run() error {
-	var err error
-	if len(os.Args) < 2 {
-		fmt.Println("Usage: rof2plus <start|check>")
-		os.Exit(1)
+func run() (err error) {
+	args := []string{}
+	isSelfUpdate := true
+	isOffline := false
+	// logFlags are passed on when a self update re-runs the program
+	logFlags := []string{}
+	logOpts := logs.Options{}
+	for _, arg := range os.Args[1:] {
+		switch arg {
+		case "--no-self-update":
+			isSelfUpdate = false
+			continue
+		case "--offline":
+			isOffline = true
+			continue
+		case "--verbose":
+			logOpts.IsVerbose = true
+			logFlags = append(logFlags, arg)
+			continue
+		case "--log-format=json", "--log-format=text":
+			logOpts.IsJSON = arg == "--log-format=json"
+			logFlags = append(logFlags, arg)
+			continue
+		}
+		args = append(args, arg)
 	}
 
-	action := os.Args[1]
+	// shortcuts launch without arguments
+	action := "start"
+	if len(args) >= 1 {
+		action = args[0]
+	}
 	arg1 := ""
-	if len(os.Args) >= 3 {
-		arg1 = os.Args[2]
+	if len(args) >= 2 {
+		arg1 = args[1]
+	}
+	arg2 := ""
+	if len(args) >= 3 {
+		arg2 = args[2]
+	}
+	p, err := paths.Resolve()
+	if err != nil {
+		return fmt.Errorf("paths: %w", err)
+	}
+
+	logOpts.Dir = p.LogDir()
+	closeLog, err := logs.Setup(logOpts)
+	if err != nil {
+		// the program works without a log
+		fmt.Println("Logging disabled:", err)
+		slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	} else {
+		logPath = filepath.Join(logOpts.Dir, logs.FileName)
+		defer closeLog()
 	}
+	slog.Info("rof2plus started", "version", version(), "os", runtime.GOOS, "action", action, "args", args, "offline", isOffline, "portable", p.IsPortable)
+	defer func() {
+		if err != nil {
+			slog.Error("rof2plus failed", "action", action, "err", err)
+			return
+		}
+		slog.Info("rof2plus finished", "action", action)
+	}()
+
+	// the first Ctrl-C cancels ctx so work stops and cleans up after itself, a second one exits right away
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+
 	switch strings.ToLower(action) {
 	case "start":
-		err = start.Start(arg1)
+		if isSelfUpdate && !isOffline {
+			err = selfUpdate(ctx, append(args, logFlags...))
+			if err != nil {
+				return fmt.Errorf("self update: %w", err)
+			}
+		}
+		err = start.Start(ctx, p, arg1, isOffline)
 		if err != nil {
 			return fmt.Errorf("start: %w", err)
 		}
 	case "check":
 		if arg1 == "" {
-			fmt.Println("Usage: rof2plus check <path>")
+			fmt.Println("Usage: rof2plus check <path> [rof2|rof2core|ls|rof2ls]")
 			os.Exit(1)
 		}
-		_, err := config.New(context.Background(), "rof2plus")
+		opts := check.Options{Client: checksum.ClientRoF2, Path: arg1}
+		cfg, err := config.New(ctx, p.ConfigFile())
 		if err != nil {
 			return fmt.Errorf("config.New: %w", err)
 		}
+		// replaced archive entries are found by comparing to the player's vanilla client
+		if cfg.RoF2Path != "" && filepath.Clean(cfg.RoF2Path) != filepath.Clean(arg1) {
+			opts.ReferencePath = cfg.RoF2Path
+		}
+		if arg2 != "" {
+			opts.Client, err = checksum.ClientByName(arg2)
+			if err != nil {
+				return fmt.Errorf("client: %w", err)
+			}
+			opts.Manifest, err = checksum.Manifest(opts.Client)
+			if err != nil {
+				return fmt.Errorf("manifest: %w", err)
+			}
+		}
 
-		err = check.Check(checksum.ClientRoF2, arg1)
+		l, err := lock.Acquire(opts.Path)
 		if err != nil {
 			return fmt.Errorf("check: %w", err)
 		}
+		defer l.Release()
 
-		report := check.Report()
+		report, err := check.Run(ctx, opts)
+		if err != nil {
+			return fmt.Errorf("check: %w", err)
+		}
+
+		cancelled := 0
 		for _, failure := range report.Failures {
+			if failure.Error == check.ErrorCancelled {
+				cancelled++
+				continue
+			}
 			fmt.Println(failure)
 		}
+		if ctx.Err() != nil {
+			fmt.Printf("Interrupted after checking %d of %d files\n", report.FileTotal-cancelled, report.FileTotal)
+			return ctx.Err()
+		}
+		return repairPreflight(opts, report)
+	case "identify":
+		if arg1 == "" {
+			fmt.Println("Usage: rof2plus identify <path>")
+			os.Exit(1)
+		}
+
+		identity, err := checksum.Identify(arg1)
+		if err != nil {
+			return fmt.Errorf("identify: %w", err)
+		}
+
+		for _, score := range identity.Scores {
+			fmt.Println(score)
+		}
+		fmt.Println("Identified as", identity)
+		return nil
+	case "install":
+		layout, err := install.DefaultLayout()
+		if err != nil {
+			return fmt.Errorf("install layout: %w", err)
+		}
+		if arg1 != "" {
+			layout, err = install.LayoutAt(arg1)
+			if err != nil {
+				return fmt.Errorf("install layout: %w", err)
+			}
+		}
+
+		exePath, err := os.Executable()
+		if err != nil {
+			return fmt.Errorf("executable: %w", err)
+		}
+		record, err := layout.Install(exePath)
+		if err != nil {
+			return fmt.Errorf("install: %w", err)
+		}
+		fmt.Println("Installed to", record.Binary)
+		for _, shortcut := range layout.Shortcuts {
+			fmt.Println("Created shortcut", shortcut)
+		}
+		return nil
+	case "uninstall":
+		layout, err := install.DefaultLayout()
+		if err != nil {
+			return fmt.Errorf("install layout: %w", err)
+		}
+		err = layout.Uninstall()
+		if err != nil {
+			return fmt.Errorf("uninstall: %w", err)
+		}
+		fmt.Println("Uninstalled rof2plus")
+		return nil
+	case "config":
+		cfg, err := config.New(ctx, p.ConfigFile())
+		if err != nil {
+			return fmt.Errorf("config.New: %w", err)
+		}
+
+		switch arg1 {
+		case "get":
+			keys := cfg.Keys()
+			if arg2 != "" {
+				keys = []string{arg2}
+			}
+			for _, key := range keys {
+				value, err := cfg.Value(key)
+				if err != nil {
+					return fmt.Errorf("get: %w", err)
+				}
+				fmt.Printf("%s: %s\n", key, value)
+			}
+			return nil
+		case "set":
+			if arg2 == "" || len(args) < 4 {
+				fmt.Println("Usage: rof2plus config set <key> <value>")
+				os.Exit(1)
+			}
+			err = cfg.SetValue(arg2, args[3])
+			if err != nil {
+				return fmt.Errorf("set: %w", err)
+			}
+			err = cfg.Save()
+			if err != nil {
+				return fmt.Errorf("save: %w", err)
+			}
+			return nil
+		case "validate":
+			err = cfg.Verify()
+			if err != nil {
+				return fmt.Errorf("validate: %w", err)
+			}
+			fmt.Println("Config is valid")
+			return nil
+		}
+		fmt.Println("Usage: rof2plus config <get|set|validate> [key] [value]")
+		os.Exit(1)
+	case "serve-cache":
+		addr := lancache.DefaultAddr
+		if arg1 != "" {
+			addr = arg1
+		}
+		dir := filepath.Join(p.Cache, "lancache")
+		err = serverlist.Fetch(p.ServerListFile())
+		if err != nil {
+			return fmt.Errorf("server list: %w", err)
+		}
+		cache := lancache.New(dir)
+		// only servers on the server list are fetched from
+		for _, server := range serverlist.Servers() {
+			cache.PatchURLs = append(cache.PatchURLs, server.PatchURL)
+			cache.Mirrors = append(cache.Mirrors, server.Mirrors...)
+		}
+		fmt.Printf("Serving patch cache of %d servers from %s on %s, press Ctrl-C to stop\n", len(serverlist.Servers()), dir, addr)
+		err = cache.ListenAndServe(ctx, addr)
+		if err != nil {
+			return fmt.Errorf("serve cache: %w", err)
+		}
+		return nil
+	case "publish":
+		flags := flag.NewFlagSet("publish", flag.ContinueOnError)
+		prefix := flags.String("prefix", "", "url players download files from")
+		out := flags.String("out", "", "directory for the file list and zips, defaults to <dir>")
+		publishVersion := flags.String("version", "", "file list version, defaults to the current time")
+		unpacks := []string{}
+		flags.Func("unpack", "directory to bundle into a zip, can be repeated", func(value string) error {
+			unpacks = append(unpacks, value)
+			return nil
+		})
+		if arg1 == "" || flags.Parse(args[2:]) != nil || *prefix == "" {
+			fmt.Println("Usage: rof2plus publish <dir> -prefix <url> [-out dir] [-version v] [-unpack dir]...")
+			os.Exit(1)
+		}
+
+		result, err := publish.Publish(ctx, publish.Options{
+			Dir:            arg1,
+			OutDir:         *out,
+			DownloadPrefix: *prefix,
+			Version:        *publishVersion,
+			Unpacks:        unpacks,
+		})
+		if err != nil {
+			return fmt.Errorf("publish: %w", err)
+		}
+		fileList := result.FileList
+		fmt.Printf("Wrote %s version %s: %d downloads, %d deletes, %d unpacks (%d vanilla files skipped)\n", result.Path, fileList.Version, len(fileList.Downloads), len(fileList.Deletes), len(fileList.Unpacks), result.Vanilla)
+		return nil
+	case "layers":
+		flags := flag.NewFlagSet("layers", flag.ContinueOnError)
+		isLSOptional := flags.Bool("ls", false, "include the LS optional overlay")
+		fileListPath := flags.String("filelist", "", "server file list, defaults to the cached list of <server>")
+		isAll := flags.Bool("all", false, "list every file, not only overrides and problems")
+		if flags.Parse(args[1:]) != nil || flags.NArg() != 1 {
+			fmt.Println("Usage: rof2plus layers [-ls] [-all] [-filelist rof2plus_filelist.yml] <server|dir>")
+			os.Exit(1)
+		}
+
+		opts := check.LayerOptions{Path: flags.Arg(0), IsLSOptional: *isLSOptional}
+		fi, err := os.Stat(opts.Path)
+		if err != nil || !fi.IsDir() {
+			// a server short name
+			opts.Path, err = p.ServerDir(flags.Arg(0))
+			if err != nil {
+				return fmt.Errorf("layers: %w", err)
+			}
+			if *fileListPath == "" {
+				cacheDir, err := p.ServerCacheDir(flags.Arg(0))
+				if err != nil {
+					return fmt.Errorf("layers: %w", err)
+				}
+				cached, err := checksum.FetchCachedFilelist(ctx, "", cacheDir, true)
+				if err != nil {
+					return fmt.Errorf("cached file list of %s: %w", flags.Arg(0), err)
+				}
+				opts.FileList = cached.FileList
+			}
+		}
+		if *fileListPath != "" {
+			data, err := os.ReadFile(*fileListPath)
+			if err != nil {
+				return fmt.Errorf("read file list: %w", err)
+			}
+			opts.FileList = &checksum.FileList{}
+			err = yaml.Unmarshal(data, opts.FileList)
+			if err != nil {
+				return fmt.Errorf("decode file list: %w", err)
+			}
+		}
+
+		report, err := check.Layers(ctx, opts)
+		if err != nil {
+			return fmt.Errorf("layers: %w", err)
+		}
+		for _, file := range report.Files {
+			if !*isAll && !file.IsOverride && file.Status == check.LayerStatusOK {
+				continue
+			}
+			fmt.Println(file)
+		}
+		fmt.Println(report)
+		return nil
+	case "rollback":
+		if arg1 == "" {
+			fmt.Println("Usage: rof2plus rollback <server> [version]")
+			os.Exit(1)
+		}
+		backupDir, err := p.BackupDir(arg1)
+		if err != nil {
+			return fmt.Errorf("rollback: %w", err)
+		}
+		serverDir, err := p.ServerDir(arg1)
+		if err != nil {
+			return fmt.Errorf("rollback: %w", err)
+		}
+		cacheDir, err := p.ServerCacheDir(arg1)
+		if err != nil {
+			return fmt.Errorf("rollback: %w", err)
+		}
+		journals, err := patch.Rollback(ctx, backupDir, serverDir, arg2)
+		for _, journal := range journals {
+			fmt.Println("Rolled back", journal)
+		}
+		if errors.Is(err, patch.ErrNoBackup) {
+			backups, _ := patch.Backups(backupDir)
+			for _, backup := range backups {
+				fmt.Println("Backup", backup)
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("rollback %s: %w", arg1, err)
+		}
+
+		cached, err := checksum.FetchCachedFilelist(ctx, "", cacheDir, true)
+		if err != nil {
+			if errors.Is(err, checksum.ErrNoCachedFilelist) {
+				return nil
+			}
+			return fmt.Errorf("cached file list of %s: %w", arg1, err)
+		}
+		removed := []string{}
+		for _, journal := range journals {
+			removed = append(removed, journal.Added...)
+		}
+		err = cached.MarkRolledBack(journals[0].Version, removed)
+		if err != nil {
+			return fmt.Errorf("mark rolled back: %w", err)
+		}
+		fmt.Printf("%s version %s will not be patched again until the server publishes a new version\n", arg1, journals[0].Version)
+		return nil
+	case "assemble":
+		if arg1 == "" {
+			fmt.Println("Usage: rof2plus assemble <dir>")
+			os.Exit(1)
+		}
+		cfg, err := config.New(ctx, p.ConfigFile())
+		if err != nil {
+			return fmt.Errorf("config.New: %w", err)
+		}
+		if cfg.RoF2Path == "" || cfg.LSPath == "" {
+			return fmt.Errorf("assemble needs both rof2path and lspath set in config")
+		}
+		lsOptional, err := checksum.Manifest(checksum.ClientLSOptional)
+		if err != nil {
+			return fmt.Errorf("manifest: %w", err)
+		}
+		rof2, err := checksum.Manifest(checksum.ClientRoF2)
+		if err != nil {
+			return fmt.Errorf("manifest: %w", err)
+		}
+
+		sources := []relocate.Source{
+			{Dir: cfg.LSPath, Manifest: lsOptional},
+			{Dir: cfg.RoF2Path, Manifest: rof2},
+		}
+		result, err := relocate.Assemble(ctx, arg1, sources, nil)
+		if err != nil {
+			return fmt.Errorf("assemble: %w", err)
+		}
+		for _, mismatch := range result.Mismatches {
+			fmt.Println("Mismatch:", mismatch)
+		}
+		fmt.Printf("Assembled %d files (%d bytes) into %s, validate with: rof2plus check %s rof2ls\n", result.Files+result.Skipped, result.Bytes, arg1, arg1)
+		return nil
+	case "support-bundle":
+		flags := flag.NewFlagSet("support-bundle", flag.ContinueOnError)
+		out := flags.String("out", "", "zip to write, defaults to rof2plus-support-<time>.zip in the current directory")
+		isYes := flags.Bool("yes", false, "write the bundle without asking")
+		if flags.Parse(args[1:]) != nil || flags.NArg() > 1 {
+			fmt.Println("Usage: rof2plus support-bundle [-out file.zip] [-yes] [server]")
+			os.Exit(1)
+		}
+
+		bundle, err := support.Gather(ctx, support.Options{Paths: p, Version: version(), Server: flags.Arg(0)})
+		if err != nil {
+			return fmt.Errorf("support bundle: %w", err)
+		}
+		fmt.Println("The support bundle will include, with your home directory shown as ~:")
+		for _, line := range bundle.Preview() {
+			fmt.Println(" ", line)
+		}
+
+		path := *out
+		if path == "" {
+			path = fmt.Sprintf("rof2plus-support-%s.zip", time.Now().Format("20060102-150405"))
+		}
+		path, err = filepath.Abs(path)
+		if err != nil {
+			return fmt.Errorf("support bundle: %w", err)
+		}
+		if !*isYes {
+			isConfirmed, err := prompt.Confirm(ctx, fmt.Sprintf("Write %s?", path))
+			if err != nil {
+				return fmt.Errorf("support bundle: %w", err)
+			}
+			if !isConfirmed {
+				fmt.Println("No support bundle was written")
+				return nil
+			}
+		}
+		err = bundle.Write(path)
+		if err != nil {
+			return fmt.Errorf("support bundle: %w", err)
+		}
+		fmt.Println("Wrote", path)
+		fmt.Println("Attach it when reporting a problem to your server")
+		return nil
+	case "pfs":
+		if (arg1 != "ls" && arg1 != "extract") || arg2 == "" || (arg1 == "extract" && len(args) < 4) {
+			fmt.Println("Usage: rof2plus pfs ls <archive> | pfs extract <archive> <dir> [name]...")
+			os.Exit(1)
+		}
+		archive, err := pfs.Open(arg2)
+		if err != nil {
+			return fmt.Errorf("open %s: %w", arg2, err)
+		}
+		defer archive.Close()
+
+		if arg1 == "ls" {
+			problems := map[string]error{}
+			for _, problem := range archive.Verify() {
+				problems[problem.Name] = problem.Err
+			}
+			for _, entry := range archive.Entries {
+				status := "ok"
+				if problems[entry.Name] != nil {
+					status = problems[entry.Name].Error()
+				}
+				fmt.Printf("%10d %08x %s %s\n", entry.Size, entry.CRC, entry.Name, status)
+			}
+			fmt.Printf("%d entries, %d problems\n", len(archive.Entries), len(problems))
+			return nil
+		}
+
+		return extractArchive(archive, args[3], args[4:])
+	case "version":
+		fmt.Println("rof2plus", version())
+		return nil
+	default:
+		fmt.Println("Usage: rof2plus <start|check|identify|config|install|uninstall|serve-cache|publish|layers|assemble|rollback|support-bundle|pfs|version> [--no-self-update] [--offline] [--verbose] [--log-format=text|json]")
+		os.Exit(1)
+	}
+
+	return nil
+}
+
+// extractArchive writes entries of archive named in names, or every entry if names is empty, to dir
+func extractArchive(archive *pfs.Archive, dir string, names []string) error {
+	entries := archive.Entries
+	if len(names) > 0 {
+		entries = []*pfs.Entry{}
+		for _, name := range names {
+			entry := archive.Entry(name)
+			if entry == nil {
+				return fmt.Errorf("%s is not in the archive", name)
+			}
+			entries = append(entries, entry)
+		}
+	}
+
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return fmt.Errorf("mkdir: %w", err)
+	}
+	for _, entry := range entries {
+		name := filepath.Base(filepath.FromSlash(entry.Name))
+		if name == "." || name == ".." || name == string(filepath.Separator) {
+			return fmt.Errorf("invalid entry name %q", entry.Name)
+		}
+		data, err := archive.ReadEntry(entry)
+		if err != nil {
+			return fmt.Errorf("read %s: %w", entry.Name, err)
+		}
+		err = os.WriteFile(filepath.Join(dir, name), data, 0644)
+		if err != nil {
+			return fmt.Errorf("write %s: %w", name, err)
+		}
+		fmt.Println("Extracted", name)
+	}
+	return nil
+}
+
+// version returns the rof2plus version
+func version() string {
+	if Version != "" {
+		return Version
+	}
+	info := struct {
+		StringFileInfo struct {
+			ProductVersion string
+		}
+	}{}
+	err := json.Unmarshal(versionInfo, &info)
+	if err != nil {
+		return "dev"
+	}
+	return info.StringFileInfo.ProductVersion
+}
+
+// repairPreflight reports whether replacing the files a check failed fits on the checked volume
+func repairPreflight(opts check.Options, report *check.ReportDetail) error {
+	needed := int64(0)
+	for _, failure := range report.Failures {
+		size := checksum.FileSize(opts.Client, failure.Path)
+		if entry, ok := opts.Manifest[failure.Path]; ok {
+			size = entry.FileSize
+		}
+		needed += max(size, 0)
+	}
+	space, err := diskspace.Check(diskspace.Need{Path: opts.Path, Reason: "repairs", Bytes: needed})
+	if err != nil {
+		if errors.Is(err, diskspace.ErrInsufficient) {
+			fmt.Printf("Repairing %d files needs more space than is free: %v\n", report.FailTotal, err)
+			return nil
+		}
+		return fmt.Errorf("preflight: %w", err)
+	}
+	for _, warning := range space.Warnings() {
+		fmt.Println(warning)
+	}
+	return nil
+}
+
+// selfUpdate replaces the binary with a newer release if one exists, then re-runs it with the same arguments.
+// Failing to reach the release feed never stops the program
+func selfUpdate(ctx context.Context, args []string) error {
+	exePath, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("executable: %w", err)
+	}
+	selfupdate.CleanupOld(exePath)
+
+	updater := selfupdate.New(version())
+	release, asset, err := updater.Check(ctx)
+	if errors.Is(err, selfupdate.ErrUnsigned) {
+		// a build without the release key, such as a local one, is expected not to update
+		slog.Debug("skipping self update", "err", err)
+		return nil
+	}
+	if err != nil {
+		fmt.Println("Skipping self update:", err)
+		return nil
+	}
+	if release == nil {
 		return nil
 	}
 
+	fmt.Printf("Updating rof2plus %s to %s...\n", version(), release.Version)
+	err = updater.Apply(ctx, asset, exePath)
+	if err != nil {
+		fmt.Println("Self update failed:", err)
+		return nil
+	}
+
+	cmd := exec.Command(exePath, append(args, "--no-self-update")...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	err = cmd.Run()
+	if err != nil {
+		exitErr := &exec.ExitError{}
+		if errors.As(err, &exitErr) {
+			os.Exit(exitErr.ExitCode())
+		}
+		return fmt.Errorf("run updated binary: %w", err)
+	}
+	os.Exit(0)
 	return nil
 }
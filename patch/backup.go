@@ -0,0 +1,318 @@
+package patch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/xackery/rof2plus/lock"
+	"github.com/xackery/rof2plus/proc"
+	"gopkg.in/yaml.v3"
+)
+
+// journalName records a patch inside its backup directory
+const journalName = "journal.yml"
+
+// keepBackups is how many patches can be rolled back, older backups are removed
+const keepBackups = 5
+
+// ErrNoBackup is returned when there is no patch to roll back
+var ErrNoBackup = errors.New("no backup")
+
+var unsafeVersion = regexp.MustCompile(`[^A-Za-z0-9._-]`)
+
+// Journal records what a patch changed so it can be rolled back. It is written before any file
+// is touched, so an interrupted patch can be rolled back too
+type Journal struct {
+	// Version is the file list version the patch applied
+	Version   string    `yaml:"version"`
+	Started   time.Time `yaml:"started"`
+	Completed time.Time `yaml:"completed"`
+	// Replaced files were backed up before being overwritten
+	Replaced []string `yaml:"replaced"`
+	// Added files did not exist before the patch and are removed on rollback
+	Added []string `yaml:"added"`
+	// Deleted files were backed up before the patch removed them
+	Deleted []string `yaml:"deleted"`
+	dir     string
+}
+
+func (e *Journal) String() string {
+	state := "completed " + e.Completed.Format(time.DateTime)
+	if e.Completed.IsZero() {
+		state = "interrupted"
+	}
+	return fmt.Sprintf("%s (%s): %d replaced, %d added, %d deleted", e.Version, state, len(e.Replaced), len(e.Added), len(e.Deleted))
+}
+
+// beginJournal backs up every file of path that downloads will replace or deletes will remove into
+// a directory of backupDir named after version. Patching the same version again, e.g. to repair it,
+// keeps the backups of the first run so a rollback returns to the state before that version
+func beginJournal(backupDir string, version string, path string, downloads []string, deletes []string) (*Journal, error) {
+	if version == "" {
+		version = "unversioned"
+	}
+	dir := filepath.Join(backupDir, unsafeVersion.ReplaceAllString(version, "_"))
+	j, err := readJournal(dir)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return nil, err
+		}
+		j = &Journal{Version: version, Started: time.Now(), dir: dir}
+	}
+	j.Completed = time.Time{}
+
+	known := map[string]bool{}
+	for _, names := range [][]string{j.Replaced, j.Added, j.Deleted} {
+		for _, name := range names {
+			known[name] = true
+		}
+	}
+
+	for _, name := range downloads {
+		if known[name] {
+			continue
+		}
+		known[name] = true
+		isBackedUp, err := backupFile(path, dir, name)
+		if err != nil {
+			return nil, err
+		}
+		if !isBackedUp {
+			j.Added = append(j.Added, name)
+			continue
+		}
+		j.Replaced = append(j.Replaced, name)
+	}
+	for _, name := range deletes {
+		if known[name] {
+			continue
+		}
+		known[name] = true
+		isBackedUp, err := backupFile(path, dir, name)
+		if err != nil {
+			return nil, err
+		}
+		if isBackedUp {
+			j.Deleted = append(j.Deleted, name)
+		}
+	}
+
+	err = j.write()
+	if err != nil {
+		return nil, err
+	}
+	slog.Info("backed up patch", "version", j.Version, "replaced", len(j.Replaced), "added", len(j.Added), "deleted", len(j.Deleted))
+	return j, nil
+}
+
+// complete marks the patch as finished
+func (e *Journal) complete() error {
+	e.Completed = time.Now()
+	return e.write()
+}
+
+func (e *Journal) write() error {
+	err := os.MkdirAll(e.dir, 0755)
+	if err != nil {
+		return fmt.Errorf("mkdir: %w", err)
+	}
+	data, err := yaml.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("encode journal: %w", err)
+	}
+	path := filepath.Join(e.dir, journalName)
+	err = os.WriteFile(path+".tmp", data, 0644)
+	if err != nil {
+		return fmt.Errorf("write journal: %w", err)
+	}
+	err = os.Rename(path+".tmp", path)
+	if err != nil {
+		return fmt.Errorf("write journal: %w", err)
+	}
+	return nil
+}
+
+func readJournal(dir string) (*Journal, error) {
+	data, err := os.ReadFile(filepath.Join(dir, journalName))
+	if err != nil {
+		return nil, err
+	}
+	j := &Journal{dir: dir}
+	err = yaml.Unmarshal(data, j)
+	if err != nil {
+		return nil, fmt.Errorf("decode journal %s: %w", filepath.Base(dir), err)
+	}
+	return j, nil
+}
+
+// Backups returns the patches in backupDir that can be rolled back, oldest first
+func Backups(backupDir string) ([]*Journal, error) {
+	dirs, err := os.ReadDir(backupDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("read backups: %w", err)
+	}
+
+	journals := []*Journal{}
+	for _, d := range dirs {
+		if !d.IsDir() {
+			continue
+		}
+		j, err := readJournal(filepath.Join(backupDir, d.Name()))
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+		journals = append(journals, j)
+	}
+	sort.Slice(journals, func(i, j int) bool {
+		return journals[i].Started.Before(journals[j].Started)
+	})
+	return journals, nil
+}
+
+// pruneBackups removes all but the newest keep backups
+func pruneBackups(backupDir string, keep int) error {
+	journals, err := Backups(backupDir)
+	if err != nil {
+		return err
+	}
+	for len(journals) > keep {
+		err = os.RemoveAll(journals[0].dir)
+		if err != nil {
+			return fmt.Errorf("remove backup %s: %w", journals[0].Version, err)
+		}
+		journals = journals[1:]
+	}
+	return nil
+}
+
+// Rollback restores path to its state before the patch of version, undoing every later patch
+// first. An empty version rolls back the latest patch. The rolled back patches are returned newest first
+func Rollback(ctx context.Context, backupDir string, path string, version string) ([]*Journal, error) {
+	l, err := lock.Acquire(path)
+	if err != nil {
+		return nil, err
+	}
+	defer l.Release()
+
+	err = proc.WaitForExit(ctx, path, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	journals, err := Backups(backupDir)
+	if err != nil {
+		return nil, err
+	}
+	if len(journals) == 0 {
+		return nil, ErrNoBackup
+	}
+
+	target := len(journals) - 1
+	if version != "" {
+		target = -1
+		for i, j := range journals {
+			if j.Version == version {
+				target = i
+				break
+			}
+		}
+		if target == -1 {
+			return nil, fmt.Errorf("version %s: %w", version, ErrNoBackup)
+		}
+	}
+
+	rolledBack := []*Journal{}
+	for i := len(journals) - 1; i >= target; i-- {
+		if ctx.Err() != nil {
+			return rolledBack, ctx.Err()
+		}
+		err = journals[i].restore(path)
+		if err != nil {
+			return rolledBack, fmt.Errorf("rollback %s: %w", journals[i].Version, err)
+		}
+		slog.Info("rolled back patch", "path", path, "version", journals[i].Version)
+		rolledBack = append(rolledBack, journals[i])
+	}
+	return rolledBack, nil
+}
+
+// restore undoes the patch in path and removes its backup
+func (e *Journal) restore(path string) error {
+	for _, name := range e.Added {
+		err := os.Remove(filepath.Join(path, filepath.FromSlash(name)))
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("remove %s: %w", name, err)
+		}
+	}
+	for _, name := range append(e.Replaced, e.Deleted...) {
+		err := copyFile(filepath.Join(e.dir, "files", filepath.FromSlash(name)), filepath.Join(path, filepath.FromSlash(name)))
+		if err != nil {
+			return fmt.Errorf("restore %s: %w", name, err)
+		}
+	}
+	err := os.RemoveAll(e.dir)
+	if err != nil {
+		return fmt.Errorf("remove backup: %w", err)
+	}
+	return nil
+}
+
+// backupFile copies name from path into the backup dir, returning false if it does not exist
+func backupFile(path string, dir string, name string) (bool, error) {
+	if !filepath.IsLocal(filepath.FromSlash(name)) {
+		return false, fmt.Errorf("invalid file name %s", name)
+	}
+	src := filepath.Join(path, filepath.FromSlash(name))
+	fi, err := os.Stat(src)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, fmt.Errorf("stat %s: %w", name, err)
+	}
+	if fi.IsDir() {
+		return false, fmt.Errorf("%s is a directory", name)
+	}
+	err = copyFile(src, filepath.Join(dir, "files", filepath.FromSlash(name)))
+	if err != nil {
+		return false, fmt.Errorf("backup %s: %w", name, err)
+	}
+	return true, nil
+}
+
+func copyFile(src string, dst string) error {
+	r, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	err = os.MkdirAll(filepath.Dir(dst), 0755)
+	if err != nil {
+		return err
+	}
+	w, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, r)
+	closeErr := w.Close()
+	if err != nil {
+		return err
+	}
+	return closeErr
+}
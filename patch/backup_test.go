@@ -0,0 +1,138 @@
+package patch
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/xackery/rof2plus/checksum"
+	"github.com/xackery/rof2plus/internal/testfiles"
+)
+
+func testEntry(name string, content string) checksum.FileEntry {
+	return checksum.FileEntry{Name: name, Md5: testfiles.MD5(content), Size: len(content)}
+}
+
+func TestRollback(t *testing.T) {
+	content := map[string]string{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, ok := content[strings.TrimPrefix(r.URL.Path, "/")]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(data))
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	backupDir := t.TempDir()
+	original := map[string]string{
+		"uifiles/default/EQUI.xml": "vanilla ui",
+		"old.txt":                  "removed by v1",
+		"spells_us.txt":            "vanilla spells",
+	}
+	testfiles.Write(t, dir, original)
+
+	content["uifiles/default/EQUI.xml"] = "server ui v1"
+	content["new.txt"] = "added by v1"
+	v1 := &checksum.FileList{
+		Version:        "v1",
+		DownloadPrefix: srv.URL,
+		Downloads:      []checksum.FileEntry{testEntry("uifiles/default/EQUI.xml", content["uifiles/default/EQUI.xml"]), testEntry("new.txt", content["new.txt"])},
+		Deletes:        []checksum.FileEntry{{Name: "old.txt"}},
+	}
+	err := Run(context.Background(), Options{FileList: v1, Path: dir, BackupDir: backupDir})
+	if err != nil {
+		t.Fatalf("patch v1: %v", err)
+	}
+	afterV1 := map[string]string{
+		"uifiles/default/EQUI.xml": "server ui v1",
+		"new.txt":                  "added by v1",
+		"spells_us.txt":            "vanilla spells",
+	}
+	testfiles.AssertOnly(t, dir, afterV1)
+
+	// v2 pushes a broken ui file
+	content["uifiles/default/EQUI.xml"] = "broken server ui v2"
+	v2 := &checksum.FileList{
+		Version:        "v2",
+		DownloadPrefix: srv.URL,
+		Downloads:      []checksum.FileEntry{testEntry("uifiles/default/EQUI.xml", content["uifiles/default/EQUI.xml"]), testEntry("new.txt", content["new.txt"])},
+	}
+	err = Run(context.Background(), Options{FileList: v2, Path: dir, BackupDir: backupDir})
+	if err != nil {
+		t.Fatalf("patch v2: %v", err)
+	}
+	testfiles.AssertOnly(t, dir, map[string]string{
+		"uifiles/default/EQUI.xml": "broken server ui v2",
+		"new.txt":                  "added by v1",
+		"spells_us.txt":            "vanilla spells",
+	})
+
+	backups, err := Backups(backupDir)
+	if err != nil {
+		t.Fatalf("backups: %v", err)
+	}
+	if len(backups) != 2 || backups[0].Version != "v1" || backups[1].Version != "v2" || backups[1].Completed.IsZero() {
+		t.Fatalf("unexpected backups: %v", backups)
+	}
+
+	journals, err := Rollback(context.Background(), backupDir, dir, "")
+	if err != nil {
+		t.Fatalf("rollback: %v", err)
+	}
+	if len(journals) != 1 || journals[0].Version != "v2" {
+		t.Fatalf("unexpected rollback: %v", journals)
+	}
+	testfiles.AssertOnly(t, dir, afterV1)
+
+	journals, err = Rollback(context.Background(), backupDir, dir, "v1")
+	if err != nil {
+		t.Fatalf("rollback v1: %v", err)
+	}
+	if len(journals) != 1 || journals[0].Version != "v1" {
+		t.Fatalf("unexpected rollback: %v", journals)
+	}
+	testfiles.AssertOnly(t, dir, original)
+
+	_, err = Rollback(context.Background(), backupDir, dir, "")
+	if !errors.Is(err, ErrNoBackup) {
+		t.Fatalf("expected no backup, got %v", err)
+	}
+}
+
+func TestRollbackSeveral(t *testing.T) {
+	dir := t.TempDir()
+	backupDir := t.TempDir()
+	testfiles.Write(t, dir, map[string]string{"a.txt": "original"})
+
+	for _, version := range []string{"1.0", "1.1", "1.2"} {
+		_, err := beginJournal(backupDir, version, dir, []string{"a.txt"}, nil)
+		if err != nil {
+			t.Fatalf("journal %s: %v", version, err)
+		}
+		testfiles.Write(t, dir, map[string]string{"a.txt": version})
+	}
+
+	journals, err := Rollback(context.Background(), backupDir, dir, "1.1")
+	if err != nil {
+		t.Fatalf("rollback: %v", err)
+	}
+	if len(journals) != 2 || journals[0].Version != "1.2" || journals[1].Version != "1.1" {
+		t.Fatalf("unexpected rollback: %v", journals)
+	}
+	testfiles.AssertOnly(t, dir, map[string]string{"a.txt": "1.0"})
+
+	err = pruneBackups(backupDir, 0)
+	if err != nil {
+		t.Fatalf("prune: %v", err)
+	}
+	_, err = Rollback(context.Background(), backupDir, dir, "1.0")
+	if !errors.Is(err, ErrNoBackup) {
+		t.Fatalf("expected no backup after prune, got %v", err)
+	}
+}
@@ -0,0 +1,139 @@
+package patch
+
+import (
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	mirrorSuccessScore = 1
+	mirrorFailureScore = -5
+	mirrorMaxScore     = 10
+)
+
+// Mirrors is an ordered list of download prefixes. Each mirror keeps a health score,
+// so mirrors that fail are tried after healthy ones
+type Mirrors struct {
+	mux     sync.Mutex
+	entries []*mirror
+}
+
+type mirror struct {
+	url      string
+	index    int
+	score    int
+	failures int
+}
+
+// NewMirrors returns mirrors in preference order, normalized and without duplicates or blanks
+func NewMirrors(prefixes ...string) *Mirrors {
+	m := &Mirrors{}
+	seen := map[string]bool{}
+	for _, prefix := range prefixes {
+		prefix = NormalizeURL(prefix)
+		if prefix == "" || seen[prefix] {
+			continue
+		}
+		seen[prefix] = true
+		m.entries = append(m.entries, &mirror{url: prefix, index: len(m.entries)})
+	}
+	return m
+}
+
+// NormalizeURL trims a download prefix and rewrites GitHub raw branch paths that need a full ref,
+// e.g. master/rof becomes refs/heads/master/rof
+func NormalizeURL(prefix string) string {
+	prefix = strings.TrimSuffix(strings.TrimSpace(prefix), "/")
+	if prefix == "" {
+		return ""
+	}
+
+	u, err := url.Parse(prefix)
+	if err != nil {
+		return prefix
+	}
+	switch u.Host {
+	case "raw.githubusercontent.com", "github.com":
+	default:
+		return prefix
+	}
+	if strings.Contains(u.Path, "/refs/heads/") || !strings.Contains(u.Path, "/master/rof") {
+		return prefix
+	}
+	u.Path = strings.Replace(u.Path, "/master/rof", "/refs/heads/master/rof", 1)
+	return u.String()
+}
+
+// Order returns the mirror urls, healthiest first, keeping preference order between equals
+func (m *Mirrors) Order() []string {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	entries := make([]*mirror, len(m.entries))
+	copy(entries, m.entries)
+	sort.SliceStable(entries, func(i, j int) bool {
+		if entries[i].score != entries[j].score {
+			return entries[i].score > entries[j].score
+		}
+		return entries[i].index < entries[j].index
+	})
+
+	urls := make([]string, len(entries))
+	for i, entry := range entries {
+		urls[i] = entry.url
+	}
+	return urls
+}
+
+// Success raises the health of a mirror
+func (m *Mirrors) Success(url string) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	entry := m.find(url)
+	if entry == nil {
+		return
+	}
+	entry.score = min(entry.score+mirrorSuccessScore, mirrorMaxScore)
+}
+
+// Failure lowers the health of a mirror, returning true the first time it fails
+func (m *Mirrors) Failure(url string) bool {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	entry := m.find(url)
+	if entry == nil {
+		return false
+	}
+	entry.score += mirrorFailureScore
+	entry.failures++
+	return entry.failures == 1
+}
+
+// Score returns the health of a mirror
+func (m *Mirrors) Score(url string) int {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	entry := m.find(url)
+	if entry == nil {
+		return 0
+	}
+	return entry.score
+}
+
+// Len returns how many mirrors there are
+func (m *Mirrors) Len() int {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	return len(m.entries)
+}
+
+func (m *Mirrors) find(url string) *mirror {
+	for _, entry := range m.entries {
+		if entry.url == url {
+			return entry
+		}
+	}
+	return nil
+}
@@ -0,0 +1,177 @@
+package patch
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/xackery/rof2plus/checksum"
+	"github.com/xackery/rof2plus/internal/testfiles"
+	"github.com/xackery/rof2plus/lancache"
+)
+
+func TestNormalizeURL(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{in: "https://raw.githubusercontent.com/xackery/rof2plus/master/rof/", want: "https://raw.githubusercontent.com/xackery/rof2plus/refs/heads/master/rof"},
+		{in: "https://raw.githubusercontent.com/xackery/rof2plus/refs/heads/master/rof", want: "https://raw.githubusercontent.com/xackery/rof2plus/refs/heads/master/rof"},
+		{in: "https://cdn.example.com/master/rof/", want: "https://cdn.example.com/master/rof"},
+		{in: " http://192.168.1.5:8080/ ", want: "http://192.168.1.5:8080"},
+		{in: "", want: ""},
+	}
+	for _, tt := range tests {
+		got := NormalizeURL(tt.in)
+		if got != tt.want {
+			t.Errorf("%q: got %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestMirrorsOrder(t *testing.T) {
+	mirrors := NewMirrors("https://a.example.com/", "https://b.example.com", "https://a.example.com", "")
+	if mirrors.Len() != 2 {
+		t.Fatalf("expected duplicates and blanks removed, got %v", mirrors.Order())
+	}
+
+	if !mirrors.Failure("https://a.example.com") {
+		t.Fatalf("first failure should be reported")
+	}
+	if mirrors.Failure("https://a.example.com") {
+		t.Fatalf("second failure should not be reported")
+	}
+	order := mirrors.Order()
+	if order[0] != "https://b.example.com" {
+		t.Fatalf("failing mirror should sort last: %v", order)
+	}
+
+	for range 20 {
+		mirrors.Success("https://a.example.com")
+	}
+	if mirrors.Score("https://a.example.com") != mirrorMaxScore {
+		t.Fatalf("score should be capped, got %d", mirrors.Score("https://a.example.com"))
+	}
+	if mirrors.Order()[0] != "https://a.example.com" {
+		t.Fatalf("recovered mirror should sort first: %v", mirrors.Order())
+	}
+}
+
+func TestRunMirrorFailover(t *testing.T) {
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer down.Close()
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(strings.TrimPrefix(r.URL.Path, "/")))
+	}))
+	defer up.Close()
+
+	tampered := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("tampered " + strings.TrimPrefix(r.URL.Path, "/")))
+	}))
+	defer tampered.Close()
+
+	fileList := &checksum.FileList{DownloadPrefix: down.URL}
+	for _, name := range []string{"a.txt", "b.txt", "maps/c.txt"} {
+		fileList.Downloads = append(fileList.Downloads, testEntry(name, name))
+	}
+
+	// a mirror serving files that do not match the file list is skipped like one that is down
+	dir := t.TempDir()
+	err := Run(context.Background(), Options{FileList: fileList, Path: dir, Mirrors: []string{tampered.URL, up.URL + "/"}})
+	if err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	for _, file := range fileList.Downloads {
+		data, err := os.ReadFile(filepath.Join(dir, file.Name))
+		if err != nil || string(data) != file.Name {
+			t.Fatalf("%s: got %q %v", file.Name, data, err)
+		}
+	}
+
+	err = Run(context.Background(), Options{FileList: fileList, Path: t.TempDir()})
+	if err == nil || !strings.Contains(err.Error(), "all mirrors failed") {
+		t.Fatalf("expected all mirrors to fail, got %v", err)
+	}
+
+	tamperedDir := t.TempDir()
+	err = Run(context.Background(), Options{FileList: fileList, Path: tamperedDir, Mirrors: []string{tampered.URL}})
+	if !errors.Is(err, ErrChecksum) {
+		t.Fatalf("expected checksum mismatch, got %v", err)
+	}
+	entries, err := os.ReadDir(tamperedDir)
+	if err != nil {
+		t.Fatalf("read dir: %v", err)
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			t.Fatalf("tampered file %s was kept", entry.Name())
+		}
+	}
+}
+
+func TestRunLANCache(t *testing.T) {
+	direct := 0
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		direct++
+		w.Write([]byte("spell data"))
+	}))
+	defer upstream.Close()
+
+	srv := lancache.New(t.TempDir())
+	srv.Mirrors = []string{upstream.URL}
+	cache := httptest.NewServer(srv)
+	defer cache.Close()
+
+	fileList := &checksum.FileList{
+		DownloadPrefix: upstream.URL,
+		Downloads:      []checksum.FileEntry{{Name: "spells_us.txt", Md5: testfiles.MD5("spell data"), Size: 10}},
+	}
+
+	// two players patching through the cache only download from upstream once
+	for range 2 {
+		dir := t.TempDir()
+		err := Run(context.Background(), Options{FileList: fileList, Path: dir, LANCache: cache.URL})
+		if err != nil {
+			t.Fatalf("run: %v", err)
+		}
+		data, err := os.ReadFile(filepath.Join(dir, "spells_us.txt"))
+		if err != nil || string(data) != "spell data" {
+			t.Fatalf("got %q %v", data, err)
+		}
+	}
+	if direct != 1 {
+		t.Fatalf("expected one upstream download, got %d", direct)
+	}
+
+	// a dead cache falls back to direct downloads
+	cache.Close()
+	err := Run(context.Background(), Options{FileList: fileList, Path: t.TempDir(), LANCache: cache.URL})
+	if err != nil {
+		t.Fatalf("run without cache: %v", err)
+	}
+	if direct != 2 {
+		t.Fatalf("expected a direct download, got %d", direct)
+	}
+
+	// a cache answering with other content is not trusted
+	rogue := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("malicious!"))
+	}))
+	defer rogue.Close()
+	dir := t.TempDir()
+	err = Run(context.Background(), Options{FileList: fileList, Path: dir, LANCache: rogue.URL})
+	if err != nil {
+		t.Fatalf("run with rogue cache: %v", err)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "spells_us.txt"))
+	if err != nil || string(data) != "spell data" {
+		t.Fatalf("got %q %v", data, err)
+	}
+}
@@ -3,28 +3,51 @@ package patch
 
 import (
 	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 	"net/http"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/xackery/rof2plus/check"
 	"github.com/xackery/rof2plus/checksum"
+	"github.com/xackery/rof2plus/diskspace"
+	"github.com/xackery/rof2plus/lancache"
+	"github.com/xackery/rof2plus/lock"
+	"github.com/xackery/rof2plus/proc"
 )
 
+// ErrChecksum is returned when a downloaded file does not match the file list
+var ErrChecksum = errors.New("checksum mismatch")
+
+// gameWait is how long a patch waits for everquest to close before giving up
+const gameWait = 5 * time.Minute
+
 var (
 	isDownloading   atomic.Bool
 	progressPercent atomic.Int32
 )
 
 type downloadRequest struct {
-	Name string
-	Path string
-	URL  string
+	Name    string
+	Path    string
+	MD5     string
+	Mirrors *Mirrors
+	Cache   *lanCache
+}
+
+// lanCache is a serve-cache that is skipped once it fails
+type lanCache struct {
+	url        string
+	isDisabled atomic.Bool
 }
 
 type downloadResult struct {
@@ -33,60 +56,272 @@ type downloadResult struct {
 	Err  error
 }
 
-// Download downloads a list of files to path
-func Download(filelist *checksum.FileList, path string) error {
-	var err error
+// Options describes a patch of one directory
+type Options struct {
+	// FileList is the server's file list
+	FileList *checksum.FileList
+	// Path is the server directory to patch
+	Path string
+	// Mirrors are download prefixes tried per file when the file list's download prefix fails
+	Mirrors []string
+	// LANCache is a rof2plus serve-cache url tried before any mirror
+	LANCache string
+	// BackupDir keeps a copy of every file the patch replaces or deletes, see Rollback. Empty disables backups
+	BackupDir string
+	// ReferencePath is a vanilla client that mismatched archives are compared to, see check.Options
+	ReferencePath string
+	// ReportFile keeps the result of the check the patch starts with. Empty skips it
+	ReportFile string
+}
 
+// Download downloads a list of files to path, stopping when ctx is done
+func Download(ctx context.Context, filelist *checksum.FileList, path string) error {
 	if isDownloading.Load() {
 		return fmt.Errorf("already downloading")
 	}
 	isDownloading.Store(true)
 	defer isDownloading.Store(false)
 
-	err = checksum.SetPatcherFilelist(filelist)
+	err := checksum.SetPatcherFilelist(filelist)
 	if err != nil {
 		return fmt.Errorf("set patcher filelist: %w", err)
 	}
 
-	checksum.SetExcludedClients(checksum.ClientRoF2Core)
+	return Run(ctx, Options{FileList: filelist, Path: path})
+}
+
+// Run downloads missing files and unpacks changed zips in opts.FileList to opts.Path, independent of any other patch
+func Run(parent context.Context, opts Options) error {
+	var err error
 
-	err = check.Check(checksum.ClientPatcher, path)
+	filelist := opts.FileList
+	path := opts.Path
+	if filelist == nil {
+		return fmt.Errorf("filelist is nil")
+	}
+	// names come from the server, one like ../eqgame.exe would reach outside path
+	for _, file := range filelist.Downloads {
+		if !filepath.IsLocal(filepath.FromSlash(file.Name)) {
+			return fmt.Errorf("invalid download %s", file.Name)
+		}
+	}
+	for _, file := range filelist.Deletes {
+		if !filepath.IsLocal(filepath.FromSlash(file.Name)) {
+			return fmt.Errorf("invalid delete %s", file.Name)
+		}
+	}
+
+	l, err := lock.Acquire(path)
+	if err != nil {
+		return err
+	}
+	defer l.Release()
+
+	report, err := check.Run(parent, check.Options{
+		Client:        checksum.ClientPatcher,
+		Path:          path,
+		Manifest:      checksum.FileListManifest(filelist),
+		ReferencePath: opts.ReferencePath,
+	})
 	if err != nil {
 		return fmt.Errorf("check: %w", err)
 	}
+	for _, fail := range report.Failures {
+		if len(fail.Entries) > 0 {
+			slog.Info("archive differs", "path", fail.Path, "directions", fail.Directions)
+		}
+	}
+	if opts.ReportFile != "" {
+		// the report only helps diagnose a client, failing to keep it does not stop the patch
+		err = writeReport(opts.ReportFile, filelist, path, report)
+		if err != nil {
+			slog.Warn("check report", "path", opts.ReportFile, "err", err)
+		}
+	}
 
 	downloads := []checksum.FileEntry{}
-
-	isPatchNeeded := false
-	report := check.Report()
-	if report != nil {
-		for _, fail := range report.Failures {
-			switch fail.Error {
-			case check.ErrorNotFound:
-				isFound := false
-				for _, file := range filelist.Downloads {
-					if fail.Path != file.Name {
-						continue
-					}
-					isFound = true
-					downloads = append(downloads, file)
-					break
+	for _, fail := range report.Failures {
+		switch fail.Error {
+		case check.ErrorNotFound, check.ErrorSize, check.ErrorHash:
+			isFound := false
+			for _, file := range filelist.Downloads {
+				if fail.Path != file.Name {
+					continue
 				}
-				if !isFound {
-					return fmt.Errorf("file %s not found in filelist", fail.Path)
-				}
-
-				isPatchNeeded = true
-				continue
-			default:
+				isFound = true
+				downloads = append(downloads, file)
+				break
+			}
+			if !isFound {
+				return fmt.Errorf("file %s not found in filelist", fail.Path)
 			}
+		default:
+		}
+	}
+
+	deletes := []string{}
+	for _, file := range filelist.Deletes {
+		fi, err := os.Stat(filepath.Join(path, filepath.FromSlash(file.Name)))
+		if err != nil || fi.IsDir() {
+			continue
 		}
+		deletes = append(deletes, file.Name)
+	}
+
+	records, err := readUnpackRecords(path)
+	if err != nil {
+		return err
+	}
+	unpacks, err := pendingUnpacks(path, filelist, records)
+	if err != nil {
+		return err
 	}
-	if !isPatchNeeded {
+
+	if len(downloads) == 0 && len(deletes) == 0 && len(unpacks) == 0 {
+		slog.Debug("no patch needed", "path", path, "version", filelist.Version)
 		fmt.Println("No patch needed")
 		return nil
 	}
 
+	err = proc.WaitForExit(parent, path, gameWait)
+	if err != nil {
+		return err
+	}
+
+	err = preflight(opts, downloads, deletes, unpacks, nil)
+	if err != nil {
+		return err
+	}
+
+	// zips are fetched beside path first, the files they replace are only known once they are opened
+	var fetched []*fetchedUnpack
+	if len(unpacks) > 0 {
+		unpackDir, err := os.MkdirTemp(filepath.Dir(path), "."+filepath.Base(path)+".unpack")
+		if err != nil {
+			return fmt.Errorf("unpack dir: %w", err)
+		}
+		defer os.RemoveAll(unpackDir)
+		err = download(parent, opts, unpackDir, unpacks)
+		if err != nil {
+			return err
+		}
+		fetched, err = openUnpacks(unpackDir, unpacks)
+		if err != nil {
+			return err
+		}
+		defer closeUnpacks(fetched)
+		// only the opened zips tell how much they extract to
+		err = preflight(opts, downloads, deletes, nil, fetched)
+		if err != nil {
+			return err
+		}
+	}
+
+	var journal *Journal
+	if opts.BackupDir != "" {
+		names := []string{}
+		for _, file := range downloads {
+			names = append(names, file.Name)
+		}
+		names = append(names, unpackNames(fetched)...)
+		journal, err = beginJournal(opts.BackupDir, filelist.Version, path, names, deletes)
+		if err != nil {
+			return fmt.Errorf("backup: %w", err)
+		}
+	}
+
+	for _, name := range deletes {
+		err = os.Remove(filepath.Join(path, filepath.FromSlash(name)))
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("delete %s: %w", name, err)
+		}
+	}
+	if len(deletes) > 0 {
+		slog.Info("deleted files", "path", path, "files", deletes)
+		fmt.Println("Deleted", len(deletes), "files")
+	}
+
+	err = download(parent, opts, path, downloads)
+	if err != nil {
+		return err
+	}
+
+	err = extractUnpacks(parent, path, fetched, records)
+	if err != nil {
+		return err
+	}
+
+	if journal != nil {
+		err = journal.complete()
+		if err != nil {
+			return fmt.Errorf("backup: %w", err)
+		}
+		err = pruneBackups(opts.BackupDir, keepBackups)
+		if err != nil {
+			return fmt.Errorf("prune backups: %w", err)
+		}
+	}
+	return nil
+}
+
+// preflight aborts the patch if downloads, unpack zips, the files extracted from fetched zips, and backups
+// of the files they replace, won't fit
+func preflight(opts Options, downloads []checksum.FileEntry, deletes []string, unpacks []checksum.FileEntry, fetched []*fetchedUnpack) error {
+	needs := []diskspace.Need{}
+	downloadBytes := int64(0)
+	backupBytes := int64(0)
+	for _, file := range downloads {
+		downloadBytes += int64(file.Size)
+		backupBytes += existingSize(opts.Path, file.Name)
+	}
+	needs = append(needs, diskspace.Need{Path: opts.Path, Reason: "downloads", Bytes: downloadBytes})
+	unpackBytes := int64(0)
+	for _, file := range unpacks {
+		unpackBytes += int64(file.Size)
+	}
+	for _, unpack := range fetched {
+		for _, file := range unpack.files {
+			unpackBytes += int64(file.file.UncompressedSize64)
+			backupBytes += existingSize(opts.Path, file.name)
+		}
+	}
+	needs = append(needs, diskspace.Need{Path: opts.Path, Reason: "unpacks", Bytes: unpackBytes})
+	if opts.BackupDir != "" {
+		for _, name := range deletes {
+			backupBytes += existingSize(opts.Path, name)
+		}
+		needs = append(needs, diskspace.Need{Path: opts.BackupDir, Reason: "backups", Bytes: backupBytes})
+	}
+
+	report, err := diskspace.Check(needs...)
+	if err != nil {
+		return fmt.Errorf("preflight: %w", err)
+	}
+	for _, warning := range report.Warnings() {
+		slog.Warn(warning)
+		fmt.Println(warning)
+	}
+	return nil
+}
+
+// existingSize returns the size of name under path, or 0 if it is not a file
+func existingSize(path string, name string) int64 {
+	fi, err := os.Stat(filepath.Join(path, filepath.FromSlash(name)))
+	if err != nil || fi.IsDir() {
+		return 0
+	}
+	return fi.Size()
+}
+
+// download fetches downloads into path
+func download(parent context.Context, opts Options, path string, downloads []checksum.FileEntry) error {
+	var err error
+
+	filelist := opts.FileList
+	if len(downloads) == 0 {
+		return nil
+	}
+
 	start := time.Now()
 	totalSizeToDownloadInKB := int64(0)
 	totalSizeDownloadedInKB := int64(0)
@@ -95,7 +330,7 @@ func Download(filelist *checksum.FileList, path string) error {
 	downloadRequestChan := make(chan *downloadRequest, 100000)
 	downloadResultChan := make(chan *downloadResult, 1000)
 
-	ctx, cancel := context.WithCancelCause(context.Background())
+	ctx, cancel := context.WithCancelCause(parent)
 	defer func() {
 		if err != nil {
 			cancel(err)
@@ -104,8 +339,13 @@ func Download(filelist *checksum.FileList, path string) error {
 		cancel(nil)
 	}()
 
-	if strings.Contains(filelist.DownloadPrefix, "master/rof") {
-		filelist.DownloadPrefix = strings.ReplaceAll(filelist.DownloadPrefix, "master/rof", "refs/heads/master/rof")
+	mirrors := NewMirrors(append([]string{filelist.DownloadPrefix}, opts.Mirrors...)...)
+	if mirrors.Len() == 0 {
+		return fmt.Errorf("filelist has no download prefix and no mirrors are set")
+	}
+	var cache *lanCache
+	if opts.LANCache != "" {
+		cache = &lanCache{url: opts.LANCache}
 	}
 
 	for _, file := range downloads {
@@ -116,19 +356,26 @@ func Download(filelist *checksum.FileList, path string) error {
 			return err
 		}
 
-		downloadRequestChan <- &downloadRequest{Name: file.Name, Path: path, URL: strings.TrimSuffix(filelist.DownloadPrefix, "/")}
+		downloadRequestChan <- &downloadRequest{Name: file.Name, Path: path, MD5: file.Md5, Mirrors: mirrors, Cache: cache}
 	}
+	slog.Info("downloading", "path", path, "version", filelist.Version, "files", totalCount, "kb", totalSizeToDownloadInKB)
 	fmt.Println("Downloading", totalCount, "files")
 	if totalSizeToDownloadInKB < 1 {
 		totalSizeToDownloadInKB = 1
 	}
 
 	isDone := make(chan bool)
+	completed := atomic.Int32{}
 
 	numJobsConcurrent := 100
 	// job consumer
+	wg := sync.WaitGroup{}
 	for range numJobsConcurrent {
-		go downloader(ctx, downloadRequestChan, downloadResultChan)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			downloader(ctx, downloadRequestChan, downloadResultChan)
+		}()
 	}
 
 	// result consumer
@@ -145,6 +392,8 @@ func Download(filelist *checksum.FileList, path string) error {
 					cancel(result.Err)
 					return
 				}
+				completed.Store(int32(count))
+				slog.Debug("downloaded", "file", result.Name, "bytes", result.Size)
 				totalSizeDownloadedInKB += result.Size / 1024
 				progressPercent.Store(int32(totalSizeDownloadedInKB * 100 / totalSizeToDownloadInKB))
 
@@ -161,6 +410,7 @@ func Download(filelist *checksum.FileList, path string) error {
 					size = fmt.Sprintf("(%0.2f MB)", float64(totalSizeDownloadedInKB)/1024)
 				}
 
+				slog.Info("download complete", "files", count, "kb", totalSizeDownloadedInKB, "seconds", time.Since(start).Seconds())
 				fmt.Printf("Downloaded %d files %s in %0.2fs\n", count, size, time.Since(start).Seconds())
 				break
 			}
@@ -171,6 +421,13 @@ func Download(filelist *checksum.FileList, path string) error {
 	// wait for consumer to finish
 	select {
 	case <-ctx.Done():
+		// workers remove their partial files as they stop
+		wg.Wait()
+		if parent.Err() != nil {
+			slog.Info("download interrupted", "completed", completed.Load(), "files", totalCount)
+			fmt.Printf("Interrupted after downloading %d of %d files\n", completed.Load(), totalCount)
+			return parent.Err()
+		}
 		if err != nil {
 			return err
 		}
@@ -191,9 +448,7 @@ func downloader(ctx context.Context, downloadRequestChan chan *downloadRequest,
 				return
 			}
 
-			requestNameToURL := strings.ReplaceAll(request.Name, "\\", "/")
-			//requestNameToURL = strings.ReplaceAll(requestNameToURL, " ", "%20")
-			copiedBytes, err := downloadFile(ctx, request.URL+"/"+requestNameToURL, filepath.Join(request.Path, request.Name))
+			copiedBytes, err := downloadMirrored(ctx, request)
 			if err != nil {
 				select {
 				case <-ctx.Done():
@@ -211,16 +466,53 @@ func downloader(ctx context.Context, downloadRequestChan chan *downloadRequest,
 	}
 }
 
-// downloadFile downloads a file from the given URL to the specified path
-func downloadFile(ctx context.Context, url string, path string) (int64, error) {
+// downloadMirrored downloads a request from the healthiest mirror, failing over to the next on error
+func downloadMirrored(ctx context.Context, request *downloadRequest) (int64, error) {
+	requestNameToURL := strings.ReplaceAll(request.Name, "\\", "/")
+	//requestNameToURL = strings.ReplaceAll(requestNameToURL, " ", "%20")
 
-	// Create the file
-	w, err := os.Create(path)
-	if err != nil {
-		return 0, fmt.Errorf("create file %s: %w", path, err)
+	if request.MD5 == "" {
+		return 0, fmt.Errorf("%s has no md5 in the file list", request.Name)
+	}
+
+	prefixes := request.Mirrors.Order()
+	if request.Cache != nil && !request.Cache.isDisabled.Load() {
+		upstream := prefixes[0] + "/" + requestNameToURL
+		copiedBytes, err := downloadFile(ctx, lancache.FileURL(request.Cache.url, request.MD5, upstream), filepath.Join(request.Path, request.Name), request.MD5)
+		if err == nil {
+			return copiedBytes, nil
+		}
+		if ctx.Err() != nil {
+			return 0, err
+		}
+		if request.Cache.isDisabled.CompareAndSwap(false, true) {
+			slog.Warn("lan cache failed", "cache", request.Cache.url, "file", request.Name, "err", err)
+			fmt.Printf("LAN cache %s failed, downloading directly: %v\n", request.Cache.url, err)
+		}
 	}
-	defer w.Close()
 
+	var lastErr error
+	for _, prefix := range prefixes {
+		copiedBytes, err := downloadFile(ctx, prefix+"/"+requestNameToURL, filepath.Join(request.Path, request.Name), request.MD5)
+		if err == nil {
+			request.Mirrors.Success(prefix)
+			return copiedBytes, nil
+		}
+		if ctx.Err() != nil {
+			return 0, err
+		}
+		lastErr = err
+		slog.Warn("mirror failed", "mirror", prefix, "file", request.Name, "err", err)
+		if request.Mirrors.Failure(prefix) && request.Mirrors.Len() > 1 {
+			fmt.Printf("Mirror %s failed, trying others: %v\n", prefix, err)
+		}
+	}
+	return 0, fmt.Errorf("all mirrors failed: %w", lastErr)
+}
+
+// downloadFile downloads a file from the given URL to the specified path, keeping it only if it
+// matches hash. A cache or mirror could serve anything, only the file list is trusted
+func downloadFile(ctx context.Context, url string, path string, hash string) (int64, error) {
 	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
 		return 0, fmt.Errorf("create request: %w", err)
@@ -235,11 +527,32 @@ func downloadFile(ctx context.Context, url string, path string) (int64, error) {
 		return 0, fmt.Errorf("download %s responded HTTP status code %d", url, resp.StatusCode)
 	}
 
-	// Write the body to file
-	copiedBytes, err := io.Copy(w, resp.Body)
+	// Write the body to a partial file, only a complete download replaces path
+	partPath := path + ".part"
+	w, err := os.Create(partPath)
+	if err != nil {
+		return 0, fmt.Errorf("create file %s: %w", partPath, err)
+	}
+	h := md5.New()
+	copiedBytes, err := io.Copy(io.MultiWriter(w, h), resp.Body)
+	closeErr := w.Close()
+	if err == nil {
+		err = closeErr
+	}
 	if err != nil {
+		os.Remove(partPath)
 		return 0, fmt.Errorf("write file %s: %w", path, err)
 	}
+	got := hex.EncodeToString(h.Sum(nil))
+	if !strings.EqualFold(got, hash) {
+		os.Remove(partPath)
+		return 0, fmt.Errorf("%s has md5 %s, expected %s: %w", url, got, hash, ErrChecksum)
+	}
 
+	err = os.Rename(partPath, path)
+	if err != nil {
+		os.Remove(partPath)
+		return 0, fmt.Errorf("rename %s: %w", partPath, err)
+	}
 	return copiedBytes, nil
 }
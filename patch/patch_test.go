@@ -1,9 +1,19 @@
 package patch
 
 import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
 
 	"github.com/xackery/rof2plus/checksum"
+	"github.com/xackery/rof2plus/internal/testfiles"
+	"github.com/xackery/rof2plus/lock"
 )
 
 func TestPatch(t *testing.T) {
@@ -12,14 +22,131 @@ func TestPatch(t *testing.T) {
 	//url := "https://github.com/jamfesteq/eqemupatcher/releases/download/1.0.6.34"
 	//url := "https://github.com/carolus21rex/eqemupatcher/releases/download/1.0.6.34/"
 	url := "https://github.com/The-Heroes-Journey-EQEMU/eqemupatcher/releases/download/1.0.6.453/"
-	fileList, err := checksum.FetchPatcherFilelist(url)
+	fileList, err := checksum.FetchPatcherFilelist(url, t.TempDir())
 	if err != nil {
 		t.Fatalf("Failed to fetch filelist: %v", err)
 	}
 
-	err = Download(fileList, testDir)
+	err = Download(context.Background(), fileList, testDir)
 	if err != nil {
 		t.Fatalf("Failed to download: %v", err)
 	}
 
 }
+
+func TestRunIndependent(t *testing.T) {
+	type run struct {
+		dir      string
+		report   string
+		fileList *checksum.FileList
+		err      error
+	}
+	runs := []*run{}
+	for _, content := range []string{"first server", "second"} {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/files/spells_us.txt" {
+				http.NotFound(w, r)
+				return
+			}
+			w.Write([]byte(content))
+		}))
+		t.Cleanup(srv.Close)
+
+		runs = append(runs, &run{
+			dir:    t.TempDir(),
+			report: filepath.Join(t.TempDir(), "check.txt"),
+			fileList: &checksum.FileList{
+				DownloadPrefix: srv.URL + "/files/",
+				Downloads:      []checksum.FileEntry{testEntry("spells_us.txt", content)},
+			},
+		})
+	}
+
+	wg := sync.WaitGroup{}
+	for _, r := range runs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r.err = Run(context.Background(), Options{FileList: r.fileList, Path: r.dir, ReportFile: r.report})
+		}()
+	}
+	wg.Wait()
+
+	for i, r := range runs {
+		if r.err != nil {
+			t.Fatalf("run %d: %v", i, r.err)
+		}
+		data, err := os.ReadFile(filepath.Join(r.dir, "spells_us.txt"))
+		if err != nil {
+			t.Fatalf("run %d: read: %v", i, err)
+		}
+		if len(data) != r.fileList.Downloads[0].Size {
+			t.Fatalf("run %d: got %q", i, data)
+		}
+		data, err = os.ReadFile(r.report)
+		if err != nil || !strings.Contains(string(data), "spells_us.txt: File not found") {
+			t.Fatalf("run %d: report %q: %v", i, data, err)
+		}
+	}
+}
+
+func TestRunInterrupted(t *testing.T) {
+	started := make(chan bool, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// send part of the file, then stall until the client goes away
+		w.Write([]byte("partial"))
+		w.(http.Flusher).Flush()
+		started <- true
+		<-r.Context().Done()
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	fileList := &checksum.FileList{
+		DownloadPrefix: srv.URL,
+		Downloads:      []checksum.FileEntry{{Name: "spells_us.txt", Md5: "00000000000000000000000000000000", Size: 100}},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-started
+		cancel()
+	}()
+	err := Run(ctx, Options{FileList: fileList, Path: dir})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected cancel, got %v", err)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("read dir: %v", err)
+	}
+	if len(entries) != 0 {
+		t.Fatalf("expected no partial files, got %s", entries[0].Name())
+	}
+	lockPath, err := lock.Path(dir)
+	if err != nil {
+		t.Fatalf("lock path: %v", err)
+	}
+	_, err = os.Stat(lockPath)
+	if !os.IsNotExist(err) {
+		t.Fatalf("expected lock to be released, got %v", err)
+	}
+}
+
+func TestRunEscape(t *testing.T) {
+	root := t.TempDir()
+	dir := filepath.Join(root, "server")
+	testfiles.Write(t, root, map[string]string{"outside.txt": "keep", "server/spells_us.txt": "spells"})
+
+	for _, fileList := range []*checksum.FileList{
+		{Deletes: []checksum.FileEntry{{Name: "../outside.txt"}}},
+		{Downloads: []checksum.FileEntry{testEntry("../outside.txt", "replaced")}},
+	} {
+		err := Run(context.Background(), Options{FileList: fileList, Path: dir})
+		if err == nil || !strings.Contains(err.Error(), "invalid") {
+			t.Fatalf("expected invalid name, got %v", err)
+		}
+	}
+	testfiles.AssertOnly(t, root, map[string]string{"outside.txt": "keep", "server/spells_us.txt": "spells"})
+}
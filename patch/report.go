@@ -0,0 +1,42 @@
+package patch
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/xackery/rof2plus/check"
+	"github.com/xackery/rof2plus/checksum"
+)
+
+// maxReportFailures is how many failures a check report lists, a fresh install fails every file
+const maxReportFailures = 200
+
+// writeReport saves the check of path against filelist as text to reportPath
+func writeReport(reportPath string, filelist *checksum.FileList, path string, report *check.ReportDetail) error {
+	text := fmt.Sprintf("checked %s against file list version %s at %s\n", path, filelist.Version, time.Now().Format(time.RFC3339))
+	text += report.String() + "\n"
+	for i, fail := range report.Failures {
+		if i == maxReportFailures {
+			text += fmt.Sprintf("... %d more failures\n", len(report.Failures)-i)
+			break
+		}
+		text += fail.String() + "\n"
+	}
+
+	err := os.MkdirAll(filepath.Dir(reportPath), 0755)
+	if err != nil {
+		return fmt.Errorf("mkdir: %w", err)
+	}
+	err = os.WriteFile(reportPath+".tmp", []byte(text), 0644)
+	if err != nil {
+		return fmt.Errorf("write: %w", err)
+	}
+	err = os.Rename(reportPath+".tmp", reportPath)
+	if err != nil {
+		os.Remove(reportPath + ".tmp")
+		return fmt.Errorf("rename: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,199 @@
+package patch
+
+import (
+	"archive/zip"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/xackery/rof2plus/checksum"
+	"gopkg.in/yaml.v3"
+)
+
+// unpackRecordName lists the zips unpacked into a directory, so a zip is only fetched again when
+// the server changes it or its files go missing
+const unpackRecordName = "rof2plus_unpacks.yml"
+
+// unpackRecord is an unpacked zip
+type unpackRecord struct {
+	Md5 string `yaml:"md5"`
+	// Files are the unpacked files and their sizes
+	Files map[string]int64 `yaml:"files"`
+}
+
+// unpackFile is a file inside a downloaded zip
+type unpackFile struct {
+	name string
+	file *zip.File
+}
+
+// fetchedUnpack is a downloaded zip waiting to be extracted
+type fetchedUnpack struct {
+	entry checksum.FileEntry
+	r     *zip.ReadCloser
+	files []unpackFile
+}
+
+func readUnpackRecords(path string) (map[string]*unpackRecord, error) {
+	records := map[string]*unpackRecord{}
+	data, err := os.ReadFile(filepath.Join(path, unpackRecordName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return records, nil
+		}
+		return nil, fmt.Errorf("read unpacks: %w", err)
+	}
+	err = yaml.Unmarshal(data, &records)
+	if err != nil {
+		return nil, fmt.Errorf("decode unpacks: %w", err)
+	}
+	return records, nil
+}
+
+func writeUnpackRecords(path string, records map[string]*unpackRecord) error {
+	data, err := yaml.Marshal(records)
+	if err != nil {
+		return fmt.Errorf("encode unpacks: %w", err)
+	}
+	recordPath := filepath.Join(path, unpackRecordName)
+	err = os.WriteFile(recordPath+".tmp", data, 0644)
+	if err != nil {
+		return fmt.Errorf("write unpacks: %w", err)
+	}
+	err = os.Rename(recordPath+".tmp", recordPath)
+	if err != nil {
+		return fmt.Errorf("write unpacks: %w", err)
+	}
+	return nil
+}
+
+// pendingUnpacks returns the unpacks of fileList that changed since they were unpacked into path,
+// or whose files are missing or a different size
+func pendingUnpacks(path string, fileList *checksum.FileList, records map[string]*unpackRecord) ([]checksum.FileEntry, error) {
+	pending := []checksum.FileEntry{}
+	for _, entry := range fileList.Unpacks {
+		if !filepath.IsLocal(filepath.FromSlash(entry.Name)) || !filepath.IsLocal(filepath.FromSlash(entry.Zip)) {
+			return nil, fmt.Errorf("invalid unpack %s into %s", entry.Name, entry.Zip)
+		}
+		record, ok := records[entry.Name]
+		if !ok || !strings.EqualFold(record.Md5, entry.Md5) || len(record.Files) == 0 {
+			pending = append(pending, entry)
+			continue
+		}
+		for name, size := range record.Files {
+			fi, err := os.Stat(filepath.Join(path, filepath.FromSlash(name)))
+			if err != nil || fi.IsDir() || fi.Size() != size {
+				pending = append(pending, entry)
+				break
+			}
+		}
+	}
+	return pending, nil
+}
+
+// openUnpacks opens the zips of unpacks downloaded into dir, listing the files each writes
+func openUnpacks(dir string, unpacks []checksum.FileEntry) ([]*fetchedUnpack, error) {
+	fetched := []*fetchedUnpack{}
+	for _, entry := range unpacks {
+		r, err := zip.OpenReader(filepath.Join(dir, filepath.FromSlash(entry.Name)))
+		if err != nil {
+			closeUnpacks(fetched)
+			return nil, fmt.Errorf("open %s: %w", entry.Name, err)
+		}
+		unpack := &fetchedUnpack{entry: entry, r: r}
+		fetched = append(fetched, unpack)
+		for _, f := range r.File {
+			if f.FileInfo().IsDir() {
+				continue
+			}
+			// a zip could name files outside its directory, such as ../eqgame.exe
+			if !filepath.IsLocal(filepath.FromSlash(f.Name)) {
+				closeUnpacks(fetched)
+				return nil, fmt.Errorf("%s: invalid file name %q", entry.Name, f.Name)
+			}
+			unpack.files = append(unpack.files, unpackFile{name: entry.Zip + "/" + strings.TrimPrefix(filepath.ToSlash(f.Name), "./"), file: f})
+		}
+	}
+	return fetched, nil
+}
+
+func closeUnpacks(fetched []*fetchedUnpack) {
+	for _, unpack := range fetched {
+		unpack.r.Close()
+	}
+}
+
+// extractUnpacks writes every file of fetched into path and records them
+func extractUnpacks(ctx context.Context, path string, fetched []*fetchedUnpack, records map[string]*unpackRecord) error {
+	for _, unpack := range fetched {
+		record := &unpackRecord{Md5: unpack.entry.Md5, Files: map[string]int64{}}
+		for _, file := range unpack.files {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			size, err := extractFile(file.file, filepath.Join(path, filepath.FromSlash(file.name)))
+			if err != nil {
+				return fmt.Errorf("unpack %s: %w", file.name, err)
+			}
+			record.Files[file.name] = size
+		}
+		records[unpack.entry.Name] = record
+		err := writeUnpackRecords(path, records)
+		if err != nil {
+			return err
+		}
+		slog.Info("unpacked", "zip", unpack.entry.Name, "dir", unpack.entry.Zip, "files", len(unpack.files))
+		fmt.Printf("Unpacked %s (%d files)\n", unpack.entry.Name, len(unpack.files))
+	}
+	return nil
+}
+
+// extractFile writes f to dst through a partial file, returning its size
+func extractFile(f *zip.File, dst string) (int64, error) {
+	r, err := f.Open()
+	if err != nil {
+		return 0, err
+	}
+	defer r.Close()
+
+	err = os.MkdirAll(filepath.Dir(dst), 0755)
+	if err != nil {
+		return 0, err
+	}
+	w, err := os.Create(dst + ".part")
+	if err != nil {
+		return 0, err
+	}
+	size, err := io.Copy(w, r)
+	closeErr := w.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(dst + ".part")
+		return 0, err
+	}
+	err = os.Rename(dst+".part", dst)
+	if err != nil {
+		os.Remove(dst + ".part")
+		return 0, err
+	}
+	return size, nil
+}
+
+// unpackNames returns the files fetched writes, sorted
+func unpackNames(fetched []*fetchedUnpack) []string {
+	names := []string{}
+	for _, unpack := range fetched {
+		for _, file := range unpack.files {
+			names = append(names, file.name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,172 @@
+package patch
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/xackery/rof2plus/checksum"
+	"github.com/xackery/rof2plus/diskspace"
+	"github.com/xackery/rof2plus/internal/testfiles"
+)
+
+func testZip(t *testing.T, files map[string]string) []byte {
+	t.Helper()
+	buf := &bytes.Buffer{}
+	zw := zip.NewWriter(buf)
+	for name, content := range files {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatalf("zip %s: %v", name, err)
+		}
+		w.Write([]byte(content))
+	}
+	err := zw.Close()
+	if err != nil {
+		t.Fatalf("zip: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func unpackEntry(name string, dir string, data []byte) checksum.FileEntry {
+	return checksum.FileEntry{Name: name, Md5: testfiles.MD5(string(data)), Size: len(data), Zip: dir}
+}
+
+func TestRunUnpack(t *testing.T) {
+	zips := map[string][]byte{}
+	hits := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+		data, ok := zips[strings.TrimPrefix(r.URL.Path, "/")]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(data)
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	backupDir := t.TempDir()
+	testfiles.Write(t, dir, map[string]string{"maps/qeynos.txt": "vanilla map"})
+
+	zips["maps.zip"] = testZip(t, map[string]string{"qeynos.txt": "server map", "brewall/freeport.txt": "brewall map"})
+	fileList := &checksum.FileList{
+		Version:        "v1",
+		DownloadPrefix: srv.URL,
+		Unpacks:        []checksum.FileEntry{unpackEntry("maps.zip", "maps", zips["maps.zip"])},
+	}
+	err := Run(context.Background(), Options{FileList: fileList, Path: dir, BackupDir: backupDir})
+	if err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	unpacked := map[string]string{
+		"maps/qeynos.txt":           "server map",
+		"maps/brewall/freeport.txt": "brewall map",
+		unpackRecordName:            "",
+	}
+	data, err := os.ReadFile(filepath.Join(dir, unpackRecordName))
+	if err != nil {
+		t.Fatalf("read record: %v", err)
+	}
+	unpacked[unpackRecordName] = string(data)
+	testfiles.AssertOnly(t, dir, unpacked)
+
+	// nothing changed, the zip is not fetched again
+	hits = 0
+	err = Run(context.Background(), Options{FileList: fileList, Path: dir, BackupDir: backupDir})
+	if err != nil {
+		t.Fatalf("run again: %v", err)
+	}
+	if hits != 0 {
+		t.Fatalf("expected no downloads, got %d", hits)
+	}
+
+	// a missing file fetches the zip again
+	err = os.Remove(filepath.Join(dir, "maps", "brewall", "freeport.txt"))
+	if err != nil {
+		t.Fatalf("remove: %v", err)
+	}
+	err = Run(context.Background(), Options{FileList: fileList, Path: dir, BackupDir: backupDir})
+	if err != nil {
+		t.Fatalf("repair: %v", err)
+	}
+	if hits != 1 {
+		t.Fatalf("expected the zip to be fetched again, got %d requests", hits)
+	}
+	testfiles.AssertOnly(t, dir, unpacked)
+
+	// rolling back restores the replaced map and removes the added one
+	_, err = Rollback(context.Background(), backupDir, dir, "v1")
+	if err != nil {
+		t.Fatalf("rollback: %v", err)
+	}
+	data, err = os.ReadFile(filepath.Join(dir, "maps", "qeynos.txt"))
+	if err != nil || string(data) != "vanilla map" {
+		t.Fatalf("rollback: got %q %v", data, err)
+	}
+	_, err = os.Stat(filepath.Join(dir, "maps", "brewall", "freeport.txt"))
+	if !os.IsNotExist(err) {
+		t.Fatalf("expected added map removed, got %v", err)
+	}
+}
+
+func TestRunUnpackEscape(t *testing.T) {
+	data := testZip(t, map[string]string{"../eqgame.exe": "malicious"})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(data)
+	}))
+	defer srv.Close()
+
+	root := t.TempDir()
+	dir := filepath.Join(root, "server")
+	err := os.Mkdir(dir, 0755)
+	if err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	fileList := &checksum.FileList{
+		DownloadPrefix: srv.URL,
+		Unpacks:        []checksum.FileEntry{unpackEntry("maps.zip", "maps", data)},
+	}
+	err = Run(context.Background(), Options{FileList: fileList, Path: dir})
+	if err == nil || !strings.Contains(err.Error(), "invalid file name") {
+		t.Fatalf("expected invalid file name, got %v", err)
+	}
+	for _, path := range []string{filepath.Join(dir, "eqgame.exe"), filepath.Join(root, "eqgame.exe")} {
+		_, err = os.Stat(path)
+		if !os.IsNotExist(err) {
+			t.Fatalf("%s was written: %v", path, err)
+		}
+	}
+}
+
+func TestRunUnpackNoSpace(t *testing.T) {
+	hits := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+		http.NotFound(w, r)
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	entry := unpackEntry("maps.zip", "maps", []byte("zip"))
+	entry.Size = 1 << 60
+	fileList := &checksum.FileList{
+		DownloadPrefix: srv.URL,
+		Unpacks:        []checksum.FileEntry{entry},
+	}
+	err := Run(context.Background(), Options{FileList: fileList, Path: dir})
+	if !errors.Is(err, diskspace.ErrInsufficient) {
+		t.Fatalf("expected insufficient space, got %v", err)
+	}
+	if hits != 0 {
+		t.Fatalf("downloaded %d files without room for them", hits)
+	}
+}
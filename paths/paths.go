@@ -0,0 +1,203 @@
+// paths resolves where rof2plus keeps its config, data and cache, independent of the working directory
+package paths
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// ErrShortName is returned for a server short name that can't be used as a directory name
+var ErrShortName = errors.New("invalid server short name")
+
+// PortableMarker placed next to the executable keeps every file beside it
+const PortableMarker = "rof2plus.portable"
+
+// Paths are the directories rof2plus reads and writes
+type Paths struct {
+	// Config holds rof2plus.yaml and the server list
+	Config string
+	// Data holds client copies and server directories
+	Data string
+	// Cache holds downloaded file lists and other files that can be fetched again
+	Cache string
+	// IsPortable is true when every directory is the executable's directory
+	IsPortable bool
+}
+
+// Resolve returns the paths for this system. A portable marker, or a rof2plus.yaml from an older
+// version, next to the executable keeps everything beside it
+func Resolve() (*Paths, error) {
+	exePath, err := os.Executable()
+	if err != nil {
+		return nil, fmt.Errorf("executable: %w", err)
+	}
+	exePath, err = filepath.EvalSymlinks(exePath)
+	if err != nil {
+		return nil, fmt.Errorf("resolve executable: %w", err)
+	}
+	return resolve(runtime.GOOS, filepath.Dir(exePath), os.Getenv)
+}
+
+// Portable returns paths that keep everything in dir
+func Portable(dir string) *Paths {
+	return &Paths{
+		Config:     dir,
+		Data:       dir,
+		Cache:      filepath.Join(dir, "cache"),
+		IsPortable: true,
+	}
+}
+
+func resolve(goos string, exeDir string, getenv func(string) string) (*Paths, error) {
+	for _, name := range []string{PortableMarker, "rof2plus.yaml"} {
+		_, err := os.Stat(filepath.Join(exeDir, name))
+		if err == nil {
+			return Portable(exeDir), nil
+		}
+	}
+
+	p := &Paths{}
+	switch goos {
+	case "windows":
+		appData := getenv("APPDATA")
+		localAppData := getenv("LOCALAPPDATA")
+		if appData == "" || localAppData == "" {
+			return nil, fmt.Errorf("APPDATA and LOCALAPPDATA must be set")
+		}
+		p.Config = filepath.Join(appData, "rof2plus")
+		p.Data = filepath.Join(localAppData, "rof2plus")
+		p.Cache = filepath.Join(localAppData, "rof2plus", "cache")
+	case "darwin":
+		home := getenv("HOME")
+		if home == "" {
+			return nil, fmt.Errorf("HOME must be set")
+		}
+		p.Config = filepath.Join(home, "Library", "Application Support", "rof2plus")
+		p.Data = p.Config
+		p.Cache = filepath.Join(home, "Library", "Caches", "rof2plus")
+	default:
+		home := getenv("HOME")
+		if home == "" {
+			return nil, fmt.Errorf("HOME must be set")
+		}
+		p.Config = xdgDir(getenv, "XDG_CONFIG_HOME", filepath.Join(home, ".config"))
+		p.Data = xdgDir(getenv, "XDG_DATA_HOME", filepath.Join(home, ".local", "share"))
+		p.Cache = xdgDir(getenv, "XDG_CACHE_HOME", filepath.Join(home, ".cache"))
+	}
+	return p, nil
+}
+
+func xdgDir(getenv func(string) string, key string, fallback string) string {
+	dir := getenv(key)
+	if dir == "" || !filepath.IsAbs(dir) {
+		dir = fallback
+	}
+	return filepath.Join(dir, "rof2plus")
+}
+
+// Ensure creates every directory
+func (p *Paths) Ensure() error {
+	for _, dir := range []string{p.Config, p.Data, p.Cache} {
+		err := os.MkdirAll(dir, 0755)
+		if err != nil {
+			return fmt.Errorf("mkdir %s: %w", dir, err)
+		}
+	}
+	return nil
+}
+
+// ConfigFile is the path of rof2plus.yaml
+func (p *Paths) ConfigFile() string {
+	return filepath.Join(p.Config, "rof2plus.yaml")
+}
+
+// ServerListFile is the path of the cached server list
+func (p *Paths) ServerListFile() string {
+	return filepath.Join(p.Config, "rof2plus_servers.yaml")
+}
+
+// ClientDir is where a vanilla client copy named client is kept, e.g. rof2
+func (p *Paths) ClientDir(client string) string {
+	return filepath.Join(p.Data, client)
+}
+
+// ServerCacheDir keeps the cached file list of a server
+func (p *Paths) ServerCacheDir(shortName string) (string, error) {
+	err := checkShortName(shortName)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(p.Cache, "servers", shortName), nil
+}
+
+// CheckReportFile keeps the check the last patch of a server started with, for support bundles
+func (p *Paths) CheckReportFile(shortName string) (string, error) {
+	dir, err := p.ServerCacheDir(shortName)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "rof2plus_check.txt"), nil
+}
+
+// BackupDir keeps the files a server's patches replaced, so a patch can be rolled back
+func (p *Paths) BackupDir(shortName string) (string, error) {
+	err := checkShortName(shortName)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(p.Data, "backups", shortName), nil
+}
+
+// LogDir keeps the rotating log files
+func (p *Paths) LogDir() string {
+	return filepath.Join(p.Data, "logs")
+}
+
+// ServerDir is the patched client directory of a server. Servers are kept apart from client copies,
+// backups and logs, so a server named rof2 or logs can't be patched over them
+func (p *Paths) ServerDir(shortName string) (string, error) {
+	err := checkShortName(shortName)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(p.Data, "servers", shortName), nil
+}
+
+// MoveLegacyServerDir moves a server directory an older version kept directly in Data to ServerDir,
+// if ServerDir does not exist yet. Only a directory with the eqhost.txt patching writes is moved
+func (p *Paths) MoveLegacyServerDir(shortName string) error {
+	dir, err := p.ServerDir(shortName)
+	if err != nil {
+		return err
+	}
+	_, err = os.Stat(dir)
+	if !os.IsNotExist(err) {
+		return nil
+	}
+	legacyDir := filepath.Join(p.Data, shortName)
+	_, err = os.Stat(filepath.Join(legacyDir, "eqhost.txt"))
+	if err != nil {
+		return nil
+	}
+	err = os.MkdirAll(filepath.Dir(dir), 0755)
+	if err != nil {
+		return fmt.Errorf("mkdir: %w", err)
+	}
+	err = os.Rename(legacyDir, dir)
+	if err != nil {
+		return fmt.Errorf("move %s: %w", legacyDir, err)
+	}
+	return nil
+}
+
+// checkShortName rejects server short names that are not a single directory name, the server list is remote
+func checkShortName(shortName string) error {
+	if shortName == "" || shortName == "." || !filepath.IsLocal(shortName) || strings.ContainsAny(shortName, `/\`) {
+		return fmt.Errorf("%q: %w", shortName, ErrShortName)
+	}
+	return nil
+}
@@ -0,0 +1,135 @@
+package paths
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func testEnv(env map[string]string) func(string) string {
+	return func(key string) string {
+		return env[key]
+	}
+}
+
+func TestResolveLinux(t *testing.T) {
+	exeDir := t.TempDir()
+
+	p, err := resolve("linux", exeDir, testEnv(map[string]string{"HOME": "/home/player"}))
+	if err != nil {
+		t.Fatalf("resolve: %v", err)
+	}
+	if p.IsPortable {
+		t.Fatalf("expected installed paths")
+	}
+	if p.Config != "/home/player/.config/rof2plus" || p.Data != "/home/player/.local/share/rof2plus" || p.Cache != "/home/player/.cache/rof2plus" {
+		t.Fatalf("unexpected paths: %+v", p)
+	}
+	if p.ConfigFile() != "/home/player/.config/rof2plus/rof2plus.yaml" {
+		t.Fatalf("config file: got %s", p.ConfigFile())
+	}
+	dir, err := p.ServerDir("test")
+	if err != nil || dir != "/home/player/.local/share/rof2plus/servers/test" {
+		t.Fatalf("server dir: got %s %v", dir, err)
+	}
+
+	p, err = resolve("linux", exeDir, testEnv(map[string]string{"HOME": "/home/player", "XDG_CACHE_HOME": "/tmp/cache", "XDG_DATA_HOME": "relative"}))
+	if err != nil {
+		t.Fatalf("resolve: %v", err)
+	}
+	if p.Cache != "/tmp/cache/rof2plus" || p.Data != "/home/player/.local/share/rof2plus" {
+		t.Fatalf("xdg paths: got %+v", p)
+	}
+}
+
+func TestResolvePortable(t *testing.T) {
+	for _, marker := range []string{PortableMarker, "rof2plus.yaml"} {
+		exeDir := t.TempDir()
+		err := os.WriteFile(filepath.Join(exeDir, marker), nil, 0644)
+		if err != nil {
+			t.Fatalf("write marker: %v", err)
+		}
+
+		p, err := resolve("linux", exeDir, testEnv(nil))
+		if err != nil {
+			t.Fatalf("resolve: %v", err)
+		}
+		if !p.IsPortable || p.Config != exeDir || p.Data != exeDir || p.Cache != filepath.Join(exeDir, "cache") {
+			t.Fatalf("%s: expected portable paths, got %+v", marker, p)
+		}
+
+		err = p.Ensure()
+		if err != nil {
+			t.Fatalf("ensure: %v", err)
+		}
+		_, err = os.Stat(p.Cache)
+		if err != nil {
+			t.Fatalf("cache dir: %v", err)
+		}
+	}
+}
+
+func TestShortName(t *testing.T) {
+	p := Portable(t.TempDir())
+	for _, name := range []string{"", ".", "..", "../x", "a/b", `a\b`, "/abs"} {
+		_, err := p.ServerDir(name)
+		if !errors.Is(err, ErrShortName) {
+			t.Fatalf("%q: expected invalid server dir, got %v", name, err)
+		}
+		_, err = p.ServerCacheDir(name)
+		if !errors.Is(err, ErrShortName) {
+			t.Fatalf("%q: expected invalid cache dir, got %v", name, err)
+		}
+		_, err = p.BackupDir(name)
+		if !errors.Is(err, ErrShortName) {
+			t.Fatalf("%q: expected invalid backup dir, got %v", name, err)
+		}
+	}
+	for _, name := range []string{"rof2", "logs", "backups", "cache"} {
+		dir, err := p.ServerDir(name)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if dir == p.ClientDir(name) || dir == p.LogDir() || dir == p.Cache || dir == filepath.Join(p.Data, "backups") {
+			t.Fatalf("%s: server dir %s overlaps the app's own directories", name, dir)
+		}
+	}
+}
+
+func TestMoveLegacyServerDir(t *testing.T) {
+	p := Portable(t.TempDir())
+	legacyDir := filepath.Join(p.Data, "thj")
+	err := os.MkdirAll(legacyDir, 0755)
+	if err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	err = os.WriteFile(filepath.Join(legacyDir, "eqhost.txt"), []byte("host"), 0644)
+	if err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	// a vanilla client copy is not a server directory
+	err = os.MkdirAll(p.ClientDir("rof2"), 0755)
+	if err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+
+	for _, name := range []string{"thj", "rof2"} {
+		err = p.MoveLegacyServerDir(name)
+		if err != nil {
+			t.Fatalf("%s: move: %v", name, err)
+		}
+	}
+	dir, err := p.ServerDir("thj")
+	if err != nil {
+		t.Fatalf("server dir: %v", err)
+	}
+	_, err = os.Stat(filepath.Join(dir, "eqhost.txt"))
+	if err != nil {
+		t.Fatalf("expected moved server dir: %v", err)
+	}
+	_, err = os.Stat(p.ClientDir("rof2"))
+	if err != nil {
+		t.Fatalf("expected client dir to stay: %v", err)
+	}
+}
@@ -0,0 +1,409 @@
+// pfs reads and writes PFS archives, the container behind everquest .s3d, .eqg and .pfs files
+package pfs
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+const (
+	// magic is "PFS " in little endian
+	magic = 0x20534650
+	// version is the only archive version everquest writes
+	version = 0x00020000
+	// directoryCRC marks the entry holding every file name
+	directoryCRC = 0x61580AC9
+	// blockSize is how much uncompressed data each block holds when writing
+	blockSize = 8192
+	// maxEntries guards against a corrupt count making huge allocations
+	maxEntries = 1 << 20
+)
+
+var crcTable = makeCRCTable()
+
+var (
+	// ErrReplaced is an entry whose content differs from the reference archive
+	ErrReplaced = errors.New("replaced")
+	// ErrAdded is an entry the reference archive does not have
+	ErrAdded = errors.New("added")
+	// ErrMissing is an entry only the reference archive has
+	ErrMissing = errors.New("missing")
+)
+
+// Entry is a file inside an archive
+type Entry struct {
+	Name string
+	// CRC is the crc of the lowercase name and is how everquest finds the file
+	CRC    uint32
+	Offset uint32
+	// Size is the uncompressed size
+	Size uint32
+}
+
+// Archive is a parsed PFS archive
+type Archive struct {
+	Entries []*Entry
+	r       io.ReaderAt
+	size    int64
+	closer  io.Closer
+}
+
+// EntryError is a problem with one entry of an archive
+type EntryError struct {
+	Name string
+	Err  error
+}
+
+func (e *EntryError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Name, e.Err)
+}
+
+func (e *EntryError) Unwrap() error {
+	return e.Err
+}
+
+// File is an entry to write to an archive
+type File struct {
+	Name string
+	Data []byte
+}
+
+// IsArchive returns true if name has a PFS archive extension
+func IsArchive(name string) bool {
+	name = strings.ToLower(name)
+	return strings.HasSuffix(name, ".s3d") || strings.HasSuffix(name, ".eqg") || strings.HasSuffix(name, ".pfs")
+}
+
+// Open parses the archive at path
+func Open(path string) (*Archive, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("stat: %w", err)
+	}
+	a, err := Parse(f, fi.Size())
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	a.closer = f
+	return a, nil
+}
+
+// Close closes the file of an opened archive
+func (a *Archive) Close() error {
+	if a.closer == nil {
+		return nil
+	}
+	return a.closer.Close()
+}
+
+// Parse reads the archive header and directory from r
+func Parse(r io.ReaderAt, size int64) (*Archive, error) {
+	header := make([]byte, 12)
+	_, err := r.ReadAt(header, 0)
+	if err != nil {
+		return nil, fmt.Errorf("read header: %w", err)
+	}
+	dirOffset := int64(binary.LittleEndian.Uint32(header[0:]))
+	if binary.LittleEndian.Uint32(header[4:]) != magic {
+		return nil, fmt.Errorf("not a pfs archive")
+	}
+	if binary.LittleEndian.Uint32(header[8:]) != version {
+		return nil, fmt.Errorf("unsupported version 0x%x", binary.LittleEndian.Uint32(header[8:]))
+	}
+	if dirOffset+4 > size {
+		return nil, fmt.Errorf("directory offset %d is past the end of the archive", dirOffset)
+	}
+
+	countData := make([]byte, 4)
+	_, err = r.ReadAt(countData, dirOffset)
+	if err != nil {
+		return nil, fmt.Errorf("read directory: %w", err)
+	}
+	count := int64(binary.LittleEndian.Uint32(countData))
+	if count > maxEntries || dirOffset+4+count*12 > size {
+		return nil, fmt.Errorf("directory of %d entries does not fit the archive", count)
+	}
+
+	dirData := make([]byte, count*12)
+	_, err = r.ReadAt(dirData, dirOffset+4)
+	if err != nil {
+		return nil, fmt.Errorf("read directory: %w", err)
+	}
+
+	a := &Archive{r: r, size: size}
+	var names *Entry
+	for i := int64(0); i < count; i++ {
+		entry := &Entry{
+			CRC:    binary.LittleEndian.Uint32(dirData[i*12:]),
+			Offset: binary.LittleEndian.Uint32(dirData[i*12+4:]),
+			Size:   binary.LittleEndian.Uint32(dirData[i*12+8:]),
+		}
+		if entry.CRC == directoryCRC {
+			names = entry
+			continue
+		}
+		a.Entries = append(a.Entries, entry)
+	}
+	if names == nil {
+		return nil, fmt.Errorf("missing file name directory")
+	}
+
+	data, err := a.read(names)
+	if err != nil {
+		return nil, fmt.Errorf("read file names: %w", err)
+	}
+	fileNames, err := parseNames(data)
+	if err != nil {
+		return nil, fmt.Errorf("parse file names: %w", err)
+	}
+	if len(fileNames) != len(a.Entries) {
+		return nil, fmt.Errorf("%d file names for %d entries", len(fileNames), len(a.Entries))
+	}
+
+	// names are stored in the order of the entry data
+	sort.SliceStable(a.Entries, func(i, j int) bool {
+		return a.Entries[i].Offset < a.Entries[j].Offset
+	})
+	for i, entry := range a.Entries {
+		entry.Name = fileNames[i]
+	}
+	return a, nil
+}
+
+func parseNames(data []byte) ([]string, error) {
+	if len(data) < 4 {
+		return nil, fmt.Errorf("too short")
+	}
+	count := int(binary.LittleEndian.Uint32(data))
+	pos := 4
+	names := []string{}
+	for i := 0; i < count; i++ {
+		if pos+4 > len(data) {
+			return nil, fmt.Errorf("name %d is truncated", i)
+		}
+		length := int(binary.LittleEndian.Uint32(data[pos:]))
+		pos += 4
+		if length < 0 || pos+length > len(data) {
+			return nil, fmt.Errorf("name %d is truncated", i)
+		}
+		names = append(names, strings.TrimRight(string(data[pos:pos+length]), "\x00"))
+		pos += length
+	}
+	return names, nil
+}
+
+// Entry returns the entry named name, ignoring case
+func (a *Archive) Entry(name string) *Entry {
+	for _, entry := range a.Entries {
+		if strings.EqualFold(entry.Name, name) {
+			return entry
+		}
+	}
+	return nil
+}
+
+// ReadEntry returns the uncompressed content of entry
+func (a *Archive) ReadEntry(entry *Entry) ([]byte, error) {
+	return a.read(entry)
+}
+
+// read inflates the blocks of entry until its size is reached
+func (a *Archive) read(entry *Entry) ([]byte, error) {
+	// a corrupt size should not allocate gigabytes up front
+	out := bytes.NewBuffer(make([]byte, 0, min(int64(entry.Size), a.size*4)))
+	pos := int64(entry.Offset)
+	blockHeader := make([]byte, 8)
+	for out.Len() < int(entry.Size) {
+		if pos+8 > a.size {
+			return nil, fmt.Errorf("block at %d is past the end of the archive", pos)
+		}
+		_, err := a.r.ReadAt(blockHeader, pos)
+		if err != nil {
+			return nil, fmt.Errorf("read block header: %w", err)
+		}
+		deflated := int64(binary.LittleEndian.Uint32(blockHeader))
+		inflated := int64(binary.LittleEndian.Uint32(blockHeader[4:]))
+		pos += 8
+		if pos+deflated > a.size {
+			return nil, fmt.Errorf("block at %d is past the end of the archive", pos)
+		}
+
+		zr, err := zlib.NewReader(io.NewSectionReader(a.r, pos, deflated))
+		if err != nil {
+			return nil, fmt.Errorf("block at %d: %w", pos, err)
+		}
+		n, err := io.Copy(out, io.LimitReader(zr, inflated+1))
+		if err == nil {
+			err = zr.Close()
+		}
+		if err != nil {
+			return nil, fmt.Errorf("block at %d: %w", pos, err)
+		}
+		if n != inflated {
+			return nil, fmt.Errorf("block at %d inflated to %d bytes, expected %d", pos, n, inflated)
+		}
+		pos += deflated
+	}
+	if out.Len() != int(entry.Size) {
+		return nil, fmt.Errorf("inflated to %d bytes, expected %d", out.Len(), entry.Size)
+	}
+	return out.Bytes(), nil
+}
+
+// Verify decompresses every entry and checks its name crc, returning the entries that fail
+func (a *Archive) Verify() []*EntryError {
+	problems := []*EntryError{}
+	for _, entry := range a.Entries {
+		if CRC(entry.Name) != entry.CRC {
+			problems = append(problems, &EntryError{Name: entry.Name, Err: fmt.Errorf("crc 0x%08x does not match name", entry.CRC)})
+			continue
+		}
+		_, err := a.read(entry)
+		if err != nil {
+			problems = append(problems, &EntryError{Name: entry.Name, Err: err})
+		}
+	}
+	return problems
+}
+
+// Diff compares a to reference, usually the vanilla copy of the same archive, and returns entries
+// that were replaced, added or removed
+func Diff(a *Archive, reference *Archive) ([]*EntryError, error) {
+	problems := []*EntryError{}
+	for _, entry := range a.Entries {
+		refEntry := reference.Entry(entry.Name)
+		if refEntry == nil {
+			problems = append(problems, &EntryError{Name: entry.Name, Err: ErrAdded})
+			continue
+		}
+		data, err := a.read(entry)
+		if err != nil {
+			problems = append(problems, &EntryError{Name: entry.Name, Err: err})
+			continue
+		}
+		refData, err := reference.read(refEntry)
+		if err != nil {
+			return nil, fmt.Errorf("read reference %s: %w", refEntry.Name, err)
+		}
+		if !bytes.Equal(data, refData) {
+			problems = append(problems, &EntryError{Name: entry.Name, Err: ErrReplaced})
+		}
+	}
+	for _, refEntry := range reference.Entries {
+		if a.Entry(refEntry.Name) == nil {
+			problems = append(problems, &EntryError{Name: refEntry.Name, Err: ErrMissing})
+		}
+	}
+	return problems, nil
+}
+
+// CRC returns the everquest crc of a file name
+func CRC(name string) uint32 {
+	crc := uint32(0)
+	for _, c := range []byte(strings.ToLower(name) + "\x00") {
+		crc = (crc << 8) ^ crcTable[byte(crc>>24)^c]
+	}
+	return crc
+}
+
+func makeCRCTable() [256]uint32 {
+	table := [256]uint32{}
+	for i := range table {
+		crc := uint32(i) << 24
+		for range 8 {
+			if crc&0x80000000 != 0 {
+				crc = crc<<1 ^ 0x04C11DB7
+			} else {
+				crc <<= 1
+			}
+		}
+		table[i] = crc
+	}
+	return table
+}
+
+// Write writes files as a PFS archive to w
+func Write(w io.Writer, files []File) error {
+	body := &bytes.Buffer{}
+	entries := []*Entry{}
+	offset := uint32(12)
+
+	writeEntry := func(crc uint32, data []byte) error {
+		entry := &Entry{CRC: crc, Offset: offset + uint32(body.Len()), Size: uint32(len(data))}
+		for start := 0; ; start += blockSize {
+			end := min(start+blockSize, len(data))
+			compressed := &bytes.Buffer{}
+			zw := zlib.NewWriter(compressed)
+			_, err := zw.Write(data[start:end])
+			if err != nil {
+				return err
+			}
+			err = zw.Close()
+			if err != nil {
+				return err
+			}
+			binary.Write(body, binary.LittleEndian, uint32(compressed.Len()))
+			binary.Write(body, binary.LittleEndian, uint32(end-start))
+			body.Write(compressed.Bytes())
+			if end == len(data) {
+				break
+			}
+		}
+		entries = append(entries, entry)
+		return nil
+	}
+
+	names := &bytes.Buffer{}
+	binary.Write(names, binary.LittleEndian, uint32(len(files)))
+	for _, file := range files {
+		err := writeEntry(CRC(file.Name), file.Data)
+		if err != nil {
+			return fmt.Errorf("compress %s: %w", file.Name, err)
+		}
+		binary.Write(names, binary.LittleEndian, uint32(len(file.Name)+1))
+		names.WriteString(file.Name)
+		names.WriteByte(0)
+	}
+	err := writeEntry(directoryCRC, names.Bytes())
+	if err != nil {
+		return fmt.Errorf("compress file names: %w", err)
+	}
+
+	header := &bytes.Buffer{}
+	binary.Write(header, binary.LittleEndian, offset+uint32(body.Len()))
+	binary.Write(header, binary.LittleEndian, uint32(magic))
+	binary.Write(header, binary.LittleEndian, uint32(version))
+
+	// everquest sorts the directory by crc
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].CRC < entries[j].CRC
+	})
+	directory := &bytes.Buffer{}
+	binary.Write(directory, binary.LittleEndian, uint32(len(entries)))
+	for _, entry := range entries {
+		binary.Write(directory, binary.LittleEndian, entry.CRC)
+		binary.Write(directory, binary.LittleEndian, entry.Offset)
+		binary.Write(directory, binary.LittleEndian, entry.Size)
+	}
+
+	for _, buf := range []*bytes.Buffer{header, body, directory} {
+		_, err = w.Write(buf.Bytes())
+		if err != nil {
+			return fmt.Errorf("write: %w", err)
+		}
+	}
+	return nil
+}
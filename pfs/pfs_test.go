@@ -0,0 +1,161 @@
+package pfs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func testArchive(t *testing.T, files []File) []byte {
+	t.Helper()
+	buf := &bytes.Buffer{}
+	err := Write(buf, files)
+	if err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestRoundTrip(t *testing.T) {
+	large := bytes.Repeat([]byte("texture "), 5000)
+	files := []File{
+		{Name: "zone.wld", Data: []byte("world data")},
+		{Name: "Brick.dds", Data: large},
+		{Name: "empty.txt", Data: nil},
+	}
+	data := testArchive(t, files)
+
+	a, err := Parse(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if len(a.Entries) != len(files) {
+		t.Fatalf("expected %d entries, got %d", len(files), len(a.Entries))
+	}
+	for _, file := range files {
+		entry := a.Entry(strings.ToUpper(file.Name))
+		if entry == nil {
+			t.Fatalf("%s: not found", file.Name)
+		}
+		content, err := a.ReadEntry(entry)
+		if err != nil {
+			t.Fatalf("%s: read: %v", file.Name, err)
+		}
+		if !bytes.Equal(content, file.Data) {
+			t.Fatalf("%s: content differs", file.Name)
+		}
+	}
+	problems := a.Verify()
+	if len(problems) != 0 {
+		t.Fatalf("unexpected problems: %v", problems)
+	}
+}
+
+func TestCRC(t *testing.T) {
+	if CRC("Brick.DDS") != CRC("brick.dds") {
+		t.Fatalf("crc should ignore case")
+	}
+	if CRC("a.dds") == CRC("b.dds") {
+		t.Fatalf("crc should differ by name")
+	}
+}
+
+func TestVerifyCorrupt(t *testing.T) {
+	data := testArchive(t, []File{
+		{Name: "good.dds", Data: bytes.Repeat([]byte("good"), 100)},
+		{Name: "bad.dds", Data: bytes.Repeat([]byte("bad"), 100)},
+	})
+
+	a, err := Parse(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	bad := a.Entry("bad.dds")
+	// flip a byte inside the compressed block of bad.dds
+	data[bad.Offset+8+4] ^= 0xff
+
+	a, err = Parse(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	problems := a.Verify()
+	if len(problems) != 1 || problems[0].Name != "bad.dds" {
+		t.Fatalf("expected bad.dds to fail, got %v", problems)
+	}
+}
+
+func TestVerifyCRCMismatch(t *testing.T) {
+	data := testArchive(t, []File{{Name: "a.dds", Data: []byte("a")}})
+	dirOffset := binary.LittleEndian.Uint32(data)
+	// the directory is sorted by crc, find the entry that is not the name directory
+	for i := uint32(0); i < 2; i++ {
+		pos := dirOffset + 4 + i*12
+		if binary.LittleEndian.Uint32(data[pos:]) == CRC("a.dds") {
+			binary.LittleEndian.PutUint32(data[pos:], 12345)
+		}
+	}
+
+	a, err := Parse(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	problems := a.Verify()
+	if len(problems) != 1 || !strings.Contains(problems[0].Error(), "crc") {
+		t.Fatalf("expected crc problem, got %v", problems)
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	data := testArchive(t, []File{{Name: "a.dds", Data: []byte("a")}})
+
+	_, err := Parse(bytes.NewReader(data[:8]), 8)
+	if err == nil {
+		t.Fatalf("expected truncated header error")
+	}
+	notPFS := append([]byte{}, data...)
+	copy(notPFS[4:], "ZIP!")
+	_, err = Parse(bytes.NewReader(notPFS), int64(len(notPFS)))
+	if err == nil {
+		t.Fatalf("expected magic error")
+	}
+	truncated := data[:len(data)-4]
+	_, err = Parse(bytes.NewReader(truncated), int64(len(truncated)))
+	if err == nil {
+		t.Fatalf("expected truncated directory error")
+	}
+}
+
+func TestDiff(t *testing.T) {
+	refData := testArchive(t, []File{
+		{Name: "same.dds", Data: []byte("same")},
+		{Name: "changed.dds", Data: []byte("vanilla")},
+		{Name: "removed.dds", Data: []byte("gone")},
+	})
+	modData := testArchive(t, []File{
+		{Name: "same.dds", Data: []byte("same")},
+		{Name: "changed.dds", Data: []byte("custom")},
+		{Name: "added.dds", Data: []byte("new")},
+	})
+	ref, err := Parse(bytes.NewReader(refData), int64(len(refData)))
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	mod, err := Parse(bytes.NewReader(modData), int64(len(modData)))
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+
+	problems, err := Diff(mod, ref)
+	if err != nil {
+		t.Fatalf("diff: %v", err)
+	}
+	got := map[string]error{}
+	for _, problem := range problems {
+		got[problem.Name] = problem.Err
+	}
+	if len(got) != 3 || !errors.Is(got["changed.dds"], ErrReplaced) || !errors.Is(got["added.dds"], ErrAdded) || !errors.Is(got["removed.dds"], ErrMissing) {
+		t.Fatalf("unexpected diff: %v", problems)
+	}
+}
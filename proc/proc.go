@@ -0,0 +1,101 @@
+// proc finds a running everquest client so its directory isn't changed underneath it
+package proc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// GameName is the everquest client executable
+const GameName = "eqgame.exe"
+
+// ErrRunning is returned when everquest is running from a directory about to be changed
+var ErrRunning = errors.New("everquest is running")
+
+// Process is a running process
+type Process struct {
+	PID  int
+	Name string
+	// Dir is the working directory of the process on Linux and its executable's directory on
+	// Windows, where eqgame.exe is always started from its own folder. It is empty if it can't be read
+	Dir string
+}
+
+// list returns every running process, replaced in tests
+var list = listProcesses
+
+// pollInterval is how often WaitForExit looks for the game
+var pollInterval = time.Second
+
+// Find returns the eqgame.exe processes running from dir
+func Find(dir string) ([]Process, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, fmt.Errorf("abs %s: %w", dir, err)
+	}
+	real, err := filepath.EvalSymlinks(dir)
+	if err == nil {
+		dir = real
+	}
+
+	processes, err := list()
+	if err != nil {
+		return nil, fmt.Errorf("list processes: %w", err)
+	}
+	found := []Process{}
+	for _, p := range processes {
+		if !strings.EqualFold(p.Name, GameName) || p.Dir == "" {
+			continue
+		}
+		if !samePath(p.Dir, dir) {
+			continue
+		}
+		found = append(found, p)
+	}
+	return found, nil
+}
+
+// WaitForExit returns nil once no eqgame.exe runs from dir. It waits up to timeout, telling the
+// player to close the game, then returns ErrRunning. A zero timeout refuses right away
+func WaitForExit(ctx context.Context, dir string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	isTold := false
+	for {
+		found, err := Find(dir)
+		if err != nil {
+			return err
+		}
+		if len(found) == 0 {
+			return nil
+		}
+		if !time.Now().Before(deadline) {
+			return fmt.Errorf("%s from %s (pid %d), close it and try again: %w", GameName, dir, found[0].PID, ErrRunning)
+		}
+		if !isTold {
+			slog.Info("everquest is running", "dir", dir, "pid", found[0].PID, "timeout", timeout.String())
+			fmt.Printf("EverQuest is running from %s (pid %d), waiting up to %s for it to close...\n", dir, found[0].PID, timeout)
+			isTold = true
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(min(pollInterval, time.Until(deadline))):
+		}
+	}
+}
+
+func samePath(a string, b string) bool {
+	a = filepath.Clean(a)
+	b = filepath.Clean(b)
+	if runtime.GOOS == "windows" {
+		return strings.EqualFold(a, b)
+	}
+	return a == b
+}
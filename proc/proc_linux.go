@@ -0,0 +1,55 @@
+package proc
+
+import (
+	"bytes"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+func listProcesses() ([]Process, error) {
+	return listProcDir("/proc")
+}
+
+// listProcDir reads processes from a /proc layout under root. Wine names an eqgame.exe process
+// after the executable and starts it in the directory it was launched from
+func listProcDir(root string) ([]Process, error) {
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		return nil, err
+	}
+
+	processes := []Process{}
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil || !entry.IsDir() {
+			continue
+		}
+		p := Process{PID: pid}
+		comm, err := os.ReadFile(filepath.Join(root, entry.Name(), "comm"))
+		if err != nil {
+			// the process exited while listing
+			continue
+		}
+		p.Name = strings.TrimSpace(string(comm))
+
+		// comm is cut to 15 characters, the command line has the full executable name
+		cmdline, err := os.ReadFile(filepath.Join(root, entry.Name(), "cmdline"))
+		if err == nil {
+			arg0, _, _ := bytes.Cut(cmdline, []byte{0})
+			name := path.Base(strings.ReplaceAll(string(arg0), `\`, "/"))
+			if strings.EqualFold(name, GameName) {
+				p.Name = name
+			}
+		}
+
+		dir, err := os.Readlink(filepath.Join(root, entry.Name(), "cwd"))
+		if err == nil {
+			p.Dir = dir
+		}
+		processes = append(processes, p)
+	}
+	return processes, nil
+}
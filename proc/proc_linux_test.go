@@ -0,0 +1,75 @@
+package proc
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestListProcDir(t *testing.T) {
+	root := t.TempDir()
+	gameDir := t.TempDir()
+	fake := map[string]map[string]string{
+		"100": {"comm": "bash\n", "cmdline": "/bin/bash\x00-l\x00"},
+		// wine shows the windows path of the executable
+		"200": {"comm": "eqgame.exe\n", "cmdline": `C:\EverQuest\eqgame.exe` + "\x00patchme\x00"},
+		"300": {"comm": "wine64-preload\n", "cmdline": "/opt/wine/eqgame.exe\x00"},
+	}
+	for pid, files := range fake {
+		err := os.MkdirAll(filepath.Join(root, pid), 0755)
+		if err != nil {
+			t.Fatalf("mkdir: %v", err)
+		}
+		for name, content := range files {
+			err = os.WriteFile(filepath.Join(root, pid, name), []byte(content), 0644)
+			if err != nil {
+				t.Fatalf("write: %v", err)
+			}
+		}
+		err = os.Symlink(gameDir, filepath.Join(root, pid, "cwd"))
+		if err != nil {
+			t.Fatalf("symlink: %v", err)
+		}
+	}
+	err := os.MkdirAll(filepath.Join(root, "self"), 0755)
+	if err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+
+	processes, err := listProcDir(root)
+	if err != nil {
+		t.Fatalf("list: %v", err)
+	}
+	names := map[int]string{}
+	for _, p := range processes {
+		names[p.PID] = p.Name
+		if p.Dir != gameDir {
+			t.Fatalf("pid %d: dir %q want %q", p.PID, p.Dir, gameDir)
+		}
+	}
+	if len(processes) != 3 || names[100] != "bash" || names[200] != GameName || names[300] != GameName {
+		t.Fatalf("unexpected processes: %+v", processes)
+	}
+
+	fakeList(t, func() ([]Process, error) { return listProcDir(root) })
+	found, err := Find(gameDir)
+	if err != nil {
+		t.Fatalf("find: %v", err)
+	}
+	if len(found) != 2 {
+		t.Fatalf("expected both eqgame.exe processes, got %+v", found)
+	}
+}
+
+func TestListProcesses(t *testing.T) {
+	processes, err := listProcesses()
+	if err != nil {
+		t.Fatalf("list: %v", err)
+	}
+	for _, p := range processes {
+		if p.PID == os.Getpid() {
+			return
+		}
+	}
+	t.Fatalf("this process is not listed")
+}
@@ -0,0 +1,74 @@
+package proc
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func fakeList(t *testing.T, fn func() ([]Process, error)) {
+	t.Helper()
+	list = fn
+	pollInterval = time.Millisecond
+	t.Cleanup(func() {
+		list = listProcesses
+		pollInterval = time.Second
+	})
+}
+
+func TestFind(t *testing.T) {
+	dir := t.TempDir()
+	other := t.TempDir()
+	fakeList(t, func() ([]Process, error) {
+		return []Process{
+			{PID: 1, Name: "init", Dir: dir},
+			{PID: 2, Name: "eqgame.exe", Dir: other},
+			{PID: 3, Name: "EQGame.exe", Dir: dir + string(filepath.Separator)},
+			{PID: 4, Name: "eqgame.exe"},
+		}, nil
+	})
+
+	found, err := Find(dir)
+	if err != nil {
+		t.Fatalf("find: %v", err)
+	}
+	if len(found) != 1 || found[0].PID != 3 {
+		t.Fatalf("unexpected processes: %+v", found)
+	}
+
+	err = WaitForExit(context.Background(), dir, 0)
+	if !errors.Is(err, ErrRunning) {
+		t.Fatalf("expected running, got %v", err)
+	}
+}
+
+func TestWaitForExit(t *testing.T) {
+	dir := t.TempDir()
+	calls := atomic.Int32{}
+	fakeList(t, func() ([]Process, error) {
+		// the game closes after a few polls
+		if calls.Add(1) > 3 {
+			return nil, nil
+		}
+		return []Process{{PID: 7, Name: GameName, Dir: dir}}, nil
+	})
+
+	err := WaitForExit(context.Background(), dir, time.Minute)
+	if err != nil {
+		t.Fatalf("wait: %v", err)
+	}
+	if calls.Load() != 4 {
+		t.Fatalf("expected 4 polls, got %d", calls.Load())
+	}
+
+	calls.Store(-1000)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = WaitForExit(ctx, dir, time.Minute)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected cancel, got %v", err)
+	}
+}
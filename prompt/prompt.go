@@ -0,0 +1,66 @@
+// prompt reads answers from the player on stdin without blocking interrupts
+package prompt
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+type promptLine struct {
+	text string
+	err  error
+}
+
+var (
+	stdinOnce sync.Once
+	// stdinLines is fed by a single reader so a prompt abandoned on interrupt loses no input
+	stdinLines chan promptLine
+)
+
+// ReadLine waits for the player to enter a line and returns it trimmed. It returns ctx's error
+// as soon as ctx is done, so Ctrl-C is not stuck behind a prompt
+func ReadLine(ctx context.Context) (string, error) {
+	stdinOnce.Do(func() {
+		stdinLines = make(chan promptLine)
+		go func() {
+			r := bufio.NewReader(os.Stdin)
+			for {
+				text, err := r.ReadString('\n')
+				if text != "" {
+					stdinLines <- promptLine{text: text}
+				}
+				if err != nil {
+					for {
+						stdinLines <- promptLine{err: err}
+					}
+				}
+			}
+		}()
+	})
+
+	select {
+	case <-ctx.Done():
+		return "", ctx.Err()
+	case line := <-stdinLines:
+		return strings.TrimSpace(line.text), line.err
+	}
+}
+
+// Confirm asks question and returns true if the player answers yes. No input counts as no
+func Confirm(ctx context.Context, question string) (bool, error) {
+	fmt.Printf("%s [y/N]: ", question)
+	answer, err := ReadLine(ctx)
+	if err != nil {
+		if err == io.EOF {
+			return false, nil
+		}
+		return false, err
+	}
+	answer = strings.ToLower(answer)
+	return answer == "y" || answer == "yes", nil
+}
@@ -0,0 +1,345 @@
+// publish builds a rof2plus_filelist.yml from a server's custom asset directory
+package publish
+
+import (
+	"archive/zip"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/xackery/rof2plus/checksum"
+	"gopkg.in/yaml.v3"
+)
+
+// FileListName is the file list players download
+const FileListName = "rof2plus_filelist.yml"
+
+// dateFormat is how file dates are written in the file list
+const dateFormat = "20060102"
+
+// Options configures a publish
+type Options struct {
+	// Dir is the server's asset directory, laid out like an everquest folder
+	Dir string
+	// OutDir receives the file list and unpack zips, defaults to Dir
+	OutDir string
+	// DownloadPrefix is the url players download files from
+	DownloadPrefix string
+	// Version identifies this file list, defaults to the current time
+	Version string
+	// Unpacks are directories, relative to Dir, bundled into a zip each instead of downloaded file by file
+	Unpacks []string
+	// Manifest is the vanilla client files that don't need to be published, defaults to rof2
+	Manifest map[string]*checksum.ChecksumEntry
+}
+
+// Result summarizes a publish
+type Result struct {
+	FileList *checksum.FileList
+	// Path is where the file list was written
+	Path string
+	// Vanilla is how many files matched the vanilla manifest and were left out
+	Vanilla int
+}
+
+type localFile struct {
+	name string
+	path string
+	md5  string
+	size int64
+	date time.Time
+}
+
+// Publish diffs opts.Dir against the vanilla manifest and writes a file list of every new or changed file.
+// Files listed by a previous file list in OutDir that no longer exist become deletes
+func Publish(ctx context.Context, opts Options) (*Result, error) {
+	if opts.Dir == "" {
+		return nil, fmt.Errorf("dir is empty")
+	}
+	if opts.DownloadPrefix == "" {
+		return nil, fmt.Errorf("download prefix is empty")
+	}
+	outDir := opts.OutDir
+	if outDir == "" {
+		outDir = opts.Dir
+	}
+	version := opts.Version
+	if version == "" {
+		version = time.Now().UTC().Format("20060102150405")
+	}
+	manifest := opts.Manifest
+	if manifest == nil {
+		var err error
+		manifest, err = checksum.Manifest(checksum.ClientRoF2)
+		if err != nil {
+			return nil, fmt.Errorf("manifest: %w", err)
+		}
+	}
+
+	outPath := filepath.Join(outDir, FileListName)
+	previous, err := readFileList(outPath)
+	if err != nil {
+		return nil, err
+	}
+
+	unpacks := map[string]bool{}
+	generated := map[string]bool{FileListName: true}
+	for _, unpack := range opts.Unpacks {
+		unpack = cleanName(unpack)
+		if unpack == "" || unpack == "." || strings.HasPrefix(unpack, "../") {
+			return nil, fmt.Errorf("invalid unpack %q", unpack)
+		}
+		unpacks[unpack] = true
+		generated[zipName(unpack)] = true
+	}
+
+	files, err := scan(ctx, opts.Dir, generated)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &Result{
+		Path: outPath,
+		FileList: &checksum.FileList{
+			Version:        version,
+			DownloadPrefix: opts.DownloadPrefix,
+			Deletes:        []checksum.FileEntry{},
+			Downloads:      []checksum.FileEntry{},
+			Unpacks:        []checksum.FileEntry{},
+		},
+	}
+
+	// asset directories are often cased differently than the client, which windows ignores
+	vanilla := map[string]*checksum.ChecksumEntry{}
+	for name, entry := range manifest {
+		vanilla[strings.ToLower(name)] = entry
+	}
+
+	present := map[string]bool{}
+	bundles := map[string][]*localFile{}
+	for _, file := range files {
+		present[strings.ToLower(file.name)] = true
+		entry, ok := vanilla[strings.ToLower(file.name)]
+		if ok && strings.EqualFold(entry.MD5Hash, file.md5) {
+			result.Vanilla++
+			continue
+		}
+
+		unpack := unpackOf(file.name, unpacks)
+		if unpack != "" {
+			bundles[unpack] = append(bundles[unpack], file)
+			continue
+		}
+
+		result.FileList.Downloads = append(result.FileList.Downloads, checksum.FileEntry{
+			Name: file.name,
+			Md5:  file.md5,
+			Date: file.date.Format(dateFormat),
+			Size: int(file.size),
+		})
+	}
+
+	if previous != nil {
+		for _, entry := range append(previous.Downloads, previous.Deletes...) {
+			if present[strings.ToLower(entry.Name)] {
+				continue
+			}
+			_, isVanilla := vanilla[strings.ToLower(entry.Name)]
+			if isVanilla {
+				// a vanilla file reverted by removing the override must be restored, not deleted
+				continue
+			}
+			result.FileList.Deletes = append(result.FileList.Deletes, checksum.FileEntry{Name: entry.Name})
+		}
+	}
+
+	err = os.MkdirAll(outDir, 0755)
+	if err != nil {
+		return nil, fmt.Errorf("mkdir: %w", err)
+	}
+
+	unpackNames := []string{}
+	for unpack := range bundles {
+		unpackNames = append(unpackNames, unpack)
+	}
+	sort.Strings(unpackNames)
+	for _, unpack := range unpackNames {
+		entry, err := writeZip(filepath.Join(outDir, zipName(unpack)), unpack, bundles[unpack])
+		if err != nil {
+			return nil, fmt.Errorf("zip %s: %w", unpack, err)
+		}
+		result.FileList.Unpacks = append(result.FileList.Unpacks, *entry)
+	}
+
+	sortEntries(result.FileList.Downloads)
+	sortEntries(result.FileList.Deletes)
+
+	data, err := yaml.Marshal(result.FileList)
+	if err != nil {
+		return nil, fmt.Errorf("encode file list: %w", err)
+	}
+	err = os.WriteFile(outPath+".tmp", data, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("write file list: %w", err)
+	}
+	err = os.Rename(outPath+".tmp", outPath)
+	if err != nil {
+		return nil, fmt.Errorf("write file list: %w", err)
+	}
+
+	return result, nil
+}
+
+func readFileList(path string) (*checksum.FileList, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("read previous file list: %w", err)
+	}
+	fileList := &checksum.FileList{}
+	err = yaml.Unmarshal(data, fileList)
+	if err != nil {
+		return nil, fmt.Errorf("decode previous file list: %w", err)
+	}
+	return fileList, nil
+}
+
+// scan hashes every file in dir except the generated names in its root
+func scan(ctx context.Context, dir string, generated map[string]bool) ([]*localFile, error) {
+	files := []*localFile{}
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if d.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		name := cleanName(rel)
+		if generated[name] || strings.HasSuffix(name, ".tmp") {
+			return nil
+		}
+
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+		hash, err := checksum.MD5Generate(path)
+		if err != nil {
+			return fmt.Errorf("md5 %s: %w", name, err)
+		}
+		files = append(files, &localFile{
+			name: name,
+			path: path,
+			md5:  hash,
+			size: fi.Size(),
+			date: fi.ModTime(),
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("scan %s: %w", dir, err)
+	}
+	return files, nil
+}
+
+// writeZip bundles files of the unpack directory into path
+func writeZip(path string, unpack string, files []*localFile) (*checksum.FileEntry, error) {
+	w, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("create: %w", err)
+	}
+	defer w.Close()
+
+	h := md5.New()
+	zw := zip.NewWriter(io.MultiWriter(w, h))
+	latest := time.Time{}
+	for _, file := range files {
+		if file.date.After(latest) {
+			latest = file.date
+		}
+		err = addZipFile(zw, strings.TrimPrefix(file.name, unpack+"/"), file)
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = zw.Close()
+	if err != nil {
+		return nil, fmt.Errorf("close zip: %w", err)
+	}
+
+	fi, err := w.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("stat: %w", err)
+	}
+	return &checksum.FileEntry{
+		Name: zipName(unpack),
+		Md5:  hex.EncodeToString(h.Sum(nil)),
+		Date: latest.Format(dateFormat),
+		Zip:  unpack,
+		Size: int(fi.Size()),
+	}, nil
+}
+
+func addZipFile(zw *zip.Writer, name string, file *localFile) error {
+	r, err := os.Open(file.path)
+	if err != nil {
+		return fmt.Errorf("open %s: %w", file.name, err)
+	}
+	defer r.Close()
+
+	fw, err := zw.CreateHeader(&zip.FileHeader{
+		Name:     name,
+		Method:   zip.Deflate,
+		Modified: file.date,
+	})
+	if err != nil {
+		return fmt.Errorf("add %s: %w", file.name, err)
+	}
+	_, err = io.Copy(fw, r)
+	if err != nil {
+		return fmt.Errorf("compress %s: %w", file.name, err)
+	}
+	return nil
+}
+
+// unpackOf returns the unpack directory name belongs to, if any
+func unpackOf(name string, unpacks map[string]bool) string {
+	for unpack := range unpacks {
+		if strings.HasPrefix(strings.ToLower(name), strings.ToLower(unpack)+"/") {
+			return unpack
+		}
+	}
+	return ""
+}
+
+func zipName(unpack string) string {
+	return strings.ReplaceAll(unpack, "/", "_") + ".zip"
+}
+
+// cleanName returns a file list name, which always uses forward slashes
+func cleanName(name string) string {
+	return strings.Trim(filepath.ToSlash(filepath.Clean(name)), "/")
+}
+
+func sortEntries(entries []checksum.FileEntry) {
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name < entries[j].Name
+	})
+}
@@ -0,0 +1,156 @@
+package publish
+
+import (
+	"archive/zip"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/xackery/rof2plus/checksum"
+	"github.com/xackery/rof2plus/internal/testfiles"
+	"gopkg.in/yaml.v3"
+)
+
+func TestPublish(t *testing.T) {
+	dir := t.TempDir()
+	manifest := map[string]*checksum.ChecksumEntry{
+		"eqgame.exe":    {MD5Hash: testfiles.MD5("vanilla game"), FileSize: 12},
+		"spells_us.txt": {MD5Hash: testfiles.MD5("vanilla spells"), FileSize: 14},
+	}
+	testfiles.Write(t, dir, map[string]string{
+		"eqgame.exe":        "vanilla game",
+		"spells_us.txt":     "custom spells",
+		"custom.eqg":        "new zone",
+		"maps/qeynos.txt":   "map one",
+		"maps/freeport.txt": "map two",
+	})
+
+	opts := Options{
+		Dir:            dir,
+		DownloadPrefix: "https://example.com/rof",
+		Version:        "1",
+		Unpacks:        []string{"maps"},
+		Manifest:       manifest,
+	}
+	result, err := Publish(context.Background(), opts)
+	if err != nil {
+		t.Fatalf("publish: %v", err)
+	}
+	fileList := result.FileList
+	if result.Vanilla != 1 {
+		t.Fatalf("expected eqgame.exe to be vanilla, got %d", result.Vanilla)
+	}
+	if len(fileList.Downloads) != 2 || fileList.Downloads[0].Name != "custom.eqg" || fileList.Downloads[1].Name != "spells_us.txt" {
+		t.Fatalf("unexpected downloads: %+v", fileList.Downloads)
+	}
+	if fileList.Downloads[1].Md5 != testfiles.MD5("custom spells") || fileList.Downloads[1].Size != 13 || fileList.Downloads[1].Date == "" {
+		t.Fatalf("unexpected entry: %+v", fileList.Downloads[1])
+	}
+	if len(fileList.Unpacks) != 1 || fileList.Unpacks[0].Name != "maps.zip" || fileList.Unpacks[0].Zip != "maps" {
+		t.Fatalf("unexpected unpacks: %+v", fileList.Unpacks)
+	}
+
+	zr, err := zip.OpenReader(filepath.Join(dir, "maps.zip"))
+	if err != nil {
+		t.Fatalf("open zip: %v", err)
+	}
+	if len(zr.File) != 2 {
+		t.Fatalf("expected 2 zipped maps, got %d", len(zr.File))
+	}
+	zr.Close()
+
+	data, err := os.ReadFile(result.Path)
+	if err != nil {
+		t.Fatalf("read file list: %v", err)
+	}
+	written := &checksum.FileList{}
+	err = yaml.Unmarshal(data, written)
+	if err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if written.DownloadPrefix != opts.DownloadPrefix || written.Version != "1" || len(written.Downloads) != 2 {
+		t.Fatalf("unexpected written list: %+v", written)
+	}
+
+	// removing a custom file deletes it, removing an override of a vanilla file restores it
+	err = os.Remove(filepath.Join(dir, "custom.eqg"))
+	if err != nil {
+		t.Fatalf("remove: %v", err)
+	}
+	err = os.Remove(filepath.Join(dir, "spells_us.txt"))
+	if err != nil {
+		t.Fatalf("remove: %v", err)
+	}
+	opts.Version = "2"
+	result, err = Publish(context.Background(), opts)
+	if err != nil {
+		t.Fatalf("republish: %v", err)
+	}
+	if len(result.FileList.Downloads) != 0 {
+		t.Fatalf("unexpected downloads: %+v", result.FileList.Downloads)
+	}
+	if len(result.FileList.Deletes) != 1 || result.FileList.Deletes[0].Name != "custom.eqg" {
+		t.Fatalf("unexpected deletes: %+v", result.FileList.Deletes)
+	}
+
+	// deletes carry over until the file comes back
+	opts.Version = "3"
+	result, err = Publish(context.Background(), opts)
+	if err != nil {
+		t.Fatalf("republish: %v", err)
+	}
+	if len(result.FileList.Deletes) != 1 {
+		t.Fatalf("expected delete to carry over: %+v", result.FileList.Deletes)
+	}
+}
+
+func TestPublishCase(t *testing.T) {
+	dir := t.TempDir()
+	manifest := map[string]*checksum.ChecksumEntry{
+		"Resources/dbstr_us.txt": {MD5Hash: testfiles.MD5("vanilla strings"), FileSize: 15},
+		"ActorEffects/spell.txt": {MD5Hash: testfiles.MD5("vanilla effect"), FileSize: 14},
+	}
+	testfiles.Write(t, dir, map[string]string{
+		"resources/DBStr_us.txt": "vanilla strings",
+		"actoreffects/spell.txt": "custom effect",
+	})
+
+	opts := Options{Dir: dir, DownloadPrefix: "https://example.com/rof", Version: "1", Manifest: manifest}
+	result, err := Publish(context.Background(), opts)
+	if err != nil {
+		t.Fatalf("publish: %v", err)
+	}
+	if result.Vanilla != 1 || len(result.FileList.Downloads) != 1 || result.FileList.Downloads[0].Name != "actoreffects/spell.txt" {
+		t.Fatalf("expected the differently cased vanilla file to be skipped, got %d vanilla, downloads %+v", result.Vanilla, result.FileList.Downloads)
+	}
+
+	// removing the override restores the vanilla file rather than deleting it
+	err = os.Remove(filepath.Join(dir, "actoreffects", "spell.txt"))
+	if err != nil {
+		t.Fatalf("remove: %v", err)
+	}
+	opts.Version = "2"
+	result, err = Publish(context.Background(), opts)
+	if err != nil {
+		t.Fatalf("republish: %v", err)
+	}
+	if len(result.FileList.Deletes) != 0 {
+		t.Fatalf("unexpected deletes: %+v", result.FileList.Deletes)
+	}
+}
+
+func TestPublishOptions(t *testing.T) {
+	_, err := Publish(context.Background(), Options{DownloadPrefix: "https://example.com"})
+	if err == nil {
+		t.Fatalf("expected missing dir error")
+	}
+	_, err = Publish(context.Background(), Options{Dir: t.TempDir()})
+	if err == nil {
+		t.Fatalf("expected missing prefix error")
+	}
+	_, err = Publish(context.Background(), Options{Dir: t.TempDir(), DownloadPrefix: "https://example.com", Unpacks: []string{"../outside"}})
+	if err == nil {
+		t.Fatalf("expected invalid unpack error")
+	}
+}
@@ -0,0 +1,512 @@
+// relocate moves client installs between folders, falling back to copy, verify and delete when a rename is not possible
+package relocate
+
+import (
+	"context"
+	"crypto/md5"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/xackery/rof2plus/checksum"
+	"github.com/xackery/rof2plus/diskspace"
+	"github.com/xackery/rof2plus/lock"
+	"github.com/xackery/rof2plus/proc"
+	"gopkg.in/yaml.v3"
+)
+
+// journalName is written to the destination while a copy is in progress so an interrupted move can resume
+const journalName = "rof2plus_relocate.yaml"
+
+// ErrMismatch is returned by Move when copied files differ from the manifest. The source is kept
+var ErrMismatch = errors.New("files differ from the manifest")
+
+// rename is swapped in tests to simulate moves across filesystems
+var rename = os.Rename
+
+// Result summarizes a relocation
+type Result struct {
+	// Renamed is true when the source was moved with a single rename
+	Renamed bool
+	Files   int
+	Bytes   int64
+	// Skipped is the number of files already copied by an earlier, interrupted run
+	Skipped int
+	// Mismatches are files whose content differs from the manifest. They are copied as-is, but Move
+	// keeps the source when there are any
+	Mismatches []string
+	// Warnings are disk space warnings for the caller to show
+	Warnings []string
+}
+
+type journal struct {
+	Source  string    `yaml:"source"`
+	Started time.Time `yaml:"started"`
+}
+
+type copyFile struct {
+	name string
+	size int64
+}
+
+// Move relocates src to dst. It renames when possible, otherwise copies every file with progress,
+// verifies each copy (and checks it against manifest when listed), then removes src.
+// If interrupted, calling Move again with the same arguments resumes the copy. src is only removed once every file is verified.
+// If any file differs from manifest, src is kept and ErrMismatch is returned; calling Move again with a nil
+// manifest accepts the copies as they are and finishes the move
+func Move(ctx context.Context, src string, dst string, manifest map[string]*checksum.ChecksumEntry, progress func(done int64, total int64)) (*Result, error) {
+	src, dst, err := absPaths(src, dst)
+	if err != nil {
+		return nil, err
+	}
+
+	unlock, err := lockDirs(src, dst)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	// the game running from either folder would break with files moved out from under it
+	for _, dir := range []string{src, dst} {
+		err = proc.WaitForExit(ctx, dir, 0)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	isResume, err := readJournal(dst, src)
+	if err != nil {
+		return nil, err
+	}
+
+	if !isResume {
+		_, err = os.Stat(dst)
+		if err == nil {
+			return nil, fmt.Errorf("destination %s already exists", dst)
+		}
+		if !os.IsNotExist(err) {
+			return nil, fmt.Errorf("stat destination: %w", err)
+		}
+
+		err = os.MkdirAll(filepath.Dir(dst), 0755)
+		if err != nil {
+			return nil, fmt.Errorf("mkdir: %w", err)
+		}
+
+		err = rename(src, dst)
+		if err == nil {
+			return &Result{Renamed: true}, nil
+		}
+		// most likely a different drive or filesystem, fall back to copying
+	}
+
+	if isResume {
+		_, err = os.Stat(src)
+		if os.IsNotExist(err) {
+			// the previous run finished copying and removed the source, only the journal is left
+			err = os.Remove(filepath.Join(dst, journalName))
+			if err != nil {
+				return nil, fmt.Errorf("remove journal: %w", err)
+			}
+			return &Result{}, nil
+		}
+	}
+
+	files, err := listFiles(src)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := copyFiles(ctx, src, dst, files, manifest, progress)
+	if err != nil {
+		return result, err
+	}
+	if len(result.Mismatches) > 0 {
+		return result, fmt.Errorf("%d %w, first %s, %s was kept", len(result.Mismatches), ErrMismatch, result.Mismatches[0], src)
+	}
+
+	err = os.RemoveAll(src)
+	if err != nil {
+		return result, fmt.Errorf("remove source: %w", err)
+	}
+
+	err = os.Remove(filepath.Join(dst, journalName))
+	if err != nil {
+		return result, fmt.Errorf("remove journal: %w", err)
+	}
+	return result, nil
+}
+
+// CopyManifest copies only the files listed in manifest from src to dst and verifies them, leaving src untouched.
+// Like Move, an interrupted copy resumes where it left off
+func CopyManifest(ctx context.Context, src string, dst string, manifest map[string]*checksum.ChecksumEntry, progress func(done int64, total int64)) (*Result, error) {
+	src, dst, err := absPaths(src, dst)
+	if err != nil {
+		return nil, err
+	}
+
+	unlock, err := lockDirs(src, dst)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	err = proc.WaitForExit(ctx, dst, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = readJournal(dst, src)
+	if err != nil {
+		return nil, err
+	}
+
+	files := []copyFile{}
+	for name := range manifest {
+		fi, err := os.Stat(filepath.Join(src, filepath.FromSlash(name)))
+		if err != nil {
+			return nil, fmt.Errorf("stat %s: %w", name, err)
+		}
+		files = append(files, copyFile{name: name, size: fi.Size()})
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].name < files[j].name })
+
+	result, err := copyFiles(ctx, src, dst, files, manifest, progress)
+	if err != nil {
+		return result, err
+	}
+
+	err = os.Remove(filepath.Join(dst, journalName))
+	if err != nil {
+		return result, fmt.Errorf("remove journal: %w", err)
+	}
+	return result, nil
+}
+
+// Source is a directory providing the files of a manifest
+type Source struct {
+	Dir      string
+	Manifest map[string]*checksum.ChecksumEntry
+}
+
+// Assemble copies every file listed by sources into dst, taking each file from the first source
+// that lists it, so sources are given highest priority first like checksum.Resolve
+func Assemble(ctx context.Context, dst string, sources []Source, progress func(done int64, total int64)) (*Result, error) {
+	// an interrupted assemble resumes at the source its journal names, earlier sources finished
+	resume := ""
+	data, err := os.ReadFile(filepath.Join(dst, journalName))
+	if err == nil {
+		j := &journal{}
+		err = yaml.Unmarshal(data, j)
+		if err != nil {
+			return nil, fmt.Errorf("decode journal: %w", err)
+		}
+		resume = j.Source
+	}
+
+	total := &Result{}
+	taken := map[string]bool{}
+	for _, source := range sources {
+		manifest := map[string]*checksum.ChecksumEntry{}
+		for name, entry := range source.Manifest {
+			if taken[name] {
+				continue
+			}
+			taken[name] = true
+			manifest[name] = entry
+		}
+		if resume != "" {
+			src, _, err := absPaths(source.Dir, dst)
+			if err != nil {
+				return total, err
+			}
+			if src != resume {
+				continue
+			}
+			resume = ""
+		}
+
+		result, err := CopyManifest(ctx, source.Dir, dst, manifest, progress)
+		if result != nil {
+			total.Files += result.Files
+			total.Bytes += result.Bytes
+			total.Skipped += result.Skipped
+			total.Mismatches = append(total.Mismatches, result.Mismatches...)
+			total.Warnings = append(total.Warnings, result.Warnings...)
+		}
+		if err != nil {
+			return total, fmt.Errorf("copy from %s: %w", source.Dir, err)
+		}
+	}
+	return total, nil
+}
+
+// lockDirs locks src and dst, so neither install is patched, checked or copied by another rof2plus meanwhile
+func lockDirs(src string, dst string) (func(), error) {
+	srcLock, err := lock.Acquire(src)
+	if err != nil {
+		return nil, err
+	}
+	dstLock, err := lock.Acquire(dst)
+	if err != nil {
+		srcLock.Release()
+		return nil, err
+	}
+	return func() {
+		dstLock.Release()
+		srcLock.Release()
+	}, nil
+}
+
+func absPaths(src string, dst string) (string, string, error) {
+	src, err := filepath.Abs(src)
+	if err != nil {
+		return "", "", fmt.Errorf("abs source: %w", err)
+	}
+	dst, err = filepath.Abs(dst)
+	if err != nil {
+		return "", "", fmt.Errorf("abs destination: %w", err)
+	}
+	if src == dst {
+		return "", "", fmt.Errorf("source and destination are the same")
+	}
+	return src, dst, nil
+}
+
+// readJournal returns true if dst holds an unfinished copy from src
+func readJournal(dst string, src string) (bool, error) {
+	data, err := os.ReadFile(filepath.Join(dst, journalName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, fmt.Errorf("read journal: %w", err)
+	}
+
+	j := &journal{}
+	err = yaml.Unmarshal(data, j)
+	if err != nil {
+		return false, fmt.Errorf("decode journal: %w", err)
+	}
+	if j.Source != src {
+		return false, fmt.Errorf("destination %s has an unfinished copy from %s", dst, j.Source)
+	}
+	return true, nil
+}
+
+func writeJournal(dst string, src string) error {
+	path := filepath.Join(dst, journalName)
+	_, err := os.Stat(path)
+	if err == nil {
+		return nil
+	}
+
+	data, err := yaml.Marshal(&journal{Source: src, Started: time.Now()})
+	if err != nil {
+		return fmt.Errorf("encode journal: %w", err)
+	}
+	err = os.WriteFile(path, data, 0644)
+	if err != nil {
+		return fmt.Errorf("write journal: %w", err)
+	}
+	return nil
+}
+
+// listFiles returns every file under root with slash separated relative names
+func listFiles(root string) ([]copyFile, error) {
+	files := []copyFile{}
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		files = append(files, copyFile{name: filepath.ToSlash(rel), size: fi.Size()})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("walk %s: %w", root, err)
+	}
+	return files, nil
+}
+
+func copyFiles(ctx context.Context, src string, dst string, files []copyFile, manifest map[string]*checksum.ChecksumEntry, progress func(done int64, total int64)) (*Result, error) {
+	result := &Result{}
+
+	// files a previous run finished don't need room again
+	remaining := int64(0)
+	for _, file := range files {
+		fi, err := os.Stat(filepath.Join(dst, filepath.FromSlash(file.name)))
+		if err == nil && fi.Size() == file.size {
+			continue
+		}
+		remaining += file.size
+	}
+	space, err := diskspace.Check(diskspace.Need{Path: dst, Reason: "copies", Bytes: remaining})
+	if err != nil {
+		return result, fmt.Errorf("preflight: %w", err)
+	}
+	result.Warnings = space.Warnings()
+
+	err = os.MkdirAll(dst, 0755)
+	if err != nil {
+		return result, fmt.Errorf("mkdir: %w", err)
+	}
+	err = writeJournal(dst, src)
+	if err != nil {
+		return result, err
+	}
+
+	total := int64(0)
+	for _, file := range files {
+		total += file.size
+	}
+	done := int64(0)
+
+	for _, file := range files {
+		select {
+		case <-ctx.Done():
+			return result, ctx.Err()
+		default:
+		}
+
+		srcPath := filepath.Join(src, filepath.FromSlash(file.name))
+		dstPath := filepath.Join(dst, filepath.FromSlash(file.name))
+
+		// files only get their final name after verification, so a matching size means a previous run finished it
+		fi, err := os.Stat(dstPath)
+		if err == nil && fi.Size() == file.size {
+			entry, ok := manifest[file.name]
+			if ok && entry.MD5Hash != "" {
+				hash, err := checksum.MD5Generate(dstPath)
+				if err != nil {
+					return result, fmt.Errorf("md5 %s: %w", file.name, err)
+				}
+				if !strings.EqualFold(hash, entry.MD5Hash) {
+					result.Mismatches = append(result.Mismatches, file.name)
+				}
+			}
+			result.Skipped++
+			done += file.size
+			if progress != nil {
+				progress(done, total)
+			}
+			continue
+		}
+
+		hash, err := copyVerified(ctx, srcPath, dstPath, file.size, func(n int64) {
+			if progress != nil {
+				progress(done+n, total)
+			}
+		})
+		if err != nil {
+			return result, fmt.Errorf("copy %s: %w", file.name, err)
+		}
+		entry, ok := manifest[file.name]
+		if ok && entry.MD5Hash != "" && !strings.EqualFold(entry.MD5Hash, hash) {
+			result.Mismatches = append(result.Mismatches, file.name)
+		}
+
+		done += file.size
+		result.Files++
+		result.Bytes += file.size
+	}
+
+	return result, nil
+}
+
+// copyVerified copies srcPath to a .part file, re-reads it to verify the hash, then renames it to dstPath
+func copyVerified(ctx context.Context, srcPath string, dstPath string, size int64, progress func(n int64)) (string, error) {
+	err := os.MkdirAll(filepath.Dir(dstPath), 0755)
+	if err != nil {
+		return "", fmt.Errorf("mkdir: %w", err)
+	}
+
+	r, err := os.Open(srcPath)
+	if err != nil {
+		return "", fmt.Errorf("open: %w", err)
+	}
+	defer r.Close()
+
+	partPath := dstPath + ".part"
+	w, err := os.Create(partPath)
+	if err != nil {
+		return "", fmt.Errorf("create: %w", err)
+	}
+	isDone := false
+	defer func() {
+		if !isDone {
+			w.Close()
+			os.Remove(partPath)
+		}
+	}()
+
+	h := md5.New()
+	n, err := io.Copy(io.MultiWriter(w, h), &progressReader{ctx: ctx, r: r, progress: progress})
+	if err != nil {
+		return "", fmt.Errorf("write: %w", err)
+	}
+	if n != size {
+		return "", fmt.Errorf("copied %d bytes, expected %d", n, size)
+	}
+	err = w.Sync()
+	if err != nil {
+		return "", fmt.Errorf("sync: %w", err)
+	}
+	err = w.Close()
+	if err != nil {
+		return "", fmt.Errorf("close: %w", err)
+	}
+
+	hash := fmt.Sprintf("%x", h.Sum(nil))
+	written, err := checksum.MD5Generate(partPath)
+	if err != nil {
+		return "", fmt.Errorf("verify: %w", err)
+	}
+	if written != hash {
+		return "", fmt.Errorf("verify: copy hash %s does not match source %s", written, hash)
+	}
+
+	err = os.Rename(partPath, dstPath)
+	if err != nil {
+		return "", fmt.Errorf("rename: %w", err)
+	}
+	isDone = true
+	return hash, nil
+}
+
+// progressReader reports bytes read and stops when ctx is done
+type progressReader struct {
+	ctx      context.Context
+	r        io.Reader
+	n        int64
+	progress func(n int64)
+}
+
+func (e *progressReader) Read(p []byte) (int, error) {
+	err := e.ctx.Err()
+	if err != nil {
+		return 0, err
+	}
+	n, err := e.r.Read(p)
+	e.n += int64(n)
+	if e.progress != nil {
+		e.progress(e.n)
+	}
+	return n, err
+}
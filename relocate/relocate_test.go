@@ -0,0 +1,208 @@
+package relocate
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/xackery/rof2plus/checksum"
+	"github.com/xackery/rof2plus/internal/testfiles"
+	"github.com/xackery/rof2plus/lock"
+)
+
+var testFiles = map[string]string{
+	"eqgame.exe":             "eqgame",
+	"Resources/dbstr_us.txt": "dbstr content",
+	"uifiles/default/ui.xml": "<ui/>",
+}
+
+func testManifest(files map[string]string) map[string]*checksum.ChecksumEntry {
+	manifest := map[string]*checksum.ChecksumEntry{}
+	for name, content := range files {
+		manifest[name] = &checksum.ChecksumEntry{MD5Hash: testfiles.MD5(content), FileSize: int64(len(content))}
+	}
+	return manifest
+}
+
+// crossDevice makes rename fail as it would across drives
+func crossDevice(t *testing.T) {
+	t.Helper()
+	rename = func(oldpath, newpath string) error {
+		return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: syscall.EXDEV}
+	}
+	t.Cleanup(func() { rename = os.Rename })
+}
+
+func TestMoveRename(t *testing.T) {
+	src := filepath.Join(t.TempDir(), "depot")
+	dst := filepath.Join(t.TempDir(), "rof2")
+	testfiles.Write(t, src, testFiles)
+
+	result, err := Move(context.Background(), src, dst, testManifest(testFiles), nil)
+	if err != nil {
+		t.Fatalf("move: %v", err)
+	}
+	if !result.Renamed {
+		t.Fatalf("expected rename")
+	}
+	testfiles.Assert(t, dst, testFiles)
+}
+
+func TestMoveCopy(t *testing.T) {
+	crossDevice(t)
+	src := filepath.Join(t.TempDir(), "depot")
+	dst := filepath.Join(t.TempDir(), "rof2")
+	testfiles.Write(t, src, testFiles)
+
+	manifest := testManifest(testFiles)
+	manifest["eqgame.exe"].MD5Hash = "bogus"
+
+	lastDone := int64(0)
+	result, err := Move(context.Background(), src, dst, manifest, func(done int64, total int64) {
+		if done < lastDone || done > total {
+			t.Fatalf("bad progress %d/%d after %d", done, total, lastDone)
+		}
+		lastDone = done
+	})
+	if !errors.Is(err, ErrMismatch) {
+		t.Fatalf("expected mismatch, got %v", err)
+	}
+	if result.Renamed || result.Files != len(testFiles) {
+		t.Fatalf("unexpected result: %+v", result)
+	}
+	if len(result.Mismatches) != 1 || result.Mismatches[0] != "eqgame.exe" {
+		t.Fatalf("mismatches: got %v", result.Mismatches)
+	}
+	testfiles.Assert(t, dst, testFiles)
+	testfiles.Assert(t, src, testFiles)
+
+	// running again still finds the mismatch among the finished copies
+	result, err = Move(context.Background(), src, dst, manifest, nil)
+	if !errors.Is(err, ErrMismatch) || result.Skipped != len(testFiles) {
+		t.Fatalf("expected mismatch on resume, got %+v %v", result, err)
+	}
+	testfiles.Assert(t, src, testFiles)
+
+	// without a manifest the copies are accepted
+	_, err = Move(context.Background(), src, dst, nil, nil)
+	if err != nil {
+		t.Fatalf("accept move: %v", err)
+	}
+	testfiles.Assert(t, dst, testFiles)
+
+	_, err = os.Stat(src)
+	if !os.IsNotExist(err) {
+		t.Fatalf("source should be removed, got %v", err)
+	}
+	_, err = os.Stat(filepath.Join(dst, journalName))
+	if !os.IsNotExist(err) {
+		t.Fatalf("journal should be removed, got %v", err)
+	}
+}
+
+func TestMoveResume(t *testing.T) {
+	crossDevice(t)
+	src := filepath.Join(t.TempDir(), "depot")
+	dst := filepath.Join(t.TempDir(), "rof2")
+	testfiles.Write(t, src, testFiles)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := Move(ctx, src, dst, nil, nil)
+	if err == nil {
+		t.Fatalf("expected cancelled move to fail")
+	}
+	testfiles.Assert(t, src, testFiles)
+
+	// simulate a run interrupted after one file finished and another was half written
+	testfiles.Write(t, dst, map[string]string{"eqgame.exe": "eqgame", "Resources/dbstr_us.txt.part": "dbstr"})
+
+	result, err := Move(context.Background(), src, dst, nil, nil)
+	if err != nil {
+		t.Fatalf("resume: %v", err)
+	}
+	if result.Skipped != 1 || result.Files != 2 {
+		t.Fatalf("unexpected result: %+v", result)
+	}
+	testfiles.Assert(t, dst, testFiles)
+}
+
+func TestMoveExistingDestination(t *testing.T) {
+	src := filepath.Join(t.TempDir(), "depot")
+	dst := t.TempDir()
+	testfiles.Write(t, src, testFiles)
+
+	_, err := Move(context.Background(), src, dst, nil, nil)
+	if err == nil {
+		t.Fatalf("expected error moving onto an existing folder")
+	}
+	testfiles.Assert(t, src, testFiles)
+}
+
+func TestMoveLockedSource(t *testing.T) {
+	src := filepath.Join(t.TempDir(), "depot")
+	dst := filepath.Join(t.TempDir(), "rof2")
+	testfiles.Write(t, src, testFiles)
+
+	l, err := lock.Acquire(src)
+	if err != nil {
+		t.Fatalf("lock: %v", err)
+	}
+	defer l.Release()
+
+	_, err = Move(context.Background(), src, dst, nil, nil)
+	if !errors.Is(err, lock.ErrLocked) {
+		t.Fatalf("expected locked source, got %v", err)
+	}
+	testfiles.Assert(t, src, testFiles)
+}
+
+func TestCopyManifest(t *testing.T) {
+	src := filepath.Join(t.TempDir(), "rof2")
+	dst := filepath.Join(t.TempDir(), "rof2core")
+	testfiles.Write(t, src, testFiles)
+
+	core := map[string]string{"eqgame.exe": testFiles["eqgame.exe"]}
+	result, err := CopyManifest(context.Background(), src, dst, testManifest(core), nil)
+	if err != nil {
+		t.Fatalf("copy: %v", err)
+	}
+	if result.Files != 1 {
+		t.Fatalf("files: got %d", result.Files)
+	}
+	testfiles.Assert(t, dst, core)
+	testfiles.Assert(t, src, testFiles)
+	_, err = os.Stat(filepath.Join(dst, "uifiles"))
+	if !os.IsNotExist(err) {
+		t.Fatalf("expected only manifest files, got %v", err)
+	}
+}
+
+func TestAssemble(t *testing.T) {
+	rof2 := filepath.Join(t.TempDir(), "rof2")
+	ls := filepath.Join(t.TempDir(), "ls")
+	dst := filepath.Join(t.TempDir(), "rof2ls")
+	testfiles.Write(t, rof2, testFiles)
+	lsFiles := map[string]string{"eqgame.exe": "ls eqgame", "uifiles/default/ui.xml": "<ls/>"}
+	testfiles.Write(t, ls, lsFiles)
+
+	sources := []Source{
+		{Dir: ls, Manifest: testManifest(lsFiles)},
+		{Dir: rof2, Manifest: testManifest(testFiles)},
+	}
+	result, err := Assemble(context.Background(), dst, sources, nil)
+	if err != nil {
+		t.Fatalf("assemble: %v", err)
+	}
+	if result.Files != len(testFiles) || len(result.Mismatches) != 0 {
+		t.Fatalf("unexpected result: %+v", result)
+	}
+	testfiles.Assert(t, dst, map[string]string{
+		"eqgame.exe":             "ls eqgame",
+		"Resources/dbstr_us.txt": testFiles["Resources/dbstr_us.txt"],
+		"uifiles/default/ui.xml": "<ls/>",
+	})
+}
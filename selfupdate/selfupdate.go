@@ -0,0 +1,250 @@
+// selfupdate checks a release feed for a newer rof2plus and safely replaces the running binary
+package selfupdate
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultFeedURL is the release feed rof2plus checks for updates
+const DefaultFeedURL = "https://github.com/xackery/rof2plus/releases/latest/download/rof2plus_release.json"
+
+// ReleaseKey is the base64 ed25519 public key releases are signed with. It is set at build time with
+// -ldflags "-X github.com/xackery/rof2plus/selfupdate.ReleaseKey=...", a build without it never updates itself
+var ReleaseKey string
+
+// ErrUnsigned is returned when an update can not be verified as a signed rof2plus release
+var ErrUnsigned = errors.New("release is not signed")
+
+// Release is the release feed document
+type Release struct {
+	Version string   `json:"version"`
+	Notes   string   `json:"notes"`
+	Assets  []*Asset `json:"assets"`
+}
+
+// Asset is a platform binary of a release
+type Asset struct {
+	OS     string `json:"os"`
+	Arch   string `json:"arch"`
+	URL    string `json:"url"`
+	SHA256 string `json:"sha256"`
+	// Signature is a base64 ed25519 signature of the raw sha256 digest. The sha256 comes from the same
+	// feed as the binary, so only the signature proves where a release came from
+	Signature string `json:"signature"`
+}
+
+// Updater checks FeedURL for releases newer than CurrentVersion
+type Updater struct {
+	FeedURL        string
+	CurrentVersion string
+	// PublicKey verifies asset signatures, updates are refused without it
+	PublicKey ed25519.PublicKey
+	Client    *http.Client
+	GOOS      string
+	GOARCH    string
+}
+
+// New returns an updater for this platform using the default feed and ReleaseKey
+func New(currentVersion string) *Updater {
+	return &Updater{
+		FeedURL:        DefaultFeedURL,
+		CurrentVersion: currentVersion,
+		PublicKey:      parseKey(ReleaseKey),
+		Client:         &http.Client{Timeout: 30 * time.Second},
+		GOOS:           runtime.GOOS,
+		GOARCH:         runtime.GOARCH,
+	}
+}
+
+// parseKey decodes a base64 ed25519 public key, returning nil if it is not one
+func parseKey(value string) ed25519.PublicKey {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(value))
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return nil
+	}
+	return ed25519.PublicKey(key)
+}
+
+// Check fetches the feed and returns the release and asset for this platform if newer than
+// CurrentVersion, or nil when up to date
+func (e *Updater) Check(ctx context.Context) (*Release, *Asset, error) {
+	if !IsRelease(e.CurrentVersion) {
+		return nil, nil, nil
+	}
+	if len(e.PublicKey) != ed25519.PublicKeySize {
+		return nil, nil, fmt.Errorf("no release public key in this build: %w", ErrUnsigned)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", e.FeedURL, nil)
+	if err != nil {
+		return nil, nil, fmt.Errorf("create request: %w", err)
+	}
+	resp, err := e.Client.Do(req)
+	if err != nil {
+		return nil, nil, fmt.Errorf("download %s: %w", e.FeedURL, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil, fmt.Errorf("download %s responded HTTP status code %d", e.FeedURL, resp.StatusCode)
+	}
+
+	release := &Release{}
+	err = json.NewDecoder(resp.Body).Decode(release)
+	if err != nil {
+		return nil, nil, fmt.Errorf("decode feed: %w", err)
+	}
+
+	if CompareVersions(release.Version, e.CurrentVersion) <= 0 {
+		return nil, nil, nil
+	}
+
+	for _, asset := range release.Assets {
+		if asset.OS == e.GOOS && asset.Arch == e.GOARCH {
+			return release, asset, nil
+		}
+	}
+	return nil, nil, fmt.Errorf("release %s has no binary for %s/%s", release.Version, e.GOOS, e.GOARCH)
+}
+
+// Apply downloads asset next to exePath, verifies it, then swaps it in. The old binary is kept as
+// exePath.old until the next CleanupOld, since windows cannot delete a running executable
+func (e *Updater) Apply(ctx context.Context, asset *Asset, exePath string) error {
+	if asset.SHA256 == "" {
+		return fmt.Errorf("asset has no sha256")
+	}
+	if len(e.PublicKey) != ed25519.PublicKeySize {
+		return fmt.Errorf("no release public key in this build: %w", ErrUnsigned)
+	}
+	if asset.Signature == "" {
+		return fmt.Errorf("asset has no signature: %w", ErrUnsigned)
+	}
+
+	newPath := exePath + ".new"
+	oldPath := exePath + ".old"
+
+	err := e.download(ctx, asset, newPath)
+	if err != nil {
+		os.Remove(newPath)
+		return err
+	}
+
+	os.Remove(oldPath)
+	err = os.Rename(exePath, oldPath)
+	if err != nil {
+		os.Remove(newPath)
+		return fmt.Errorf("move current binary: %w", err)
+	}
+
+	err = os.Rename(newPath, exePath)
+	if err != nil {
+		rollbackErr := os.Rename(oldPath, exePath)
+		if rollbackErr != nil {
+			return fmt.Errorf("replace binary: %w (restore failed: %v)", err, rollbackErr)
+		}
+		os.Remove(newPath)
+		return fmt.Errorf("replace binary: %w", err)
+	}
+	return nil
+}
+
+// download writes asset to path and verifies its checksum and signature
+func (e *Updater) download(ctx context.Context, asset *Asset, path string) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", asset.URL, nil)
+	if err != nil {
+		return fmt.Errorf("create request: %w", err)
+	}
+	resp, err := e.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("download %s: %w", asset.URL, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("download %s responded HTTP status code %d", asset.URL, resp.StatusCode)
+	}
+
+	w, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0755)
+	if err != nil {
+		return fmt.Errorf("create %s: %w", path, err)
+	}
+	defer w.Close()
+
+	h := sha256.New()
+	_, err = io.Copy(io.MultiWriter(w, h), resp.Body)
+	if err != nil {
+		return fmt.Errorf("write %s: %w", path, err)
+	}
+	err = w.Close()
+	if err != nil {
+		return fmt.Errorf("close %s: %w", path, err)
+	}
+
+	digest := h.Sum(nil)
+	if !strings.EqualFold(hex.EncodeToString(digest), asset.SHA256) {
+		return fmt.Errorf("checksum mismatch: got %x, want %s", digest, asset.SHA256)
+	}
+
+	signature, err := base64.StdEncoding.DecodeString(asset.Signature)
+	if err != nil {
+		return fmt.Errorf("decode signature: %w", ErrUnsigned)
+	}
+	if !ed25519.Verify(e.PublicKey, digest, signature) {
+		return fmt.Errorf("signature mismatch: %w", ErrUnsigned)
+	}
+	return nil
+}
+
+// CleanupOld removes a binary left behind by a previous Apply
+func CleanupOld(exePath string) {
+	os.Remove(exePath + ".old")
+}
+
+// IsRelease returns true if version is a dotted numeric release version, not a dev build
+func IsRelease(version string) bool {
+	if version == "" {
+		return false
+	}
+	for _, part := range strings.Split(version, ".") {
+		_, err := strconv.Atoi(part)
+		if err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// CompareVersions compares dotted numeric versions such as 0.1.1.2, returning -1, 0 or 1
+func CompareVersions(a string, b string) int {
+	aParts := strings.Split(strings.TrimPrefix(a, "v"), ".")
+	bParts := strings.Split(strings.TrimPrefix(b, "v"), ".")
+	for i := 0; i < max(len(aParts), len(bParts)); i++ {
+		aValue := 0
+		if i < len(aParts) {
+			aValue, _ = strconv.Atoi(aParts[i])
+		}
+		bValue := 0
+		if i < len(bParts) {
+			bValue, _ = strconv.Atoi(bParts[i])
+		}
+		if aValue < bValue {
+			return -1
+		}
+		if aValue > bValue {
+			return 1
+		}
+	}
+	return 0
+}
@@ -0,0 +1,246 @@
+package selfupdate
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+type testFeed struct {
+	server     *httptest.Server
+	release    *Release
+	binary     []byte
+	publicKey  ed25519.PublicKey
+	privateKey ed25519.PrivateKey
+}
+
+func newTestFeed(t *testing.T, version string, binary []byte) *testFeed {
+	t.Helper()
+	publicKey, privateKey, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	feed := &testFeed{binary: binary, publicKey: publicKey, privateKey: privateKey}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/feed.json", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(feed.release)
+	})
+	mux.HandleFunc("/rof2plus", func(w http.ResponseWriter, r *http.Request) {
+		w.Write(feed.binary)
+	})
+	feed.server = httptest.NewServer(mux)
+	t.Cleanup(feed.server.Close)
+
+	digest := sha256.Sum256(binary)
+	feed.release = &Release{
+		Version: version,
+		Assets: []*Asset{
+			{OS: "plan9", Arch: "mips", URL: feed.server.URL + "/nope", SHA256: "00"},
+			{OS: "linux", Arch: "amd64", URL: feed.server.URL + "/rof2plus", SHA256: hex.EncodeToString(digest[:]), Signature: base64.StdEncoding.EncodeToString(ed25519.Sign(privateKey, digest[:]))},
+		},
+	}
+	return feed
+}
+
+func (e *testFeed) updater(current string) *Updater {
+	return &Updater{
+		FeedURL:        e.server.URL + "/feed.json",
+		CurrentVersion: current,
+		PublicKey:      e.publicKey,
+		Client:         e.server.Client(),
+		GOOS:           "linux",
+		GOARCH:         "amd64",
+	}
+}
+
+func writeExe(t *testing.T) string {
+	t.Helper()
+	exePath := filepath.Join(t.TempDir(), "rof2plus")
+	err := os.WriteFile(exePath, []byte("old binary"), 0755)
+	if err != nil {
+		t.Fatalf("write exe: %v", err)
+	}
+	return exePath
+}
+
+func assertContent(t *testing.T, path string, want string) {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read %s: %v", path, err)
+	}
+	if string(data) != want {
+		t.Fatalf("%s: got %q want %q", path, data, want)
+	}
+}
+
+func TestCompareVersions(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"0.1.1.2", "0.1.1.2", 0},
+		{"0.1.1.3", "0.1.1.2", 1},
+		{"0.1.1", "0.1.1.2", -1},
+		{"0.2", "0.1.9.9", 1},
+		{"v1.0.0", "0.9", 1},
+		{"0.1.10", "0.1.9", 1},
+	}
+	for _, tt := range tests {
+		got := CompareVersions(tt.a, tt.b)
+		if got != tt.want {
+			t.Fatalf("CompareVersions(%s, %s): got %d want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+
+	if IsRelease("dev") || IsRelease("") || !IsRelease("0.1.1.2") {
+		t.Fatalf("IsRelease mismatch")
+	}
+}
+
+func TestCheck(t *testing.T) {
+	feed := newTestFeed(t, "0.1.1.3", []byte("new binary"))
+
+	release, asset, err := feed.updater("0.1.1.2").Check(context.Background())
+	if err != nil {
+		t.Fatalf("check: %v", err)
+	}
+	if release == nil || release.Version != "0.1.1.3" || asset.OS != "linux" {
+		t.Fatalf("expected update, got %+v %+v", release, asset)
+	}
+
+	for _, current := range []string{"0.1.1.3", "0.2", "dev"} {
+		release, _, err = feed.updater(current).Check(context.Background())
+		if err != nil {
+			t.Fatalf("check %s: %v", current, err)
+		}
+		if release != nil {
+			t.Fatalf("expected no update for %s, got %s", current, release.Version)
+		}
+	}
+
+	updater := feed.updater("0.1.1.2")
+	updater.GOARCH = "arm64"
+	_, _, err = updater.Check(context.Background())
+	if err == nil {
+		t.Fatalf("expected error for missing platform")
+	}
+}
+
+func TestApply(t *testing.T) {
+	feed := newTestFeed(t, "0.1.1.3", []byte("new binary"))
+	exePath := writeExe(t)
+
+	updater := feed.updater("0.1.1.2")
+	_, asset, err := updater.Check(context.Background())
+	if err != nil {
+		t.Fatalf("check: %v", err)
+	}
+
+	err = updater.Apply(context.Background(), asset, exePath)
+	if err != nil {
+		t.Fatalf("apply: %v", err)
+	}
+	assertContent(t, exePath, "new binary")
+	assertContent(t, exePath+".old", "old binary")
+
+	CleanupOld(exePath)
+	_, err = os.Stat(exePath + ".old")
+	if !os.IsNotExist(err) {
+		t.Fatalf("old binary should be removed, got %v", err)
+	}
+}
+
+func TestApplyChecksumMismatch(t *testing.T) {
+	feed := newTestFeed(t, "0.1.1.3", []byte("new binary"))
+	exePath := writeExe(t)
+
+	updater := feed.updater("0.1.1.2")
+	_, asset, err := updater.Check(context.Background())
+	if err != nil {
+		t.Fatalf("check: %v", err)
+	}
+	feed.binary = []byte("tampered binary")
+
+	err = updater.Apply(context.Background(), asset, exePath)
+	if err == nil {
+		t.Fatalf("expected checksum error")
+	}
+	assertContent(t, exePath, "old binary")
+	_, err = os.Stat(exePath + ".new")
+	if !os.IsNotExist(err) {
+		t.Fatalf("partial download should be removed, got %v", err)
+	}
+}
+
+func TestApplySignature(t *testing.T) {
+	binary := []byte("signed binary")
+	feed := newTestFeed(t, "0.1.1.3", binary)
+	exePath := writeExe(t)
+
+	updater := feed.updater("0.1.1.2")
+	_, asset, err := updater.Check(context.Background())
+	if err != nil {
+		t.Fatalf("check: %v", err)
+	}
+	signature := asset.Signature
+
+	asset.Signature = ""
+	err = updater.Apply(context.Background(), asset, exePath)
+	if !errors.Is(err, ErrUnsigned) {
+		t.Fatalf("expected error for unsigned asset, got %v", err)
+	}
+
+	digest := sha256.Sum256([]byte("something else"))
+	asset.Signature = base64.StdEncoding.EncodeToString(ed25519.Sign(feed.privateKey, digest[:]))
+	err = updater.Apply(context.Background(), asset, exePath)
+	if !errors.Is(err, ErrUnsigned) {
+		t.Fatalf("expected error for bad signature, got %v", err)
+	}
+	assertContent(t, exePath, "old binary")
+
+	// a build without the release key refuses to update at all
+	unkeyed := feed.updater("0.1.1.2")
+	unkeyed.PublicKey = nil
+	_, _, err = unkeyed.Check(context.Background())
+	if !errors.Is(err, ErrUnsigned) {
+		t.Fatalf("expected check without a key to fail, got %v", err)
+	}
+	asset.Signature = signature
+	err = unkeyed.Apply(context.Background(), asset, exePath)
+	if !errors.Is(err, ErrUnsigned) {
+		t.Fatalf("expected apply without a key to fail, got %v", err)
+	}
+	assertContent(t, exePath, "old binary")
+
+	err = updater.Apply(context.Background(), asset, exePath)
+	if err != nil {
+		t.Fatalf("apply: %v", err)
+	}
+	assertContent(t, exePath, "signed binary")
+}
+
+func TestParseKey(t *testing.T) {
+	publicKey, _, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	key := parseKey(base64.StdEncoding.EncodeToString(publicKey))
+	if !publicKey.Equal(key) {
+		t.Fatalf("got %x", key)
+	}
+	for _, value := range []string{"", "not base64!", base64.StdEncoding.EncodeToString([]byte("short"))} {
+		if parseKey(value) != nil {
+			t.Fatalf("%q: expected no key", value)
+		}
+	}
+}
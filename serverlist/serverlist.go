@@ -4,8 +4,10 @@ package serverlist
 import (
 	"fmt"
 	"os"
+	"path/filepath"
 	"time"
 
+	"github.com/xackery/rof2plus/checksum"
 	"gopkg.in/yaml.v3"
 )
 
@@ -21,21 +23,40 @@ type ServerEntry struct {
 	ShortName string `yaml:"shortname"`
 	Name      string `yaml:"name"`
 	PatchURL  string `yaml:"patchurl"`
+	// Client is the base client the server requires: rof2, rof2core or ls. Defaults to rof2
+	Client string `yaml:"client"`
+	// LoginHost is the login server written to eqhost.txt, e.g. login.eqemulator.net:5998
+	LoginHost string `yaml:"loginhost"`
+	// Mirrors are download prefixes tried in order when the file list's download prefix fails,
+	// such as release assets, a CDN or a LAN cache
+	Mirrors []string `yaml:"mirrors"`
 }
 
-// Fetch gets the latest server list
-func Fetch() error {
-	data, err := os.ReadFile("rof2plus_servers.yaml")
+// BaseClient returns the base client the server requires
+func (e *ServerEntry) BaseClient() (checksum.ChecksumClient, error) {
+	if e.Client == "" {
+		return checksum.ClientRoF2, nil
+	}
+	client, err := checksum.ClientByName(e.Client)
+	if err != nil {
+		return client, err
+	}
+	switch client {
+	case checksum.ClientPatcher, checksum.ClientLSOptional, checksum.ClientRoF2LS:
+		return client, fmt.Errorf("%s is not a base client", client.String())
+	}
+	return client, nil
+}
+
+// Fetch gets the latest server list, caching it at path
+func Fetch(path string) error {
+	data, err := os.ReadFile(path)
 	if err != nil {
 		if os.IsNotExist(err) {
-			err = download()
+			err = download(path)
 			if err != nil {
 				return fmt.Errorf("download: %w", err)
 			}
-			data, err = os.ReadFile("rof2plus_servers.yaml")
-			if err != nil {
-				return fmt.Errorf("read download: %w", err)
-			}
 			return nil
 		}
 
@@ -50,18 +71,22 @@ func Fetch() error {
 	return nil
 }
 
-func download() error {
+func download(path string) error {
 	// make a mock serverlist
 	server.Entries = []*ServerEntry{
 		{
 			Name:      "Test Server",
 			ShortName: "test",
 			PatchURL:  "https://example.com/patch",
+			Client:    "rof2",
+			LoginHost: "login.eqemulator.net:5998",
 		},
 		{
 			Name:      "Another Server",
 			ShortName: "another",
 			PatchURL:  "https://example.com/anotherpatch",
+			Client:    "rof2core",
+			LoginHost: "login.eqemulator.net:5998",
 		},
 	}
 	server.LastUpdate = time.Now()
@@ -71,7 +96,12 @@ func download() error {
 		return fmt.Errorf("marshal: %w", err)
 	}
 
-	err = os.WriteFile("rof2plus_servers.yaml", data, 0644)
+	err = os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return fmt.Errorf("mkdir: %w", err)
+	}
+
+	err = os.WriteFile(path, data, 0644)
 	if err != nil {
 		return fmt.Errorf("write: %w", err)
 	}
@@ -0,0 +1,148 @@
+package start
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"time"
+
+	"github.com/xackery/rof2plus/checksum"
+)
+
+const (
+	// depotTimeout is how long a depot download may take in total
+	depotTimeout = 4 * time.Hour
+	// depotStallTimeout is how long a depot download may go without growing
+	depotStallTimeout = 10 * time.Minute
+	// depotPollInterval is how often the depot folder is polled
+	depotPollInterval = 2 * time.Second
+)
+
+// ErrDepotStalled is returned when a depot download stops growing before it finishes
+var ErrDepotStalled = errors.New("depot download stalled")
+
+// depotMonitor reports depot download progress as bytes present versus bytes in a manifest
+type depotMonitor struct {
+	fsys         fs.FS
+	manifest     map[string]*checksum.ChecksumEntry
+	interval     time.Duration
+	stallTimeout time.Duration
+	// progress is called each time the downloaded byte count changes
+	progress func(done int64, total int64)
+}
+
+// bytesPresent returns the bytes found on disk, each file capped at its manifest size, and the manifest total
+func (m *depotMonitor) bytesPresent() (int64, int64) {
+	done := int64(0)
+	total := int64(0)
+	for name, entry := range m.manifest {
+		total += entry.FileSize
+		fi, err := fs.Stat(m.fsys, name)
+		if err != nil || fi.IsDir() {
+			continue
+		}
+		done += min(fi.Size(), entry.FileSize)
+	}
+	return done, total
+}
+
+// wait polls until every manifest byte is present, returning early if ctx is done or
+// the download makes no progress for stallTimeout
+func (m *depotMonitor) wait(ctx context.Context) error {
+	lastDone := int64(-1)
+	lastChange := time.Now()
+
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+
+	for {
+		done, total := m.bytesPresent()
+		if done != lastDone {
+			lastDone = done
+			lastChange = time.Now()
+			if m.progress != nil {
+				m.progress(done, total)
+			}
+		}
+		if done >= total {
+			return nil
+		}
+		if m.stallTimeout > 0 && time.Since(lastChange) >= m.stallTimeout {
+			return fmt.Errorf("%w: %s of %s after %s without progress", ErrDepotStalled, byteSize(done), byteSize(total), m.stallTimeout)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// monitorDepotDownload waits for the depot folder to appear then reports progress until the
+// client's manifest is fully downloaded, the download stalls, times out, or is interrupted with Ctrl-C
+func monitorDepotDownload(ctx context.Context, client checksum.ChecksumClient) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, depotTimeout)
+	defer cancel()
+
+	manifest, err := checksum.Manifest(client)
+	if err != nil {
+		return "", fmt.Errorf("manifest: %w", err)
+	}
+
+	depotPath := ""
+	for {
+		depotPath, err = SteamPath()
+		if err == nil {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return "", fmt.Errorf("wait for depot: %w", ctx.Err())
+		case <-time.After(6 * time.Second):
+		}
+	}
+
+	fmt.Println("Depot path:", depotPath)
+	fmt.Println("Download started, I'll report progress until finished. Press Ctrl-C to stop waiting")
+
+	lastPercent := int64(-1)
+	monitor := &depotMonitor{
+		fsys:         os.DirFS(depotPath),
+		manifest:     manifest,
+		interval:     depotPollInterval,
+		stallTimeout: depotStallTimeout,
+		progress: func(done int64, total int64) {
+			percent := done * 100 / max(total, 1)
+			if percent == lastPercent {
+				return
+			}
+			lastPercent = percent
+			fmt.Printf("Download progress: %d%% (%s / %s)\n", percent, byteSize(done), byteSize(total))
+		},
+	}
+
+	err = monitor.wait(ctx)
+	if err != nil {
+		if errors.Is(err, ErrDepotStalled) && lastPercent >= 99 {
+			fmt.Println("Download appears finished, a few files differ from the manifest and will be validated next")
+			return depotPath, nil
+		}
+		return "", err
+	}
+
+	return depotPath, nil
+}
+
+// byteSize returns a human readable size
+func byteSize(size int64) string {
+	switch {
+	case size >= 1024*1024*1024:
+		return fmt.Sprintf("%0.2f GB", float64(size)/1024/1024/1024)
+	case size >= 1024*1024:
+		return fmt.Sprintf("%0.2f MB", float64(size)/1024/1024)
+	}
+	return fmt.Sprintf("%0.2f KB", float64(size)/1024)
+}
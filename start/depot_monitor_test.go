@@ -0,0 +1,152 @@
+package start
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/xackery/rof2plus/checksum"
+)
+
+// growingFS is a fake depot folder whose files grow at rate bytes per millisecond until they
+// reach their final size, or stallAt total bytes if set
+type growingFS struct {
+	start   time.Time
+	rate    int64
+	stallAt int64
+	names   []string
+	sizes   map[string]int64
+}
+
+type growingFileInfo struct {
+	name string
+	size int64
+}
+
+func (e *growingFileInfo) Name() string       { return e.name }
+func (e *growingFileInfo) Size() int64        { return e.size }
+func (e *growingFileInfo) Mode() fs.FileMode  { return 0644 }
+func (e *growingFileInfo) ModTime() time.Time { return time.Time{} }
+func (e *growingFileInfo) IsDir() bool        { return false }
+func (e *growingFileInfo) Sys() any           { return nil }
+
+func (e *growingFS) Open(name string) (fs.File, error) {
+	return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+}
+
+// Stat sizes files in order, as if steam downloaded them one at a time
+func (e *growingFS) Stat(name string) (fs.FileInfo, error) {
+	written := time.Since(e.start).Milliseconds() * e.rate
+	if e.stallAt > 0 {
+		written = min(written, e.stallAt)
+	}
+	for _, n := range e.names {
+		size := min(written, e.sizes[n])
+		if n == name {
+			if size <= 0 {
+				return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+			}
+			return &growingFileInfo{name: name, size: size}, nil
+		}
+		written -= size
+	}
+	return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+}
+
+func newGrowingFS(rate int64, stallAt int64) (*growingFS, map[string]*checksum.ChecksumEntry) {
+	fsys := &growingFS{
+		start:   time.Now(),
+		rate:    rate,
+		stallAt: stallAt,
+		names:   []string{"eqgame.exe", "global_chr.s3d", "Resources/dbstr_us.txt"},
+		sizes:   map[string]int64{"eqgame.exe": 4000, "global_chr.s3d": 10000, "Resources/dbstr_us.txt": 6000},
+	}
+	manifest := map[string]*checksum.ChecksumEntry{}
+	for name, size := range fsys.sizes {
+		manifest[name] = &checksum.ChecksumEntry{FileSize: size}
+	}
+	return fsys, manifest
+}
+
+func TestDepotMonitorComplete(t *testing.T) {
+	fsys, manifest := newGrowingFS(1000, 0)
+
+	mu := sync.Mutex{}
+	reports := []int64{}
+	monitor := &depotMonitor{
+		fsys:         fsys,
+		manifest:     manifest,
+		interval:     time.Millisecond,
+		stallTimeout: time.Second,
+		progress: func(done int64, total int64) {
+			mu.Lock()
+			defer mu.Unlock()
+			if total != 20000 {
+				t.Errorf("total: got %d", total)
+			}
+			reports = append(reports, done)
+		},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err := monitor.wait(ctx)
+	if err != nil {
+		t.Fatalf("wait: %v", err)
+	}
+
+	if len(reports) < 2 {
+		t.Fatalf("expected several progress reports, got %v", reports)
+	}
+	for i := 1; i < len(reports); i++ {
+		if reports[i] < reports[i-1] {
+			t.Fatalf("progress went backwards: %v", reports)
+		}
+	}
+	if reports[len(reports)-1] != 20000 {
+		t.Fatalf("final progress: got %d", reports[len(reports)-1])
+	}
+}
+
+func TestDepotMonitorStall(t *testing.T) {
+	fsys, manifest := newGrowingFS(1000, 12000)
+
+	monitor := &depotMonitor{
+		fsys:         fsys,
+		manifest:     manifest,
+		interval:     time.Millisecond,
+		stallTimeout: 50 * time.Millisecond,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err := monitor.wait(ctx)
+	if !errors.Is(err, ErrDepotStalled) {
+		t.Fatalf("expected stall, got %v", err)
+	}
+
+	done, total := monitor.bytesPresent()
+	if done != 12000 || total != 20000 {
+		t.Fatalf("bytes: got %d/%d", done, total)
+	}
+}
+
+func TestDepotMonitorCancel(t *testing.T) {
+	fsys, manifest := newGrowingFS(1, 0)
+
+	monitor := &depotMonitor{
+		fsys:     fsys,
+		manifest: manifest,
+		interval: time.Millisecond,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	err := monitor.wait(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded, got %v", err)
+	}
+}
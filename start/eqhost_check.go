@@ -0,0 +1,72 @@
+package start
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/xackery/rof2plus/paths"
+	"github.com/xackery/rof2plus/serverlist"
+)
+
+// eqhostCheck writes eqhost.txt in the server directory so the client connects to the server's login host.
+// A user-edited eqhost.txt is backed up before it is replaced
+func eqhostCheck(p *paths.Paths, server *serverlist.ServerEntry) error {
+	if server.LoginHost == "" {
+		return nil
+	}
+
+	eqPath, err := p.ServerDir(server.ShortName)
+	if err != nil {
+		return err
+	}
+	return writeEQHost(eqPath, server.LoginHost)
+}
+
+// eqhostContent returns the eqhost.txt content for loginHost
+func eqhostContent(loginHost string) []byte {
+	return []byte(fmt.Sprintf("[Registration Servers]\r\n{\r\n\"%s\"\r\n}\r\n[Login Servers]\r\n{\r\n\"%s\"\r\n}\r\n", loginHost, loginHost))
+}
+
+func writeEQHost(dir string, loginHost string) error {
+	path := filepath.Join(dir, "eqhost.txt")
+	content := eqhostContent(loginHost)
+
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("read eqhost: %w", err)
+	}
+	if err == nil {
+		if bytes.Equal(data, content) {
+			return nil
+		}
+
+		backupPath := path + ".bak"
+		_, err = os.Stat(backupPath)
+		if err == nil {
+			backupPath = fmt.Sprintf("%s.%s.bak", path, time.Now().Format("20060102-150405"))
+		}
+		err = os.WriteFile(backupPath, data, 0644)
+		if err != nil {
+			return fmt.Errorf("backup eqhost: %w", err)
+		}
+		fmt.Printf("Your eqhost.txt was changed, a backup was saved to %s\n", backupPath)
+	}
+
+	err = os.WriteFile(path, content, 0644)
+	if err != nil {
+		return fmt.Errorf("write eqhost: %w", err)
+	}
+
+	data, err = os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("verify eqhost: %w", err)
+	}
+	if !bytes.Equal(data, content) {
+		return fmt.Errorf("verify eqhost: content mismatch")
+	}
+
+	return nil
+}
@@ -0,0 +1,52 @@
+package start
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteEQHost(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "eqhost.txt")
+
+	err := writeEQHost(dir, "login.example.com:5998")
+	if err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if string(data) != string(eqhostContent("login.example.com:5998")) {
+		t.Fatalf("unexpected content: %q", data)
+	}
+
+	// unchanged content should not make a backup
+	err = writeEQHost(dir, "login.example.com:5998")
+	if err != nil {
+		t.Fatalf("rewrite: %v", err)
+	}
+	_, err = os.Stat(path + ".bak")
+	if !os.IsNotExist(err) {
+		t.Fatalf("expected no backup, got %v", err)
+	}
+
+	userEdit := []byte("[Login Servers]\r\n{\r\n\"127.0.0.1:5998\"\r\n}\r\n")
+	err = os.WriteFile(path, userEdit, 0644)
+	if err != nil {
+		t.Fatalf("user edit: %v", err)
+	}
+
+	err = writeEQHost(dir, "login.example.com:5998")
+	if err != nil {
+		t.Fatalf("write over edit: %v", err)
+	}
+	backup, err := os.ReadFile(path + ".bak")
+	if err != nil {
+		t.Fatalf("read backup: %v", err)
+	}
+	if string(backup) != string(userEdit) {
+		t.Fatalf("backup mismatch: %q", backup)
+	}
+}
@@ -1,17 +1,19 @@
 package start
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"path/filepath"
 	"strings"
+
+	"github.com/xackery/rof2plus/install"
+	"github.com/xackery/rof2plus/prompt"
 )
 
 // installCheck verifies you are not running the program in Downloads, Desktop, or other common generic folders
-// if you are, it'll ask you to install it
-func installCheck() error {
-	// TODO: registry check for active installation
-
+// if you are, it'll point to an existing install or ask to install it
+func installCheck(ctx context.Context) error {
 	exePath, err := os.Executable()
 	if err != nil {
 		return fmt.Errorf("get absolute path: %w", err)
@@ -22,77 +24,63 @@ func installCheck() error {
 		"Documents",
 		"Downloads",
 	}
-	for _, folder := range notInstalledFolders {
-		if strings.Contains(exePath, folder) {
-			fmt.Printf("It looks like you are running the program from your %s folder.\n", folder)
-			fmt.Printf("Where would you like to install the program? ")
-			path := ""
-			_, err := fmt.Scanln(&path)
-			if err != nil {
-				return fmt.Errorf("scan path: %w", err)
-			}
-			path = strings.TrimSpace(path)
-			if path == "" {
-				fmt.Println("You must provide a valid path.")
-				return fmt.Errorf("invalid path")
-			}
-			fi, err := os.Stat(path)
-			if err != nil {
-				if !os.IsNotExist(err) {
-					return fmt.Errorf("stat path: %w", err)
-				}
-				err = os.MkdirAll(path, 0755)
-				if err != nil {
-					return fmt.Errorf("mkdir path: %w", err)
-				}
-			}
-			if err == nil && !fi.IsDir() {
-				return fmt.Errorf("path is not a directory")
-			}
-			outPath := filepath.Join(path, "rof2plus.exe")
-			fi, err = os.Stat(outPath)
-			if err == nil && !fi.IsDir() {
-				fmt.Printf("File %s already exists. Overwrite? (y/n): ", outPath)
-				var overwrite string
-				_, err := fmt.Scanln(&overwrite)
-				if err != nil {
-					return fmt.Errorf("scan overwrite: %w", err)
-				}
-				if strings.ToLower(overwrite) != "y" {
-					fmt.Println("Installation cancelled.")
-					return nil
-				}
-			}
-			if err != nil && !os.IsNotExist(err) {
-				return fmt.Errorf("stat outPath: %w", err)
-			}
-			if err == nil {
-				err = os.Remove(outPath)
-				if err != nil {
-					return fmt.Errorf("remove outPath: %w", err)
-				}
-			}
+	folder := ""
+	for _, notInstalledFolder := range notInstalledFolders {
+		if strings.Contains(exePath, notInstalledFolder) {
+			folder = notInstalledFolder
+			break
+		}
+	}
+	if folder == "" {
+		return nil
+	}
+
+	layout, err := install.DefaultLayout()
+	if err != nil {
+		return fmt.Errorf("install layout: %w", err)
+	}
+	record, err := layout.Installed()
+	if err != nil {
+		return fmt.Errorf("install record: %w", err)
+	}
+	if record != nil {
+		fmt.Printf("rof2plus is installed at %s, you can launch it from there next time\n", record.Dir)
+		return nil
+	}
 
-			w, err := os.Create(outPath)
-			if err != nil {
-				return fmt.Errorf("create exe: %w", err)
-			}
-			defer w.Close()
-			exeData, err := os.ReadFile(exePath)
-			if err != nil {
-				return fmt.Errorf("read exe: %w", err)
-			}
-			_, err = w.Write(exeData)
-			if err != nil {
-				return fmt.Errorf("write exe: %w", err)
-			}
-			fmt.Printf("Installed to %s\n", outPath)
-			err = os.Chdir(path)
-			if err != nil {
-				return fmt.Errorf("chdir: %w", err)
-			}
-			fmt.Printf("Changed working directory to %s\n", path)
+	fmt.Printf("It looks like you are running the program from your %s folder.\n", folder)
+	fmt.Printf("Where would you like to install the program? (enter for %s) ", layout.Dir)
+	path, err := prompt.ReadLine(ctx)
+	if err != nil {
+		return fmt.Errorf("scan path: %w", err)
+	}
+	if path != "" {
+		layout, err = install.LayoutAt(path)
+		if err != nil {
+			return fmt.Errorf("install layout: %w", err)
+		}
+	}
+
+	fi, err := os.Stat(layout.Binary)
+	if err == nil && !fi.IsDir() {
+		fmt.Printf("File %s already exists. Overwrite? (y/n): ", layout.Binary)
+		overwrite, err := prompt.ReadLine(ctx)
+		if err != nil {
+			return fmt.Errorf("scan overwrite: %w", err)
 		}
+		if strings.ToLower(overwrite) != "y" {
+			fmt.Println("Installation cancelled.")
+			return nil
+		}
+	}
+
+	record, err = layout.Install(exePath)
+	if err != nil {
+		return fmt.Errorf("install: %w", err)
+	}
+	fmt.Printf("Installed to %s\n", record.Binary)
+	for _, shortcut := range layout.Shortcuts {
+		fmt.Printf("Created shortcut %s\n", filepath.Base(shortcut))
 	}
 
 	return nil
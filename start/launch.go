@@ -4,19 +4,20 @@ import (
 	"fmt"
 	"os"
 	"os/exec"
+	"path/filepath"
 
+	"github.com/xackery/rof2plus/paths"
 	"github.com/xackery/rof2plus/serverlist"
 )
 
-func launch(server *serverlist.ServerEntry) error {
-
-	err := os.Chdir(server.ShortName)
+func launch(p *paths.Paths, server *serverlist.ServerEntry) error {
+	eqPath, err := p.ServerDir(server.ShortName)
 	if err != nil {
-		return fmt.Errorf("chdir: %w", err)
+		return err
 	}
 
-	cmd := exec.Command("eqgame.exe", "patchme")
-	cmd.Dir = server.ShortName
+	cmd := exec.Command(filepath.Join(eqPath, "eqgame.exe"), "patchme")
+	cmd.Dir = eqPath
 
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
@@ -1,18 +1,41 @@
 package start
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"log/slog"
 	"os"
-	"path/filepath"
+	"time"
 
 	"github.com/xackery/rof2plus/checksum"
+	"github.com/xackery/rof2plus/lancache"
 	"github.com/xackery/rof2plus/patch"
 	"github.com/xackery/rof2plus/serverlist"
 )
 
-func patchCheck(server *serverlist.ServerEntry) error {
+func patchCheck(ctx context.Context, opts Options, server *serverlist.ServerEntry) error {
 
-	eqPath := filepath.Join(server.ShortName)
+	err := opts.Paths.MoveLegacyServerDir(server.ShortName)
+	if err != nil {
+		return fmt.Errorf("server dir: %w", err)
+	}
+	eqPath, err := opts.Paths.ServerDir(server.ShortName)
+	if err != nil {
+		return err
+	}
+	cacheDir, err := opts.Paths.ServerCacheDir(server.ShortName)
+	if err != nil {
+		return err
+	}
+	backupDir, err := opts.Paths.BackupDir(server.ShortName)
+	if err != nil {
+		return err
+	}
+	reportFile, err := opts.Paths.CheckReportFile(server.ShortName)
+	if err != nil {
+		return err
+	}
 
 	fi, err := os.Stat(eqPath)
 	if err != nil {
@@ -28,15 +51,124 @@ func patchCheck(server *serverlist.ServerEntry) error {
 		return fmt.Errorf("path is not a directory: %s", eqPath)
 	}
 
-	fileList, err := checksum.FetchPatcherFilelist(server.PatchURL)
+	cacheURL := lanCacheCheck(ctx, opts)
+	var fileList *checksum.CachedFileList
+	// a discovered cache answered an unauthenticated broadcast, it is only trusted with files, which
+	// are checked against the file list. The file list comes through a cache the player configured
+	if cacheURL != "" && opts.Config.LANCache != "" {
+		fileList, err = checksum.FetchCachedFilelist(ctx, lancache.FileListBase(cacheURL, server.PatchURL), cacheDir, false)
+		if err != nil {
+			slog.Warn("lan cache file list failed", "cache", cacheURL, "err", err)
+			fmt.Printf("LAN cache %s could not provide the file list, fetching directly: %v\n", cacheURL, err)
+		}
+	}
+	if fileList == nil {
+		fileList, err = fetchFileList(ctx, server, cacheDir, opts.IsOffline)
+	}
+	if err != nil && !opts.IsOffline && !errors.Is(err, context.Canceled) {
+		// the file list host being down should not stop patching from mirrors
+		cached, cacheErr := checksum.FetchCachedFilelist(ctx, server.PatchURL, cacheDir, true)
+		if cacheErr == nil {
+			slog.Warn("file list fetch failed, using cache", "url", server.PatchURL, "err", err)
+			fmt.Printf("Could not reach %s, using the last file list: %v\n", server.PatchURL, err)
+			fileList, err = cached, nil
+		}
+	}
 	if err != nil {
+		if errors.Is(err, checksum.ErrNoCachedFilelist) {
+			return fmt.Errorf("%s was never patched, connect once before playing offline: %w", server.Name, err)
+		}
 		return fmt.Errorf("fetch patcher filelist: %w", err)
 	}
+	slog.Info("file list", "server", server.ShortName, "version", fileList.Version, "patched", fileList.Meta.PatchedVersion, "modified", fileList.IsModified)
+	// a current version is only skipped while its files are there, the directory may have been wiped since
+	if fileList.IsCurrent() {
+		missing := fileList.Missing(eqPath)
+		if len(missing) == 0 {
+			fmt.Printf("%s is up to date (version %s)\n", server.Name, fileList.Version)
+			return nil
+		}
+		slog.Warn("patched version is missing files", "server", server.ShortName, "version", fileList.Version, "missing", len(missing))
+		fmt.Printf("%s version %s is missing %d files, patching again\n", server.Name, fileList.Version, len(missing))
+	}
+	if fileList.IsRolledBack() {
+		missing := fileList.Missing(eqPath)
+		if len(missing) == 0 {
+			fmt.Printf("Skipping %s version %s, it was rolled back\n", server.Name, fileList.Version)
+			return nil
+		}
+		slog.Warn("rolled back version is missing files", "server", server.ShortName, "version", fileList.Version, "missing", len(missing))
+		fmt.Printf("%s version %s was rolled back, but %d of its files are missing, patching again\n", server.Name, fileList.Version, len(missing))
+	}
 
-	err = patch.Download(fileList, eqPath)
+	err = patch.Run(ctx, patch.Options{
+		FileList:      fileList.FileList,
+		Path:          eqPath,
+		Mirrors:       server.Mirrors,
+		LANCache:      cacheURL,
+		BackupDir:     backupDir,
+		ReferencePath: opts.Config.RoF2Path,
+		ReportFile:    reportFile,
+	})
 	if err != nil {
 		return fmt.Errorf("download: %w", err)
 	}
 
+	err = fileList.MarkPatched()
+	if err != nil {
+		return fmt.Errorf("mark patched: %w", err)
+	}
+
 	return nil
 }
+
+// fetchFileList fetches the file list from the server's patch url, then each of its mirrors in the
+// order files are downloaded from them, returning the first error if none has it
+func fetchFileList(ctx context.Context, server *serverlist.ServerEntry, cacheDir string, isOffline bool) (*checksum.CachedFileList, error) {
+	fileList, err := checksum.FetchCachedFilelist(ctx, server.PatchURL, cacheDir, isOffline)
+	if err == nil || isOffline {
+		return fileList, err
+	}
+	firstErr := err
+	for _, mirror := range patch.NewMirrors(server.Mirrors...).Order() {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if mirror == patch.NormalizeURL(server.PatchURL) {
+			continue
+		}
+		fileList, err = checksum.FetchCachedFilelist(ctx, mirror, cacheDir, false)
+		if err != nil {
+			slog.Warn("mirror file list failed", "mirror", mirror, "err", err)
+			continue
+		}
+		slog.Warn("file list fetch failed, using mirror", "url", server.PatchURL, "mirror", mirror, "err", firstErr)
+		fmt.Printf("Could not reach %s, using the file list from %s\n", server.PatchURL, mirror)
+		return fileList, nil
+	}
+	return nil, firstErr
+}
+
+// lanCacheCheck returns the configured LAN cache, or one found on the network, if it responds
+func lanCacheCheck(ctx context.Context, opts Options) string {
+	if opts.IsOffline {
+		return ""
+	}
+
+	cacheURL := opts.Config.LANCache
+	if cacheURL == "" {
+		var err error
+		cacheURL, err = lancache.Discover(ctx, 500*time.Millisecond)
+		if err != nil {
+			return ""
+		}
+	}
+
+	err := lancache.Ping(ctx, cacheURL)
+	if err != nil {
+		fmt.Printf("Ignoring LAN cache: %v\n", err)
+		return ""
+	}
+	fmt.Println("Using LAN cache", cacheURL)
+	return cacheURL
+}
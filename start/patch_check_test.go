@@ -0,0 +1,51 @@
+package start
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/xackery/rof2plus/serverlist"
+)
+
+func TestFetchFileListMirrors(t *testing.T) {
+	requests := []string{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.URL.Path)
+		if r.URL.Path != "/good/rof2plus_filelist.yml" {
+			http.Error(w, "down", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("version: \"2\"\ndownloadprefix: " + "http://" + r.Host + "/good/\n"))
+	}))
+	defer srv.Close()
+
+	server := &serverlist.ServerEntry{
+		ShortName: "test",
+		PatchURL:  srv.URL + "/patch",
+		Mirrors:   []string{srv.URL + "/bad", srv.URL + "/good", srv.URL + "/unused"},
+	}
+	fileList, err := fetchFileList(context.Background(), server, t.TempDir(), false)
+	if err != nil {
+		t.Fatalf("fetch: %v", err)
+	}
+	if fileList.Version != "2" {
+		t.Fatalf("got version %q", fileList.Version)
+	}
+	expected := []string{"/patch/rof2plus_filelist.yml", "/bad/rof2plus_filelist.yml", "/good/rof2plus_filelist.yml"}
+	if len(requests) != len(expected) {
+		t.Fatalf("got requests %v", requests)
+	}
+	for i := range expected {
+		if requests[i] != expected[i] {
+			t.Fatalf("got requests %v", requests)
+		}
+	}
+
+	server.Mirrors = []string{srv.URL + "/bad"}
+	_, err = fetchFileList(context.Background(), server, t.TempDir(), false)
+	if err == nil {
+		t.Fatalf("expected error when no mirror has the file list")
+	}
+}
@@ -1,14 +1,15 @@
 package start
 
 import (
+	"context"
 	"fmt"
 	"io"
-	"strings"
 
+	"github.com/xackery/rof2plus/prompt"
 	"github.com/xackery/rof2plus/serverlist"
 )
 
-func selectServer(serverName string) (*serverlist.ServerEntry, error) {
+func selectServer(ctx context.Context, serverName string) (*serverlist.ServerEntry, error) {
 	var err error
 	if serverName != "" {
 		serverEntry, err := selectServerAttempt(serverName)
@@ -18,8 +19,11 @@ func selectServer(serverName string) (*serverlist.ServerEntry, error) {
 	}
 	isServerChosen := false
 	for !isServerChosen {
-		serverName, err = selectServerPrompt()
+		serverName, err = selectServerPrompt(ctx)
 		if err != nil {
+			if ctx.Err() != nil {
+				return nil, err
+			}
 			fmt.Println(err)
 			continue
 		}
@@ -45,14 +49,13 @@ func selectServerAttempt(serverName string) (*serverlist.ServerEntry, error) {
 	return server, nil
 }
 
-func selectServerPrompt() (string, error) {
+func selectServerPrompt(ctx context.Context) (string, error) {
 	servers := serverlist.Servers()
 	for _, server := range servers {
 		fmt.Printf("Server: %s\n", server.ShortName)
 	}
 	fmt.Printf("Please select a server to connect to: ")
-	var selectedServer string
-	_, err := fmt.Scanln(&selectedServer)
+	selectedServer, err := prompt.ReadLine(ctx)
 	if err != nil {
 		if err == io.EOF {
 			return "", nil
@@ -60,7 +63,6 @@ func selectServerPrompt() (string, error) {
 
 		return "", fmt.Errorf("scan server: %w", err)
 	}
-	selectedServer = strings.TrimSpace(selectedServer)
 	if selectedServer == "" {
 		return "", fmt.Errorf("invalid server name")
 	}
@@ -4,35 +4,60 @@ package start
 import (
 	"context"
 	"fmt"
+	"log/slog"
 
 	"github.com/xackery/rof2plus/config"
+	"github.com/xackery/rof2plus/paths"
 	"github.com/xackery/rof2plus/serverlist"
 )
 
-// Start begins the program process
-func Start(serverName string) error {
+// Options is everything a start run depends on
+type Options struct {
+	// Paths are where config, clients and servers are kept
+	Paths *paths.Paths
+	// Config holds the vanilla client locations and is saved as they change
+	Config *config.Config
+	// ServerName selects a server without prompting when set
+	ServerName string
+	// IsOffline uses cached file lists instead of contacting patch servers
+	IsOffline bool
+}
 
-	_, err := config.New(context.Background(), "rof2plus")
+// Start loads the config found in p and begins the program process, stopping when ctx is done
+func Start(ctx context.Context, p *paths.Paths, serverName string, isOffline bool) error {
+	err := p.Ensure()
 	if err != nil {
-		return fmt.Errorf("config.New: %w", err)
+		return fmt.Errorf("paths: %w", err)
 	}
 
-	err = installCheck()
+	cfg, err := config.New(ctx, p.ConfigFile())
 	if err != nil {
-		return fmt.Errorf("installCheck: %w", err)
+		return fmt.Errorf("config.New: %w", err)
+	}
+
+	return Run(ctx, Options{Paths: p, Config: cfg, ServerName: serverName, IsOffline: isOffline})
+}
+
+// Run begins the program process with opts
+func Run(ctx context.Context, opts Options) error {
+	if opts.Paths == nil {
+		return fmt.Errorf("paths are not set")
+	}
+	if opts.Config == nil {
+		return fmt.Errorf("config is not set")
 	}
 
-	err = vanillaCheck()
+	err := installCheck(ctx)
 	if err != nil {
-		return fmt.Errorf("vanillaCheck: %w", err)
+		return fmt.Errorf("installCheck: %w", err)
 	}
 
-	err = serverlist.Fetch()
+	err = serverlist.Fetch(opts.Paths.ServerListFile())
 	if err != nil {
 		return fmt.Errorf("serverlist.fetch: %w", err)
 	}
 
-	server, err := selectServer(serverName)
+	server, err := selectServer(ctx, opts.ServerName)
 	if err != nil {
 		return fmt.Errorf("selectServer: %w", err)
 	}
@@ -41,13 +66,24 @@ func Start(serverName string) error {
 		return fmt.Errorf("no server selected")
 	}
 
+	slog.Info("selected server", "server", server.ShortName, "patch_url", server.PatchURL)
 	fmt.Printf("Selected server: %s\n", server.Name)
-	err = patchCheck(server)
+	err = vanillaCheck(ctx, opts, server)
+	if err != nil {
+		return fmt.Errorf("vanillaCheck: %w", err)
+	}
+
+	err = patchCheck(ctx, opts, server)
 	if err != nil {
 		return fmt.Errorf("patch: %w", err)
 	}
 
-	err = launch(server)
+	err = eqhostCheck(opts.Paths, server)
+	if err != nil {
+		return fmt.Errorf("eqhostCheck: %w", err)
+	}
+
+	err = launch(opts.Paths, server)
 	if err != nil {
 		return fmt.Errorf("launch: %w", err)
 	}
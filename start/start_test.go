@@ -0,0 +1,75 @@
+package start
+
+import (
+	"context"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/xackery/rof2plus/config"
+	"github.com/xackery/rof2plus/paths"
+	"github.com/xackery/rof2plus/serverlist"
+)
+
+func testOptions(t *testing.T) Options {
+	t.Helper()
+	p := paths.Portable(t.TempDir())
+	err := p.Ensure()
+	if err != nil {
+		t.Fatalf("ensure: %v", err)
+	}
+	cfg, err := config.New(context.Background(), p.ConfigFile())
+	if err != nil {
+		t.Fatalf("config: %v", err)
+	}
+	return Options{Paths: p, Config: cfg}
+}
+
+func TestVanillaCheckIndependentConfigs(t *testing.T) {
+	rof2 := testOptions(t)
+	rof2.Config.RoF2Path = t.TempDir()
+	ls := testOptions(t)
+	ls.Config.LSPath = t.TempDir()
+
+	runs := []struct {
+		opts   Options
+		server *serverlist.ServerEntry
+	}{
+		{opts: rof2, server: &serverlist.ServerEntry{ShortName: "a", Client: "rof2"}},
+		{opts: ls, server: &serverlist.ServerEntry{ShortName: "b", Client: "ls"}},
+	}
+
+	wg := sync.WaitGroup{}
+	errs := make([]error, len(runs))
+	for i, run := range runs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = vanillaCheck(context.Background(), run.opts, run.server)
+		}()
+	}
+	wg.Wait()
+	for i, err := range errs {
+		if err != nil {
+			t.Fatalf("run %d: %v", i, err)
+		}
+	}
+
+	if rof2.Config.LSPath != "" || ls.Config.RoF2Path != "" {
+		t.Fatalf("configs leaked into each other: %+v %+v", rof2.Config, ls.Config)
+	}
+	if rof2.Config.Path() == ls.Config.Path() || filepath.Dir(rof2.Config.Path()) != rof2.Paths.Config {
+		t.Fatalf("unexpected config paths %s %s", rof2.Config.Path(), ls.Config.Path())
+	}
+}
+
+func TestRunRequiresOptions(t *testing.T) {
+	err := Run(context.Background(), Options{})
+	if err == nil {
+		t.Fatalf("expected missing paths error")
+	}
+	err = Run(context.Background(), Options{Paths: paths.Portable(t.TempDir())})
+	if err == nil {
+		t.Fatalf("expected missing config error")
+	}
+}
@@ -0,0 +1,49 @@
+package start
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/xackery/rof2plus/prompt"
+	"github.com/xackery/rof2plus/steam"
+)
+
+// SteamPath returns the first EverQuest depot download folder found in any Steam library
+func SteamPath() (string, error) {
+	loc, err := steam.Locate()
+	if err != nil {
+		return "", fmt.Errorf("locate: %w", err)
+	}
+	if len(loc.Depots) == 0 {
+		return "", fmt.Errorf("depot path not found in %d steam libraries", len(loc.Libraries))
+	}
+	return loc.Depots[0], nil
+}
+
+// promptSteamCopy offers EverQuest copies found in Steam libraries, returning the chosen path or empty
+func promptSteamCopy(ctx context.Context, name string) (string, error) {
+	loc, err := steam.Locate()
+	if err != nil {
+		return "", nil
+	}
+
+	candidates := []string{}
+	candidates = append(candidates, loc.Depots...)
+	for _, install := range loc.Installs {
+		candidates = append(candidates, install.InstallPath())
+	}
+
+	for _, candidate := range candidates {
+		fmt.Printf("I found an EverQuest copy in your Steam library at %s\n", candidate)
+		fmt.Printf("Is this a vanilla copy of %s? (y/n) ", name)
+		answer, err := prompt.ReadLine(ctx)
+		if err != nil {
+			return "", fmt.Errorf("scan: %w", err)
+		}
+		if strings.ToLower(answer) == "y" {
+			return candidate, nil
+		}
+	}
+	return "", nil
+}
@@ -1,84 +1,120 @@
 package start
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"log/slog"
 	"os"
 	"os/exec"
-	"path/filepath"
 	"runtime"
 	"strings"
-	"time"
 
 	"github.com/xackery/rof2plus/check"
 	"github.com/xackery/rof2plus/checksum"
 	"github.com/xackery/rof2plus/config"
+	"github.com/xackery/rof2plus/diskspace"
+	"github.com/xackery/rof2plus/prompt"
+	"github.com/xackery/rof2plus/relocate"
+	"github.com/xackery/rof2plus/serverlist"
 )
 
-// vanillaCheck checks if rof2 and ls are properly set, installed,
+// vanillaCheck checks if the base client the server uses is properly set, installed,
 // and walks through process if not
-func vanillaCheck() error {
-	err := checkVanillaClient("rof2")
+func vanillaCheck(ctx context.Context, opts Options, server *serverlist.ServerEntry) error {
+	client, err := server.BaseClient()
 	if err != nil {
-		return fmt.Errorf("checkRoF2: %w", err)
+		return fmt.Errorf("base client: %w", err)
 	}
-	err = checkVanillaClient("ls")
+
+	err = checkVanillaClient(ctx, opts, client)
 	if err != nil {
-		return fmt.Errorf("checkLS: %w", err)
+		return fmt.Errorf("check %s: %w", client.String(), err)
 	}
 
 	return nil
 }
 
-func checkVanillaClient(client string) error {
-	cfg := config.Get()
-	path := ""
+// clientPath returns the configured path for a base client
+func clientPath(cfg *config.Config, client checksum.ChecksumClient) string {
 	switch client {
-	case "rof2":
-		path = cfg.RoF2Path
-	case "ls":
-		path = cfg.LSPath
+	case checksum.ClientRoF2:
+		return cfg.RoF2Path
+	case checksum.ClientRoF2Core:
+		return cfg.RoF2CorePath
+	case checksum.ClientLS:
+		return cfg.LSPath
 	}
+	return ""
+}
 
-	if path != "" {
-		fi, err := os.Stat(path)
-		if err != nil {
-			if !os.IsNotExist(err) {
-				return fmt.Errorf("stat: %w", err)
-			}
-			path = ""
-		}
-		if fi != nil && !fi.IsDir() {
-			path = ""
-		}
+// setClientPath sets the configured path for a base client
+func setClientPath(cfg *config.Config, client checksum.ChecksumClient, path string) {
+	switch client {
+	case checksum.ClientRoF2:
+		cfg.RoF2Path = path
+	case checksum.ClientRoF2Core:
+		cfg.RoF2CorePath = path
+	case checksum.ClientLS:
+		cfg.LSPath = path
 	}
+}
 
-	if path != "" {
+// isDir returns true if path exists and is a directory
+func isDir(path string) bool {
+	if path == "" {
+		return false
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		return false
+	}
+	return fi.IsDir()
+}
+
+func checkVanillaClient(ctx context.Context, opts Options, client checksum.ChecksumClient) error {
+	cfg := opts.Config
+	p := opts.Paths
+	name := client.String()
+	path := clientPath(cfg, client)
+
+	if isDir(path) {
 		return nil
 	}
 
-	fmt.Printf("I do not see %s installed. Do you have a vanilla copy of %s? (y/n) ", client, client)
+	// a full rof2 install is a superset of rof2core
+	if client == checksum.ClientRoF2Core && isDir(cfg.RoF2Path) {
+		fmt.Printf("Using your rof2 installation at %s for %s\n", cfg.RoF2Path, name)
+		return saveVanillaClient(ctx, opts, client, cfg.RoF2Path)
+	}
 
-	var answer string
-	_, err := fmt.Scanln(&answer)
+	path, err := promptSteamCopy(ctx, name)
 	if err != nil {
-		return fmt.Errorf("scan: %w", err)
+		return fmt.Errorf("steam copy: %w", err)
 	}
 
-	answer = strings.TrimSpace(answer)
-	if answer == "" {
-		return fmt.Errorf("invalid answer")
+	var answer string
+	if path == "" {
+		fmt.Printf("I do not see %s installed. Do you have a vanilla copy of %s? (y/n) ", name, name)
+
+		answer, err = prompt.ReadLine(ctx)
+		if err != nil {
+			return fmt.Errorf("scan: %w", err)
+		}
+
+		if answer == "" {
+			return fmt.Errorf("invalid answer")
+		}
 	}
 
 	if strings.ToLower(answer) == "y" {
 		isClientPathProvided := false
 		for !isClientPathProvided {
-			fmt.Printf("Please enter the path to your %s installation: ", client)
-			_, err := fmt.Scanln(&path)
+			fmt.Printf("Please enter the path to your %s installation: ", name)
+			path, err = prompt.ReadLine(ctx)
 			if err != nil {
-				fmt.Printf("scan path: %s", err)
-				continue
+				return fmt.Errorf("scan path: %w", err)
 			}
-			path = strings.TrimSpace(path)
 			if path == "" {
 				fmt.Println("You must provide a valid path")
 				continue
@@ -105,14 +141,12 @@ func checkVanillaClient(client string) error {
 	}
 
 	if strings.ToLower(answer) == "n" {
-		fmt.Printf("You can install %s from Steam using the steam console.\n", client)
+		fmt.Printf("You can install %s from Steam using the steam console.\n", name)
 		fmt.Printf("Would you like me to open the console for you? (y/n) ")
-		var answer string
-		_, err := fmt.Scanln(&answer)
+		answer, err := prompt.ReadLine(ctx)
 		if err != nil {
 			return fmt.Errorf("scan: %w", err)
 		}
-		answer = strings.TrimSpace(answer)
 		if answer == "" {
 			return fmt.Errorf("invalid answer")
 		}
@@ -133,107 +167,104 @@ func checkVanillaClient(client string) error {
 				return fmt.Errorf("unsupported OS: %s", runtime.GOOS)
 			}
 
+			// rof2core is carved out of a full rof2 depot download
 			depotCommand := "download_depot 205710 205711 1926608638440811669"
-			if client == "ls" {
+			if client == checksum.ClientLS {
 				depotCommand = "download_depot 205710 205711 5852850381673064693"
 			}
 			fmt.Printf("Please enter the following command to the console:\n%s\n", depotCommand)
 			fmt.Println("I'll watch for steam to start downloading...")
 
-			chkClient := checksum.ClientRoF2
-			if client == "ls" {
-				chkClient = checksum.ClientLS
+			depotClient := client
+			if client == checksum.ClientRoF2Core {
+				depotClient = checksum.ClientRoF2
 			}
-			path, err = monitorDepotDownload(chkClient)
+			path, err = monitorDepotDownload(ctx, depotClient)
 			if err != nil {
 				return fmt.Errorf("monitor depot: %w", err)
 			}
 
-			fmt.Println("Download complete!", client, "files are ready")
+			fmt.Println("Download complete!", name, "files are ready")
+
+			identity, err := checksum.Identify(path)
+			if err != nil {
+				return fmt.Errorf("identify: %w", err)
+			}
+			if identity.Build != depotClient.String() {
+				fmt.Printf("Warning: the downloaded files look like %s, not %s\n", identity, depotClient.String())
+			}
 
 		}
 	}
 
 	if strings.Contains(path, "steamapp") {
-		fmt.Printf("It looks like your %s path is in steamapps.\n", client)
-		fmt.Printf("I can move it to rof2plus\\%s. This is recommended.\n", client)
+		fmt.Printf("It looks like your %s path is in steamapps.\n", name)
+		if client == checksum.ClientRoF2Core {
+			fmt.Printf("I can copy only the files %s needs to %s, a minimal install. This is recommended.\n", name, p.ClientDir(name))
+		} else {
+			fmt.Printf("I can move it to %s. This is recommended.\n", p.ClientDir(name))
+		}
 		fmt.Printf("Would you like me to do this? (y/n) ")
-		var answer string
-		_, err := fmt.Scanln(&answer)
+		answer, err := prompt.ReadLine(ctx)
 		if err != nil {
 			return fmt.Errorf("scan: %w", err)
 		}
-		answer = strings.TrimSpace(answer)
 		if answer == "" {
 			return fmt.Errorf("invalid answer")
 		}
 		if strings.ToLower(answer) == "y" {
-
-			fmt.Printf("Moving depot files to %s directory...\n", client)
-			err = os.Rename(path, "./"+client)
+			path, err = relocateClient(ctx, client, path, p.ClientDir(name))
 			if err != nil {
-				return fmt.Errorf("rename: %w", err)
+				return fmt.Errorf("relocate: %w", err)
 			}
 			fmt.Println("Done")
 		}
-		path = client
 	}
 
-	switch client {
-	case "rof2":
-		cfg.RoF2Path = path
-	case "ls":
-		cfg.LSPath = path
-	}
+	return saveVanillaClient(ctx, opts, client, path)
+}
+
+// saveVanillaClient stores path for client and validates it, prompting again if invalid
+func saveVanillaClient(ctx context.Context, opts Options, client checksum.ChecksumClient, path string) error {
+	cfg := opts.Config
+	setClientPath(cfg, client, path)
 
-	err = config.Get().Save()
+	err := cfg.Save()
 	if err != nil {
 		return fmt.Errorf("save config: %w", err)
 	}
 
-	err = validateVanillaClient(client, path)
+	err = validateVanillaClient(ctx, client, path)
 	if err != nil {
-		switch client {
-		case "rof2":
-			cfg.RoF2Path = ""
-		case "ls":
-			cfg.LSPath = ""
-		}
-		config.Get().Save()
-		return checkVanillaClient(client)
-
+		setClientPath(cfg, client, "")
+		cfg.Save()
+		return checkVanillaClient(ctx, opts, client)
 	}
 
 	return nil
 }
 
-func validateVanillaClient(client string, path string) error {
-	if client != "rof2" && client != "ls" {
+func validateVanillaClient(ctx context.Context, client checksum.ChecksumClient, path string) error {
+	if client != checksum.ClientRoF2 && client != checksum.ClientRoF2Core && client != checksum.ClientLS {
 		return fmt.Errorf("invalid client")
 	}
 
-	cl := checksum.ClientRoF2
-	if client == "ls" {
-		cl = checksum.ClientLS
+	manifest, err := checksum.Manifest(client)
+	if err != nil {
+		return fmt.Errorf("manifest: %w", err)
 	}
-	checksum.SetClientLimit(true)
 
-	err := check.Check(cl, path)
+	report, err := check.Run(ctx, check.Options{Client: client, Path: path, Manifest: manifest})
 	if err != nil {
 		return fmt.Errorf("check: %w", err)
 	}
 
-	report := check.Report()
-	if report == nil {
-		fmt.Println("Client is valid")
-		return nil
-	}
-
 	if report.FailTotal > 0 {
 		firstFail := report.Failures[0]
 		if len(report.Failures) > 3 {
 
-			fmt.Printf("Client %s is invalid, %d files failed.\n", client, report.FailTotal)
+			slog.Warn("vanilla client invalid", "client", client.String(), "path", path, "failed", report.FailTotal, "first", firstFail)
+			fmt.Printf("Client %s is invalid, %d files failed.\n", client.String(), report.FailTotal)
 			fmt.Println("First failed file:", firstFail)
 			fmt.Println("Please verify your installation")
 			return fmt.Errorf("client is invalid")
@@ -244,107 +275,80 @@ func validateVanillaClient(client string, path string) error {
 	return nil
 }
 
-// monitorDepotDownload checks if depotPath exists and monitors for xul.dll to reach >1MB
-func monitorDepotDownload(client checksum.ChecksumClient) (string, error) {
-	var err error
-	depotPath := ""
+// relocateClient moves a steamapps copy of client to dst, or for rof2core copies only the files it needs.
+// It returns the new path of the client
+func relocateClient(ctx context.Context, client checksum.ChecksumClient, src string, dst string) (string, error) {
+	manifest, err := checksum.Manifest(client)
+	if err != nil {
+		return "", fmt.Errorf("manifest: %w", err)
+	}
 
-	isWaiting := false
-	// Check if directory exists, wait if it doesn't
-	for {
-		depotPath, err = SteamPath()
-		if err != nil {
-			if isWaiting {
-				fmt.Printf(".")
-				time.Sleep(6 * time.Second)
-				continue
-			}
-			time.Sleep(12 * time.Second)
-			continue
+	lastPercent := int64(-10)
+	progress := func(done int64, total int64) {
+		percent := done * 100 / max(total, 1)
+		if percent/10 == lastPercent/10 {
+			return
 		}
-		break
+		lastPercent = percent
+		fmt.Printf("Progress: %d%% (%s / %s)\n", percent, byteSize(done), byteSize(total))
 	}
 
-	fmt.Println("Depot path:", depotPath)
-	fmt.Println("Download started, I'll report key percents updates until finished")
-
-	// Monitor for xul.dll with size > 1MB
-	type watchFileEntry struct {
-		name           string
-		progressReport int
+	var result *relocate.Result
+	if client == checksum.ClientRoF2Core {
+		fmt.Printf("Copying %s files to %s directory...\n", client.String(), dst)
+		result, err = relocate.CopyManifest(ctx, src, dst, manifest, progress)
+	} else {
+		fmt.Printf("Moving depot files to %s directory...\n", dst)
+		result, err = relocate.Move(ctx, src, dst, manifest, progress)
+		if errors.Is(err, relocate.ErrMismatch) {
+			result, err = confirmMismatches(ctx, client, src, dst, result, progress)
+		}
 	}
-
-	knownProgress := 0
-	watchFiles := []watchFileEntry{
-		{name: "10annvshield.eqg", progressReport: 1},
-		{name: "B09.eqg", progressReport: 2},
-		{name: "cabeast.s3d", progressReport: 5},
-		{name: "cobaltscar_sounds.eff", progressReport: 6},
-		{name: "crystalshard_obj.s3d", progressReport: 7},
-		{name: "drachnidhivec.eqg", progressReport: 8},
-		{name: "eastkorlach.eqg", progressReport: 9},
-		{name: "eviltree.emt", progressReport: 10},
-		{name: "frd.eqg", progressReport: 11},
-		{name: "furniture09.eqg", progressReport: 12},
-		{name: "global_chr.s3d", progressReport: 15},
-		{name: "guildlobby.eqg", progressReport: 18},
-		{name: "hole.s3d", progressReport: 20},
-		{name: "iceclad.s3d", progressReport: 23},
-		{name: "karnor.s3d", progressReport: 25},
-		{name: "kerraridge.s3d", progressReport: 30},
-		{name: "load_obj.s3d", progressReport: 35},
-		{name: "maiden.s3d", progressReport: 38},
-		{name: "mistythicket.eqg", progressReport: 40},
-		{name: "northro.eqg", progressReport: 43},
-		{name: "nro.s3d", progressReport: 45},
-		{name: "oggok.s3d", progressReport: 48},
-		{name: "paineel.s3d", progressReport: 50},
-		{name: "potactics.s3d", progressReport: 55},
-		{name: "powater.s3d", progressReport: 58},
-		{name: "qeynos2.s3d", progressReport: 60},
-		{name: "qeytoqrg.s3d", progressReport: 63},
-		{name: "runnyeye.s3d", progressReport: 65},
-		{name: "sirens.s3d", progressReport: 68},
-		{name: "skyfire.s3d", progressReport: 70},
-		{name: "soldungb.s3d", progressReport: 73},
-		{name: "southkarana.s3d", progressReport: 75},
-		{name: "swampofnohope.s3d", progressReport: 80},
-		{name: "tenebrous.s3d", progressReport: 83},
-		{name: "thurgadina.s3d", progressReport: 85},
-		{name: "tutorialb.s3d", progressReport: 90},
-		{name: "velketor.s3d", progressReport: 93},
-		{name: "wallofslaughter.eqg", progressReport: 95},
-		{name: "xorbb.eqg", progressReport: 98},
-		{name: "zonein.eqg", progressReport: 100},
+	if err != nil {
+		if result != nil && ctx.Err() != nil {
+			fmt.Printf("Stopped after copying %d files (%s)\n", result.Files, byteSize(result.Bytes))
+		}
+		if errors.Is(err, diskspace.ErrInsufficient) {
+			return "", fmt.Errorf("%w (free up space and run again to resume)", err)
+		}
+		return "", fmt.Errorf("%w (run again to resume)", err)
+	}
+	slog.Info("relocated client", "client", client.String(), "src", src, "dst", dst, "renamed", result.Renamed, "files", result.Files, "skipped", result.Skipped, "mismatches", len(result.Mismatches))
+	for _, warning := range result.Warnings {
+		fmt.Println(warning)
+	}
+	if len(result.Mismatches) > 0 {
+		fmt.Printf("Note %d files differ from the %s manifest, first: %s\n", len(result.Mismatches), client.String(), result.Mismatches[0])
 	}
 
-	for {
-		for _, watchFile := range watchFiles {
-			if knownProgress >= watchFile.progressReport {
-				continue
-			}
-
-			filePath := filepath.Join(depotPath, watchFile.name)
-			fi, err := os.Stat(filePath)
-			if err != nil {
-				time.Sleep(2 * time.Second)
-				break
-			}
-
-			if fi.Size() < checksum.FileSize(client, filepath.Base(filePath))-1000 {
-				break
-			}
+	return dst, nil
+}
 
-			knownProgress = watchFile.progressReport
-			fmt.Println("Download progress:", knownProgress, "%")
-			if knownProgress == 100 {
-				break
-			}
-		}
-		if knownProgress == 100 {
+// confirmMismatches asks the player whether to finish a move whose copies differ from the manifest,
+// which removes the source. Declining keeps both folders
+func confirmMismatches(ctx context.Context, client checksum.ChecksumClient, src string, dst string, result *relocate.Result, progress func(done int64, total int64)) (*relocate.Result, error) {
+	slog.Warn("relocated files differ from manifest", "client", client.String(), "src", src, "mismatches", result.Mismatches)
+	fmt.Printf("%d copied files differ from the %s manifest:\n", len(result.Mismatches), client.String())
+	for i, name := range result.Mismatches {
+		if i == 5 {
+			fmt.Printf("  ...and %d more\n", len(result.Mismatches)-i)
 			break
 		}
-		time.Sleep(2 * time.Second)
+		fmt.Println(" ", name)
+	}
+	isConfirmed, err := prompt.Confirm(ctx, fmt.Sprintf("Remove %s anyway?", src))
+	if err != nil {
+		return result, err
+	}
+	if !isConfirmed {
+		return result, fmt.Errorf("kept %s, %d files differ from the %s manifest", src, len(result.Mismatches), client.String())
+	}
+
+	mismatches := result.Mismatches
+	result, err = relocate.Move(ctx, src, dst, nil, progress)
+	if err != nil {
+		return result, err
 	}
-	return depotPath, nil
+	result.Mismatches = mismatches
+	return result, nil
 }
@@ -0,0 +1,227 @@
+// steam locates Steam libraries, app manifests and depot downloads for EverQuest
+package steam
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// AppEverQuest is the Steam app id of EverQuest
+	AppEverQuest = "205710"
+	// DepotEverQuest is the Steam depot id holding the EverQuest client files
+	DepotEverQuest = "205711"
+)
+
+// AppManifest is a parsed steamapps/appmanifest_<appid>.acf
+type AppManifest struct {
+	AppID           string
+	Name            string
+	InstallDir      string
+	StateFlags      string
+	InstalledDepots map[string]*InstalledDepot
+	// Library is the library folder the manifest was found in
+	Library string
+}
+
+// InstalledDepot is a depot entry of an app manifest
+type InstalledDepot struct {
+	Manifest string
+	Size     string
+}
+
+// InstallPath returns the steamapps/common directory the app is installed to
+func (e *AppManifest) InstallPath() string {
+	return filepath.Join(e.Library, "steamapps", "common", e.InstallDir)
+}
+
+// Libraries returns every library folder known to the Steam install at root, root included
+func Libraries(root string) ([]string, error) {
+	libraries := []string{}
+	seen := map[string]bool{}
+	add := func(path string) {
+		path = filepath.Clean(path)
+		key := libraryKey(path)
+		if seen[key] {
+			return
+		}
+		seen[key] = true
+		libraries = append(libraries, path)
+	}
+	add(root)
+
+	for _, vdfPath := range []string{
+		filepath.Join(root, "steamapps", "libraryfolders.vdf"),
+		filepath.Join(root, "config", "libraryfolders.vdf"),
+	} {
+		doc, err := ParseVDFFile(vdfPath)
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+			return libraries, fmt.Errorf("parse %s: %w", vdfPath, err)
+		}
+
+		folders := doc.Child("libraryfolders")
+		if folders == nil {
+			// older steam clients capitalized the root key
+			folders = doc.Child("LibraryFolders")
+		}
+		for _, folder := range folders.childrenOrNil() {
+			if folder.Value != "" {
+				// legacy format: "1" "D:\\SteamLibrary"
+				if isNumeric(folder.Key) {
+					add(folder.Value)
+				}
+				continue
+			}
+			path := folder.Get("path")
+			if path == "" {
+				continue
+			}
+			add(path)
+		}
+	}
+
+	return libraries, nil
+}
+
+// ReadAppManifest parses the app manifest for appID in library, e.g. steamapps/appmanifest_205710.acf
+func ReadAppManifest(library string, appID string) (*AppManifest, error) {
+	path := filepath.Join(library, "steamapps", fmt.Sprintf("appmanifest_%s.acf", appID))
+	doc, err := ParseVDFFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+
+	state := doc.Child("AppState")
+	if state == nil {
+		return nil, fmt.Errorf("parse %s: missing AppState", path)
+	}
+
+	manifest := &AppManifest{
+		AppID:           state.Get("appid"),
+		Name:            state.Get("name"),
+		InstallDir:      state.Get("installdir"),
+		StateFlags:      state.Get("StateFlags"),
+		InstalledDepots: make(map[string]*InstalledDepot),
+		Library:         library,
+	}
+	for _, depot := range state.Child("InstalledDepots").childrenOrNil() {
+		manifest.InstalledDepots[depot.Key] = &InstalledDepot{
+			Manifest: depot.Get("manifest"),
+			Size:     depot.Get("size"),
+		}
+	}
+	return manifest, nil
+}
+
+// DepotPaths returns every existing depot download folder, steamapps/content/app_<appID>/depot_<depotID>, across libraries
+func DepotPaths(libraries []string, appID string, depotID string) []string {
+	paths := []string{}
+	for _, library := range libraries {
+		path := filepath.Join(library, "steamapps", "content", "app_"+appID, "depot_"+depotID)
+		if !isDir(path) {
+			continue
+		}
+		paths = append(paths, path)
+	}
+	return paths
+}
+
+// Installs returns the app manifest of every library that has appID installed
+func Installs(libraries []string, appID string) []*AppManifest {
+	installs := []*AppManifest{}
+	for _, library := range libraries {
+		manifest, err := ReadAppManifest(library, appID)
+		if err != nil {
+			continue
+		}
+		if manifest.InstallDir == "" || !isDir(manifest.InstallPath()) {
+			continue
+		}
+		installs = append(installs, manifest)
+	}
+	return installs
+}
+
+// Locations is the result of scanning every Steam library for EverQuest
+type Locations struct {
+	Libraries []string
+	// Depots are download_depot folders for DepotEverQuest
+	Depots []string
+	// Installs are EverQuest copies installed through the Steam library
+	Installs []*AppManifest
+}
+
+// Locate scans every library of every Steam root found on the system for EverQuest
+func Locate() (*Locations, error) {
+	roots := Roots()
+	if len(roots) == 0 {
+		return nil, fmt.Errorf("steam path not found on this system")
+	}
+	return LocateFrom(roots...)
+}
+
+// LocateFrom scans every library of the provided Steam roots for EverQuest
+func LocateFrom(roots ...string) (*Locations, error) {
+	loc := &Locations{}
+	seen := map[string]bool{}
+	for _, root := range roots {
+		libraries, err := Libraries(root)
+		if err != nil {
+			return nil, fmt.Errorf("libraries: %w", err)
+		}
+		for _, library := range libraries {
+			key := libraryKey(library)
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			loc.Libraries = append(loc.Libraries, library)
+		}
+	}
+
+	loc.Depots = DepotPaths(loc.Libraries, AppEverQuest, DepotEverQuest)
+	loc.Installs = Installs(loc.Libraries, AppEverQuest)
+	return loc, nil
+}
+
+func (e *KeyValue) childrenOrNil() []*KeyValue {
+	if e == nil {
+		return nil
+	}
+	return e.Children
+}
+
+// libraryKey returns a comparable form of a library path, windows paths are case-insensitive
+func libraryKey(path string) string {
+	if filepath.Separator == '\\' {
+		return strings.ToLower(path)
+	}
+	return path
+}
+
+func isDir(path string) bool {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return false
+	}
+	return fi.IsDir()
+}
+
+func isNumeric(value string) bool {
+	if value == "" {
+		return false
+	}
+	for _, c := range value {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
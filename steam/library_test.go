@@ -0,0 +1,108 @@
+package steam
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeFixture copies a testdata fixture to dst, replacing {{ROOT}} and {{LIBRARY}} placeholders
+func writeFixture(t *testing.T, name string, dst string, root string, library string) {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatalf("read fixture: %v", err)
+	}
+	escape := strings.NewReplacer(`\`, `\\`)
+	content := strings.NewReplacer("{{ROOT}}", escape.Replace(root), "{{LIBRARY}}", escape.Replace(library)).Replace(string(data))
+	err = os.MkdirAll(filepath.Dir(dst), 0755)
+	if err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	err = os.WriteFile(dst, []byte(content), 0644)
+	if err != nil {
+		t.Fatalf("write fixture: %v", err)
+	}
+}
+
+func mkdir(t *testing.T, path string) {
+	t.Helper()
+	err := os.MkdirAll(path, 0755)
+	if err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+}
+
+func TestLocateFrom(t *testing.T) {
+	root := filepath.Join(t.TempDir(), "Steam")
+	library := filepath.Join(t.TempDir(), "SteamLibrary")
+
+	writeFixture(t, "libraryfolders.vdf", filepath.Join(root, "steamapps", "libraryfolders.vdf"), root, library)
+	writeFixture(t, "appmanifest_205710.acf", filepath.Join(library, "steamapps", "appmanifest_205710.acf"), root, library)
+	mkdir(t, filepath.Join(library, "steamapps", "common", "EverQuest"))
+	mkdir(t, filepath.Join(library, "steamapps", "content", "app_205710", "depot_205711"))
+
+	loc, err := LocateFrom(root)
+	if err != nil {
+		t.Fatalf("locate: %v", err)
+	}
+
+	if len(loc.Libraries) != 2 {
+		t.Fatalf("libraries: got %v", loc.Libraries)
+	}
+	if loc.Libraries[0] != root || loc.Libraries[1] != library {
+		t.Fatalf("libraries: got %v", loc.Libraries)
+	}
+
+	if len(loc.Depots) != 1 || loc.Depots[0] != filepath.Join(library, "steamapps", "content", "app_205710", "depot_205711") {
+		t.Fatalf("depots: got %v", loc.Depots)
+	}
+
+	if len(loc.Installs) != 1 {
+		t.Fatalf("installs: got %d", len(loc.Installs))
+	}
+	install := loc.Installs[0]
+	if install.InstallPath() != filepath.Join(library, "steamapps", "common", "EverQuest") {
+		t.Fatalf("install path: got %s", install.InstallPath())
+	}
+	depot, ok := install.InstalledDepots[DepotEverQuest]
+	if !ok || depot.Manifest != "5852850381673064693" {
+		t.Fatalf("installed depot: got %+v", depot)
+	}
+}
+
+func TestLibrariesLegacy(t *testing.T) {
+	root := filepath.Join(t.TempDir(), "Steam")
+	library := filepath.Join(t.TempDir(), "SteamLibrary")
+
+	writeFixture(t, "libraryfolders_legacy.vdf", filepath.Join(root, "steamapps", "libraryfolders.vdf"), root, library)
+
+	libraries, err := Libraries(root)
+	if err != nil {
+		t.Fatalf("libraries: %v", err)
+	}
+	if len(libraries) != 2 || libraries[1] != library {
+		t.Fatalf("libraries: got %v", libraries)
+	}
+}
+
+func TestLibrariesMissingVDF(t *testing.T) {
+	root := t.TempDir()
+
+	libraries, err := Libraries(root)
+	if err != nil {
+		t.Fatalf("libraries: %v", err)
+	}
+	if len(libraries) != 1 || libraries[0] != root {
+		t.Fatalf("libraries: got %v", libraries)
+	}
+
+	loc, err := LocateFrom(root)
+	if err != nil {
+		t.Fatalf("locate: %v", err)
+	}
+	if len(loc.Depots) != 0 || len(loc.Installs) != 0 {
+		t.Fatalf("expected nothing found, got %+v", loc)
+	}
+}
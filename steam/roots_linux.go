@@ -0,0 +1,25 @@
+package steam
+
+import (
+	"os"
+)
+
+// Roots returns the Steam installation folders found on Linux
+func Roots() []string {
+	paths := []string{
+		"$HOME/.steam/steam",
+		"$HOME/.steam/steam/ubuntu12_32",
+		"$HOME/.local/share/Steam",
+		"$HOME/.var/app/com.valvesoftware.Steam/data/Steam",
+	}
+
+	roots := []string{}
+	for _, path := range paths {
+		expandedPath := os.ExpandEnv(path)
+		if !isDir(expandedPath) {
+			continue
+		}
+		roots = append(roots, expandedPath)
+	}
+	return roots
+}
@@ -0,0 +1,40 @@
+package steam
+
+import (
+	"path/filepath"
+
+	"golang.org/x/sys/windows/registry"
+)
+
+// Roots returns the Steam installation folders found in the Windows registry
+func Roots() []string {
+	type registryValue struct {
+		root  registry.Key
+		path  string
+		value string
+	}
+	values := []registryValue{
+		{root: registry.CURRENT_USER, path: `Software\Valve\Steam`, value: "SteamPath"},
+		{root: registry.LOCAL_MACHINE, path: `SOFTWARE\WOW6432Node\Valve\Steam`, value: "InstallPath"},
+		{root: registry.LOCAL_MACHINE, path: `SOFTWARE\Valve\Steam`, value: "InstallPath"},
+	}
+
+	roots := []string{}
+	for _, v := range values {
+		k, err := registry.OpenKey(v.root, v.path, registry.QUERY_VALUE)
+		if err != nil {
+			continue
+		}
+		path, _, err := k.GetStringValue(v.value)
+		k.Close()
+		if err != nil || path == "" {
+			continue
+		}
+		path = filepath.Clean(path)
+		if !isDir(path) {
+			continue
+		}
+		roots = append(roots, path)
+	}
+	return roots
+}
@@ -0,0 +1,237 @@
+package steam
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// KeyValue is a node of a Valve KeyValues (VDF) document, such as libraryfolders.vdf or appmanifest_*.acf
+type KeyValue struct {
+	Key      string
+	Value    string
+	Children []*KeyValue
+}
+
+// Child returns the first child matching key, case-insensitive, or nil
+func (e *KeyValue) Child(key string) *KeyValue {
+	if e == nil {
+		return nil
+	}
+	for _, child := range e.Children {
+		if strings.EqualFold(child.Key, key) {
+			return child
+		}
+	}
+	return nil
+}
+
+// Get walks children by keys and returns the value found, or an empty string
+func (e *KeyValue) Get(keys ...string) string {
+	node := e
+	for _, key := range keys {
+		node = node.Child(key)
+	}
+	if node == nil {
+		return ""
+	}
+	return node.Value
+}
+
+// ParseVDFFile parses a VDF document at path
+func ParseVDFFile(path string) (*KeyValue, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open: %w", err)
+	}
+	defer f.Close()
+
+	return ParseVDF(f)
+}
+
+// ParseVDF parses a VDF document. The returned node has no key and holds the top level entries as children
+func ParseVDF(r io.Reader) (*KeyValue, error) {
+	p := &vdfParser{r: bufio.NewReader(r), line: 1}
+	root := &KeyValue{}
+	err := p.parseChildren(root, false)
+	if err != nil {
+		return nil, fmt.Errorf("line %d: %w", p.line, err)
+	}
+	return root, nil
+}
+
+type vdfTokenKind int
+
+const (
+	tokenEOF vdfTokenKind = iota
+	tokenString
+	tokenOpen
+	tokenClose
+)
+
+type vdfParser struct {
+	r    *bufio.Reader
+	line int
+}
+
+func (p *vdfParser) parseChildren(parent *KeyValue, isNested bool) error {
+	for {
+		kind, key, err := p.next()
+		if err != nil {
+			return err
+		}
+		switch kind {
+		case tokenEOF:
+			if isNested {
+				return fmt.Errorf("unexpected end of file, missing }")
+			}
+			return nil
+		case tokenClose:
+			if !isNested {
+				return fmt.Errorf("unexpected }")
+			}
+			return nil
+		case tokenOpen:
+			return fmt.Errorf("unexpected {")
+		}
+
+		node := &KeyValue{Key: key}
+		kind, value, err := p.next()
+		if err != nil {
+			return err
+		}
+		switch kind {
+		case tokenString:
+			node.Value = value
+		case tokenOpen:
+			err = p.parseChildren(node, true)
+			if err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("missing value for key %q", key)
+		}
+		parent.Children = append(parent.Children, node)
+	}
+}
+
+// next returns the next token, skipping whitespace, comments and [$CONDITIONAL] markers
+func (p *vdfParser) next() (vdfTokenKind, string, error) {
+	for {
+		c, err := p.readRune()
+		if err == io.EOF {
+			return tokenEOF, "", nil
+		}
+		if err != nil {
+			return tokenEOF, "", err
+		}
+
+		switch {
+		case c == ' ' || c == '\t' || c == '\r' || c == '\n' || c == '\uFEFF':
+			continue
+		case c == '{':
+			return tokenOpen, "", nil
+		case c == '}':
+			return tokenClose, "", nil
+		case c == '"':
+			value, err := p.readQuoted()
+			return tokenString, value, err
+		case c == '/':
+			next, err := p.r.Peek(1)
+			if err == nil && next[0] == '/' {
+				p.skipLine()
+				continue
+			}
+			value, err := p.readBare(c)
+			return tokenString, value, err
+		case c == '[':
+			p.skipUntil(']')
+			continue
+		default:
+			value, err := p.readBare(c)
+			return tokenString, value, err
+		}
+	}
+}
+
+func (p *vdfParser) readRune() (rune, error) {
+	c, _, err := p.r.ReadRune()
+	if c == '\n' {
+		p.line++
+	}
+	return c, err
+}
+
+func (p *vdfParser) readQuoted() (string, error) {
+	sb := strings.Builder{}
+	for {
+		c, err := p.readRune()
+		if err != nil {
+			if err == io.EOF {
+				return "", fmt.Errorf("unterminated string")
+			}
+			return "", err
+		}
+		if c == '"' {
+			return sb.String(), nil
+		}
+		if c != '\\' {
+			sb.WriteRune(c)
+			continue
+		}
+		c, err = p.readRune()
+		if err != nil {
+			return "", fmt.Errorf("unterminated escape")
+		}
+		switch c {
+		case 'n':
+			sb.WriteRune('\n')
+		case 't':
+			sb.WriteRune('\t')
+		default:
+			sb.WriteRune(c)
+		}
+	}
+}
+
+func (p *vdfParser) readBare(first rune) (string, error) {
+	sb := strings.Builder{}
+	sb.WriteRune(first)
+	for {
+		c, _, err := p.r.ReadRune()
+		if err == io.EOF {
+			return sb.String(), nil
+		}
+		if err != nil {
+			return "", err
+		}
+		if c == ' ' || c == '\t' || c == '\r' || c == '\n' || c == '{' || c == '}' || c == '"' {
+			err = p.r.UnreadRune()
+			if err != nil {
+				return "", err
+			}
+			return sb.String(), nil
+		}
+		sb.WriteRune(c)
+	}
+}
+
+func (p *vdfParser) skipLine() {
+	for {
+		c, err := p.readRune()
+		if err != nil || c == '\n' {
+			return
+		}
+	}
+}
+
+func (p *vdfParser) skipUntil(end rune) {
+	for {
+		c, err := p.readRune()
+		if err != nil || c == end {
+			return
+		}
+	}
+}
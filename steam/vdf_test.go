@@ -0,0 +1,57 @@
+package steam
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseVDF(t *testing.T) {
+	doc, err := ParseVDFFile("testdata/appmanifest_205710.acf")
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+
+	if doc.Get("AppState", "appid") != "205710" {
+		t.Fatalf("appid: got %q", doc.Get("AppState", "appid"))
+	}
+	// keys are case-insensitive
+	if doc.Get("appstate", "INSTALLDIR") != "EverQuest" {
+		t.Fatalf("installdir: got %q", doc.Get("appstate", "installdir"))
+	}
+	if doc.Get("AppState", "InstalledDepots", "205711", "manifest") != "5852850381673064693" {
+		t.Fatalf("manifest: got %q", doc.Get("AppState", "InstalledDepots", "205711", "manifest"))
+	}
+	if doc.Get("AppState", "missing", "key") != "" {
+		t.Fatalf("expected empty value for missing key")
+	}
+}
+
+func TestParseVDFEscapes(t *testing.T) {
+	doc, err := ParseVDF(strings.NewReader(`"root" { "path" "C:\\Program Files (x86)\\Steam" "label" "say \"hi\"" [$WIN32] bare value }`))
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if doc.Get("root", "path") != `C:\Program Files (x86)\Steam` {
+		t.Fatalf("path: got %q", doc.Get("root", "path"))
+	}
+	if doc.Get("root", "label") != `say "hi"` {
+		t.Fatalf("label: got %q", doc.Get("root", "label"))
+	}
+	if doc.Get("root", "bare") != "value" {
+		t.Fatalf("bare: got %q", doc.Get("root", "bare"))
+	}
+}
+
+func TestParseVDFErrors(t *testing.T) {
+	for _, input := range []string{
+		`"root" {`,
+		`"root" { "key" }`,
+		`}`,
+		`"unterminated`,
+	} {
+		_, err := ParseVDF(strings.NewReader(input))
+		if err == nil {
+			t.Fatalf("expected error for %q", input)
+		}
+	}
+}
@@ -0,0 +1,417 @@
+// support gathers what is needed to diagnose a player's client into a single zip they can share
+package support
+
+import (
+	"archive/zip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/xackery/rof2plus/checksum"
+	"github.com/xackery/rof2plus/logs"
+	"github.com/xackery/rof2plus/paths"
+	"github.com/xackery/rof2plus/serverlist"
+	"gopkg.in/yaml.v3"
+)
+
+// maxLogSize is how much of the end of each log file is included
+const maxLogSize = 1024 * 1024
+
+// maxReportSize is how much of the last check report is included
+const maxReportSize = 256 * 1024
+
+// Options describes what to gather
+type Options struct {
+	Paths *paths.Paths
+	// Version is the rof2plus version
+	Version string
+	// Server is the short name of the server the player has trouble with, optional
+	Server string
+}
+
+// File is a file of the bundle
+type File struct {
+	// Name is the name inside the zip
+	Name string
+	// Source is where the file came from, redacted
+	Source string
+	// Missing explains why an expected file is not included
+	Missing string
+	data    []byte
+}
+
+func (e *File) String() string {
+	if e.Missing != "" {
+		return fmt.Sprintf("%s: not included, %s", e.Name, e.Missing)
+	}
+	if e.Source == "" {
+		return fmt.Sprintf("%s (%d bytes)", e.Name, len(e.data))
+	}
+	return fmt.Sprintf("%s (%d bytes) from %s", e.Name, len(e.data), e.Source)
+}
+
+// Bundle is the gathered diagnostics, with every home path replaced by ~
+type Bundle struct {
+	Files []*File
+}
+
+// Gather collects the diagnostics described by opts. Files that can not be read are listed as missing
+// rather than failing, a partial bundle is still worth sending
+func Gather(ctx context.Context, opts Options) (*Bundle, error) {
+	p := opts.Paths
+	if p == nil {
+		return nil, fmt.Errorf("paths are required")
+	}
+	b := &Bundle{}
+
+	var server *serverPaths
+	if opts.Server != "" {
+		var err error
+		server, err = resolveServer(p, opts.Server)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	b.add("version.txt", "", []byte(versionText(opts)))
+	b.add("paths.txt", "", []byte(pathsText(p, server)))
+	b.addFile("rof2plus.yaml", p.ConfigFile(), 0)
+
+	if opts.Server == "" {
+		for _, name := range []string{"server.yaml", "check.txt", "listing.txt", "eqhost.txt", "eqclient.ini"} {
+			b.missing(name, "no server was given")
+		}
+	} else {
+		b.addServer(p, opts.Server)
+
+		dir := server.dir
+		text, err := checkText(ctx, server)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			b.missing("check.txt", err.Error())
+		} else {
+			b.add("check.txt", "", []byte(text))
+		}
+
+		text, err = listingText(ctx, dir)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			b.missing("listing.txt", err.Error())
+		} else {
+			b.add("listing.txt", dir, []byte(text))
+		}
+
+		b.addFile("eqhost.txt", filepath.Join(dir, "eqhost.txt"), 0)
+		b.addFile("eqclient.ini", filepath.Join(dir, "eqclient.ini"), 0)
+	}
+
+	logFiles, err := filepath.Glob(filepath.Join(p.LogDir(), "rof2plus*.log"))
+	if err != nil {
+		return nil, fmt.Errorf("glob logs: %w", err)
+	}
+	if len(logFiles) == 0 {
+		b.missing("logs/"+logs.FileName, "no logs were written")
+	}
+	sort.Strings(logFiles)
+	for _, path := range logFiles {
+		b.addFile("logs/"+filepath.Base(path), path, maxLogSize)
+	}
+	return b, nil
+}
+
+// Preview returns a line for each file describing what will be included
+func (b *Bundle) Preview() []string {
+	lines := []string{}
+	for _, file := range b.Files {
+		lines = append(lines, file.String())
+	}
+	return lines
+}
+
+// Write saves the bundle as a zip at path
+func (b *Bundle) Write(path string) error {
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return fmt.Errorf("mkdir: %w", err)
+	}
+	w, err := os.Create(path + ".part")
+	if err != nil {
+		return fmt.Errorf("create: %w", err)
+	}
+	err = b.writeZip(w)
+	closeErr := w.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path + ".part")
+		return err
+	}
+	err = os.Rename(path+".part", path)
+	if err != nil {
+		os.Remove(path + ".part")
+		return fmt.Errorf("rename: %w", err)
+	}
+	return nil
+}
+
+func (b *Bundle) writeZip(w io.Writer) error {
+	zw := zip.NewWriter(w)
+	now := time.Now()
+	for _, file := range b.Files {
+		if file.Missing != "" {
+			continue
+		}
+		fw, err := zw.CreateHeader(&zip.FileHeader{Name: file.Name, Method: zip.Deflate, Modified: now})
+		if err != nil {
+			return fmt.Errorf("zip %s: %w", file.Name, err)
+		}
+		_, err = fw.Write(file.data)
+		if err != nil {
+			return fmt.Errorf("zip %s: %w", file.Name, err)
+		}
+	}
+
+	// the preview is included so whoever reads the bundle knows what was left out
+	manifest := strings.Join(b.Preview(), "\n") + "\n"
+	fw, err := zw.CreateHeader(&zip.FileHeader{Name: "bundle.txt", Method: zip.Deflate, Modified: now})
+	if err != nil {
+		return fmt.Errorf("zip bundle.txt: %w", err)
+	}
+	_, err = fw.Write([]byte(manifest))
+	if err != nil {
+		return fmt.Errorf("zip bundle.txt: %w", err)
+	}
+
+	err = zw.Close()
+	if err != nil {
+		return fmt.Errorf("zip: %w", err)
+	}
+	return nil
+}
+
+func (b *Bundle) add(name string, source string, data []byte) {
+	b.Files = append(b.Files, &File{Name: name, Source: logs.Redact(source), data: []byte(logs.Redact(string(data)))})
+}
+
+func (b *Bundle) missing(name string, reason string) {
+	b.Files = append(b.Files, &File{Name: name, Missing: logs.Redact(reason)})
+}
+
+// addFile adds the file at path, keeping only its last limit bytes when limit is set
+func (b *Bundle) addFile(name string, path string, limit int64) {
+	data, err := readTail(path, limit)
+	if err != nil {
+		if os.IsNotExist(err) {
+			b.missing(name, "not found at "+path)
+			return
+		}
+		b.missing(name, err.Error())
+		return
+	}
+	b.add(name, path, data)
+}
+
+func readTail(path string, limit int64) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if fi.IsDir() {
+		return nil, fmt.Errorf("%s is a directory", path)
+	}
+	offset := int64(0)
+	if limit > 0 && fi.Size() > limit {
+		offset = fi.Size() - limit
+	}
+	data := make([]byte, fi.Size()-offset)
+	_, err = f.ReadAt(data, offset)
+	if err != nil {
+		return nil, fmt.Errorf("read %s: %w", filepath.Base(path), err)
+	}
+	return data, nil
+}
+
+func (b *Bundle) addServer(p *paths.Paths, shortName string) {
+	err := serverlist.Fetch(p.ServerListFile())
+	if err != nil {
+		b.missing("server.yaml", fmt.Sprintf("server list: %v", err))
+		return
+	}
+	entry, err := serverlist.ByShortName(shortName)
+	if err != nil {
+		b.missing("server.yaml", err.Error())
+		return
+	}
+	data, err := yaml.Marshal(entry)
+	if err != nil {
+		b.missing("server.yaml", fmt.Sprintf("encode: %v", err))
+		return
+	}
+	b.add("server.yaml", p.ServerListFile(), data)
+}
+
+// serverPaths are the directories of the server a bundle is for
+type serverPaths struct {
+	shortName  string
+	dir        string
+	cacheDir   string
+	backupDir  string
+	reportFile string
+}
+
+func resolveServer(p *paths.Paths, shortName string) (*serverPaths, error) {
+	server := &serverPaths{shortName: shortName}
+	var err error
+	server.dir, err = p.ServerDir(shortName)
+	if err != nil {
+		return nil, err
+	}
+	server.cacheDir, err = p.ServerCacheDir(shortName)
+	if err != nil {
+		return nil, err
+	}
+	server.backupDir, err = p.BackupDir(shortName)
+	if err != nil {
+		return nil, err
+	}
+	server.reportFile, err = p.CheckReportFile(shortName)
+	if err != nil {
+		return nil, err
+	}
+	return server, nil
+}
+
+func versionText(opts Options) string {
+	text := fmt.Sprintf("rof2plus %s\n", opts.Version)
+	text += fmt.Sprintf("os: %s/%s\n", runtime.GOOS, runtime.GOARCH)
+	text += fmt.Sprintf("go: %s\n", runtime.Version())
+	text += fmt.Sprintf("created: %s\n", time.Now().Format(time.RFC3339))
+	return text
+}
+
+func pathsText(p *paths.Paths, server *serverPaths) string {
+	text := fmt.Sprintf("portable: %t\n", p.IsPortable)
+	text += fmt.Sprintf("config: %s\n", p.Config)
+	text += fmt.Sprintf("data: %s\n", p.Data)
+	text += fmt.Sprintf("cache: %s\n", p.Cache)
+	text += fmt.Sprintf("logs: %s\n", p.LogDir())
+	if server != nil {
+		text += fmt.Sprintf("server: %s\n", server.dir)
+		text += fmt.Sprintf("server cache: %s\n", server.cacheDir)
+		text += fmt.Sprintf("backups: %s\n", server.backupDir)
+	}
+	return text
+}
+
+// checkText describes the cached file list and the check the last patch started with. It reads what
+// patching saved rather than scanning the server directory, which may be in use
+func checkText(ctx context.Context, server *serverPaths) (string, error) {
+	text := ""
+	cached, err := checksum.FetchCachedFilelist(ctx, "", server.cacheDir, true)
+	switch {
+	case err == nil:
+		text += fmt.Sprintf("file list: version %s, patched %s, rolled back %s, fetched %s\n", cached.Version, cached.Meta.PatchedVersion, cached.Meta.RolledBack, cached.Meta.Fetched.Format(time.RFC3339))
+	case errors.Is(err, checksum.ErrNoCachedFilelist):
+		text += "file list: never fetched\n"
+	default:
+		text += fmt.Sprintf("file list: %v\n", err)
+	}
+
+	data, err := readTail(server.reportFile, maxReportSize)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", fmt.Errorf("%s was never checked by a patch", server.shortName)
+		}
+		return "", fmt.Errorf("check report: %w", err)
+	}
+	text += string(data)
+	return text, nil
+}
+
+type dirSummary struct {
+	files int
+	bytes int64
+}
+
+// listingText summarizes dir by its top level entries, so missing folders and empty files stand out
+// without listing thousands of client files
+func listingText(ctx context.Context, dir string) (string, error) {
+	summaries := map[string]*dirSummary{}
+	total := &dirSummary{}
+	empty := []string{}
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if d.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+		top := filepath.ToSlash(rel)
+		if i := strings.Index(top, "/"); i >= 0 {
+			top = top[:i+1]
+		} else {
+			top = "."
+		}
+		summary, ok := summaries[top]
+		if !ok {
+			summary = &dirSummary{}
+			summaries[top] = summary
+		}
+		summary.files++
+		summary.bytes += fi.Size()
+		total.files++
+		total.bytes += fi.Size()
+		if fi.Size() == 0 {
+			empty = append(empty, filepath.ToSlash(rel))
+		}
+		return nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("list %s: %w", dir, err)
+	}
+
+	names := []string{}
+	for name := range summaries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	text := ""
+	for _, name := range names {
+		text += fmt.Sprintf("%8d files %12d bytes %s\n", summaries[name].files, summaries[name].bytes, name)
+	}
+	text += fmt.Sprintf("%8d files %12d bytes total\n", total.files, total.bytes)
+	sort.Strings(empty)
+	for _, name := range empty {
+		text += fmt.Sprintf("empty file: %s\n", name)
+	}
+	return text, nil
+}
@@ -0,0 +1,126 @@
+package support
+
+import (
+	"archive/zip"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/xackery/rof2plus/internal/testfiles"
+	"github.com/xackery/rof2plus/paths"
+)
+
+func TestGather(t *testing.T) {
+	home, err := os.UserHomeDir()
+	if err != nil || len(home) < 2 {
+		t.Skip("no home directory to redact")
+	}
+
+	p := paths.Portable(t.TempDir())
+	homePath := filepath.Join(home, "games", "rof2")
+	testfiles.WriteFile(t, p.ConfigFile(), "version: 1\nrof2path: "+homePath+"\n")
+	testfiles.WriteFile(t, p.ServerListFile(), "entries:\n  - shortname: test\n    name: Test Server\n    loginhost: login.example.com:5998\n")
+	serverDir, err := p.ServerDir("test")
+	if err != nil {
+		t.Fatalf("server dir: %v", err)
+	}
+	reportFile, err := p.CheckReportFile("test")
+	if err != nil {
+		t.Fatalf("report file: %v", err)
+	}
+	testfiles.WriteFile(t, filepath.Join(serverDir, "eqhost.txt"), "[LoginServer]\nHost=login.example.com:5998\n")
+	testfiles.WriteFile(t, filepath.Join(serverDir, "uifiles", "default", "EQUI.xml"), "ui")
+	testfiles.WriteFile(t, reportFile, "checked "+homePath+"\nTotal: 2 OK: 1 Fail: 1\nmaps/qeynos.txt: File not found\n")
+	testfiles.WriteFile(t, filepath.Join(p.LogDir(), "rof2plus.log"), "level=INFO msg=started path="+homePath+"\n")
+
+	b, err := Gather(context.Background(), Options{Paths: p, Version: "1.2.3", Server: "test"})
+	if err != nil {
+		t.Fatalf("gather: %v", err)
+	}
+
+	missing := map[string]bool{}
+	for _, file := range b.Files {
+		if file.Missing != "" {
+			missing[file.Name] = true
+		}
+	}
+	if len(missing) != 1 || !missing["eqclient.ini"] {
+		t.Fatalf("unexpected missing files: %v", b.Preview())
+	}
+
+	path := filepath.Join(t.TempDir(), "bundle.zip")
+	err = b.Write(path)
+	if err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	zr, err := zip.OpenReader(path)
+	if err != nil {
+		t.Fatalf("open zip: %v", err)
+	}
+	defer zr.Close()
+
+	contents := map[string]string{}
+	for _, f := range zr.File {
+		r, err := f.Open()
+		if err != nil {
+			t.Fatalf("open %s: %v", f.Name, err)
+		}
+		data, err := io.ReadAll(r)
+		r.Close()
+		if err != nil {
+			t.Fatalf("read %s: %v", f.Name, err)
+		}
+		if strings.Contains(string(data), home) {
+			t.Fatalf("%s was not redacted: %s", f.Name, data)
+		}
+		contents[f.Name] = string(data)
+	}
+
+	for _, name := range []string{"version.txt", "paths.txt", "rof2plus.yaml", "server.yaml", "check.txt", "listing.txt", "eqhost.txt", "logs/rof2plus.log", "bundle.txt"} {
+		if _, ok := contents[name]; !ok {
+			t.Fatalf("%s not in bundle", name)
+		}
+	}
+	if _, ok := contents["eqclient.ini"]; ok {
+		t.Fatalf("missing eqclient.ini was written")
+	}
+	if !strings.Contains(contents["rof2plus.yaml"], filepath.Join("~", "games", "rof2")) {
+		t.Fatalf("config path not redacted to ~: %s", contents["rof2plus.yaml"])
+	}
+	if !strings.Contains(contents["server.yaml"], "login.example.com:5998") {
+		t.Fatalf("unexpected server entry: %s", contents["server.yaml"])
+	}
+	if !strings.Contains(contents["check.txt"], "file list: never fetched") || !strings.Contains(contents["check.txt"], "maps/qeynos.txt: File not found") {
+		t.Fatalf("unexpected check: %s", contents["check.txt"])
+	}
+	if !strings.Contains(contents["listing.txt"], "uifiles/") {
+		t.Fatalf("unexpected listing: %s", contents["listing.txt"])
+	}
+	if !strings.Contains(contents["bundle.txt"], "eqclient.ini: not included") {
+		t.Fatalf("bundle.txt does not list the missing file: %s", contents["bundle.txt"])
+	}
+}
+
+func TestReadTail(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rof2plus.log")
+	testfiles.WriteFile(t, path, "0123456789")
+
+	data, err := readTail(path, 4)
+	if err != nil {
+		t.Fatalf("read tail: %v", err)
+	}
+	if string(data) != "6789" {
+		t.Fatalf("got %q", data)
+	}
+	data, err = readTail(path, 0)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if string(data) != "0123456789" {
+		t.Fatalf("got %q", data)
+	}
+}